@@ -1,6 +1,9 @@
 package app
 
 import (
+	"context"
+	"errors"
+	"sort"
 	"time"
 
 	"github.com/shopspring/decimal"
@@ -13,10 +16,12 @@ type App struct {
 
 // Config ...
 type Config struct {
-	Cache    Cache
-	Provider Provider
-	Output   Output
-	Log      Log
+	Cache     Cache
+	Provider  Provider
+	Output    Output
+	Store     QuoteStore
+	Converter QuoteConverter
+	Log       Log
 }
 
 // Cache ...
@@ -28,6 +33,12 @@ func (a App) Provider() Provider { return a.Config.Provider }
 // Output ...
 func (a App) Output() Output { return a.Config.Output }
 
+// Store ...
+func (a App) Store() QuoteStore { return a.Config.Store }
+
+// Converter ...
+func (a App) Converter() QuoteConverter { return a.Config.Converter }
+
 // Log ...
 func (a App) Log() Log { return a.Config.Log }
 
@@ -36,28 +47,127 @@ type Quote struct {
 	Time   time.Time
 	Symbol string
 	USD    decimal.Decimal
+	// Currency the quote has been converted to, empty if only denominated in USD.
+	Currency string
+	// Price in the converted Currency.
+	Price decimal.Decimal
+	// RateDate is the day of the exchange rate the Price was converted with, if it was carried forward from an earlier day without a published rate, e.g. a weekend.
+	RateDate time.Time
 }
 
+// Denominated returns the quote's price and the currency it is denominated in.
+func (q Quote) Denominated() (decimal.Decimal, string) {
+	if q.Currency == "" {
+		return q.USD, "USD"
+	}
+	return q.Price, q.Currency
+}
+
+// ErrCacheExists is returned by a Cache when writing data that has already been written by another process.
+var ErrCacheExists = errors.New("cache data already exists")
+
 // Cache caches API data when multiple use-cases are run for the same dataset without having to re-query the source API.
 type Cache interface {
-	ReadSince(time.Time) ([][]byte, error)
-	ReadCurrent() ([]byte, error)
-	WriteCurrent(data []byte) error
+	ReadSince(ctx context.Context, since time.Time) ([][]byte, error)
+	ReadCurrent(ctx context.Context) ([]byte, error)
+	WriteCurrent(ctx context.Context, data []byte) error
+}
+
+// DatedCache is a Cache that can also read and write the data for a specific date.
+type DatedCache interface {
+	Cache
+	ReadDate(ctx context.Context, date time.Time) ([]byte, error)
+	WriteDate(ctx context.Context, date time.Time, data []byte) error
+}
+
+// DayOffset returns the number of UTC calendar days from now to the date, e.g. -1 for any time yesterday, for caches that key data by day.
+func DayOffset(now, date time.Time) int {
+	y, m, d := now.UTC().Date()
+	today := time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+	y, m, d = date.UTC().Date()
+	return int(time.Date(y, m, d, 0, 0, 0, 0, time.UTC).Sub(today).Hours() / 24)
+}
+
+// OrderSymbols returns the expected symbols in order, followed by any other symbols quoted, sorted, for outputs that collect quotes per symbol.
+func OrderSymbols[V any](expected []string, quoted map[string]V) []string {
+	symbols := make([]string, 0, len(quoted))
+	seen := make(map[string]struct{}, len(quoted))
+	for _, symbol := range expected {
+		if _, ok := seen[symbol]; !ok {
+			symbols = append(symbols, symbol)
+			seen[symbol] = struct{}{}
+		}
+	}
+	others := make([]string, 0)
+	for symbol := range quoted {
+		if _, ok := seen[symbol]; !ok {
+			others = append(others, symbol)
+		}
+	}
+	sort.Strings(others)
+	return append(symbols, others...)
+}
+
+// FilterDay returns the day's quotes that keep accepts, or all of them if keep is nil, and the expected symbols without an accepted quote.
+func FilterDay(quotes []Quote, expected []string, keep func(Quote) bool) (kept []Quote, missing []string) {
+	kept = make([]Quote, 0, len(quotes))
+	found := make(map[string]struct{}, len(quotes))
+	for _, q := range quotes {
+		if keep != nil && !keep(q) {
+			continue
+		}
+		kept = append(kept, q)
+		found[q.Symbol] = struct{}{}
+	}
+	for _, symbol := range expected {
+		if _, ok := found[symbol]; !ok {
+			missing = append(missing, symbol)
+		}
+	}
+	return kept, missing
 }
 
 // Provider implements a source provider for retrieving external data.
+// The context is passed on to its requests, and parents their spans when traced.
 type Provider interface {
-	QueryLatest() ([]byte, error)
-	ParseQuotes(data []byte, symbols ...string) ([]Quote, error)
+	QueryLatest(ctx context.Context) ([]byte, error)
+	ParseQuotes(ctx context.Context, data []byte, symbols ...string) ([]Quote, error)
+}
+
+// QuoteStore stores parsed quotes compacted into per-symbol time-series, so they can be read without re-parsing raw source data.
+type QuoteStore interface {
+	// Compacted returns the date up to which all the given symbols have been compacted, or the zero time if any have not.
+	Compacted(symbols ...string) (time.Time, error)
+	WriteQuotes(until time.Time, symbols []string, quotes []Quote) error
+	// ReadSince calls each with the quotes for the given symbols for each day since the given time, most recent day first, stopping at the first error.
+	ReadSince(since time.Time, symbols []string, each func(quotes []Quote) error) error
 }
 
-// Log interface.
+// QuoteConverter re-denominates quotes into another currency.
+type QuoteConverter interface {
+	Convert(ctx context.Context, q Quote) (Quote, error)
+}
+
+// Log is a leveled, structured logger, implemented by *slog.Logger. Args are alternating keys and values, or slog.Attr.
 type Log interface {
-	Println(v ...interface{})
-	Printf(format string, v ...interface{})
+	Debug(msg string, args ...any)
+	Info(msg string, args ...any)
+	Warn(msg string, args ...any)
+	Error(msg string, args ...any)
 }
 
-// Output implements an output writer.
+// Output implements a streaming output writer.
 type Output interface {
-	WriteSet(filename string, set [][]Quote, symbols ...string) (map[int][]string, error)
+	// Begin starts writing an output, expecting each day to have quotes for the given symbols.
+	Begin(filename string, symbols ...string) (DayWriter, error)
+}
+
+// DayWriter writes an output one day of quotes at a time.
+type DayWriter interface {
+	// WriteDay writes a day's quotes, returning the expected symbols missing from them.
+	WriteDay(quotes []Quote) (missing []string, err error)
+	// Close finishes the output.
+	Close() error
+	// Abort discards what it can of the output, instead of closing it after a failure.
+	Abort() error
 }
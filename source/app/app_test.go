@@ -1,9 +1,12 @@
 package app_test
 
 import (
+	"context"
+	"testing"
 	"time"
 
 	"github.com/benjohns1/invest-source/app"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 )
 
@@ -11,19 +14,19 @@ type mockCache struct {
 	mock.Mock
 }
 
-func (mc *mockCache) ReadSince(t time.Time) ([][]byte, error) {
+func (mc *mockCache) ReadSince(_ context.Context, t time.Time) ([][]byte, error) {
 	args := mc.Called(t)
 	retB, _ := args.Get(0).([][]byte)
 	return retB, args.Error(1)
 }
 
-func (mc *mockCache) ReadCurrent() ([]byte, error) {
+func (mc *mockCache) ReadCurrent(context.Context) ([]byte, error) {
 	args := mc.Called()
 	retB, _ := args.Get(0).([]byte)
 	return retB, args.Error(1)
 }
 
-func (mc *mockCache) WriteCurrent(data []byte) error {
+func (mc *mockCache) WriteCurrent(_ context.Context, data []byte) error {
 	args := mc.Called(data)
 	return args.Error(0)
 }
@@ -32,13 +35,13 @@ type mockProvider struct {
 	mock.Mock
 }
 
-func (mp *mockProvider) QueryLatest() ([]byte, error) {
+func (mp *mockProvider) QueryLatest(context.Context) ([]byte, error) {
 	args := mp.Called()
 	retB, _ := args.Get(0).([]byte)
 	return retB, args.Error(1)
 }
 
-func (mp *mockProvider) ParseQuotes(data []byte, symbols ...string) ([]app.Quote, error) {
+func (mp *mockProvider) ParseQuotes(_ context.Context, data []byte, symbols ...string) ([]app.Quote, error) {
 	args := mp.Called(data, symbols)
 	retQ, _ := args.Get(0).([]app.Quote)
 	return retQ, args.Error(1)
@@ -46,10 +49,148 @@ func (mp *mockProvider) ParseQuotes(data []byte, symbols ...string) ([]app.Quote
 
 type mockOutput struct {
 	mock.Mock
+	writer *mockDayWriter
 }
 
-func (mo *mockOutput) WriteSet(filename string, quotes [][]app.Quote, symbols ...string) (map[int][]string, error) {
-	args := mo.Called(filename, quotes, symbols)
-	retS, _ := args.Get(0).(map[int][]string)
+func (mo *mockOutput) Begin(filename string, symbols ...string) (app.DayWriter, error) {
+	args := mo.Called(filename, symbols)
+	retW, _ := args.Get(0).(app.DayWriter)
+	return retW, args.Error(1)
+}
+
+type mockDayWriter struct {
+	mock.Mock
+}
+
+func (mw *mockDayWriter) WriteDay(quotes []app.Quote) ([]string, error) {
+	args := mw.Called(quotes)
+	retS, _ := args.Get(0).([]string)
 	return retS, args.Error(1)
 }
+
+func (mw *mockDayWriter) Close() error {
+	args := mw.Called()
+	return args.Error(0)
+}
+
+func (mw *mockDayWriter) Abort() error {
+	args := mw.Called()
+	return args.Error(0)
+}
+
+type mockStore struct {
+	mock.Mock
+}
+
+func (ms *mockStore) Compacted(symbols ...string) (time.Time, error) {
+	args := ms.Called(symbols)
+	retT, _ := args.Get(0).(time.Time)
+	return retT, args.Error(1)
+}
+
+func (ms *mockStore) WriteQuotes(until time.Time, symbols []string, quotes []app.Quote) error {
+	args := ms.Called(until, symbols, quotes)
+	return args.Error(0)
+}
+
+func (ms *mockStore) ReadSince(since time.Time, symbols []string, each func(quotes []app.Quote) error) error {
+	args := ms.Called(since, symbols)
+	retQ, _ := args.Get(0).([][]app.Quote)
+	for _, quotes := range retQ {
+		if err := each(quotes); err != nil {
+			return err
+		}
+	}
+	return args.Error(1)
+}
+
+type mockDatedCache struct {
+	mockCache
+}
+
+func (mc *mockDatedCache) ReadDate(_ context.Context, date time.Time) ([]byte, error) {
+	args := mc.Called(date)
+	retB, _ := args.Get(0).([]byte)
+	return retB, args.Error(1)
+}
+
+func (mc *mockDatedCache) WriteDate(_ context.Context, date time.Time, data []byte) error {
+	args := mc.Called(date, data)
+	return args.Error(0)
+}
+
+type mockConverter struct {
+	mock.Mock
+}
+
+func (mc *mockConverter) Convert(_ context.Context, q app.Quote) (app.Quote, error) {
+	args := mc.Called(q)
+	retQ, _ := args.Get(0).(app.Quote)
+	return retQ, args.Error(1)
+}
+
+func TestDayOffset(t *testing.T) {
+	now := time.Date(2021, time.January, 3, 1, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name string
+		date time.Time
+		want int
+	}{
+		{name: "should be 0 for any time today", date: time.Date(2021, time.January, 3, 23, 59, 0, 0, time.UTC), want: 0},
+		{name: "should be -1 for any time yesterday", date: time.Date(2021, time.January, 2, 23, 59, 0, 0, time.UTC), want: -1},
+		{name: "should count days across a month", date: time.Date(2020, time.December, 31, 0, 0, 0, 0, time.UTC), want: -3},
+		{name: "should be positive for a future date", date: time.Date(2021, time.January, 4, 0, 0, 0, 0, time.UTC), want: 1},
+		{name: "should compare calendar days in UTC", date: time.Date(2021, time.January, 2, 20, 0, 0, 0, time.FixedZone("EST", -5*60*60)), want: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, app.DayOffset(now, tt.date))
+		})
+	}
+}
+
+func TestOrderSymbols(t *testing.T) {
+	quoted := map[string]int{"ETH": 1, "DOGE": 2, "BTC": 3, "ADA": 4}
+	tests := []struct {
+		name     string
+		expected []string
+		want     []string
+	}{
+		{name: "should sort quoted symbols without expected ones", want: []string{"ADA", "BTC", "DOGE", "ETH"}},
+		{name: "should put expected symbols first, in order", expected: []string{"ETH", "BTC"}, want: []string{"ETH", "BTC", "ADA", "DOGE"}},
+		{name: "should include expected symbols that weren't quoted, once", expected: []string{"USDC", "BTC", "USDC"}, want: []string{"USDC", "BTC", "ADA", "DOGE", "ETH"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, app.OrderSymbols(tt.expected, quoted))
+		})
+	}
+}
+
+func TestFilterDay(t *testing.T) {
+	btc, eth, doge := app.Quote{Symbol: "BTC"}, app.Quote{Symbol: "ETH"}, app.Quote{Symbol: "DOGE"}
+	tests := []struct {
+		name        string
+		expected    []string
+		keep        func(app.Quote) bool
+		wantKept    []app.Quote
+		wantMissing []string
+	}{
+		{name: "should keep every quote without a filter", expected: []string{"BTC", "ETH"}, wantKept: []app.Quote{btc, doge, eth}},
+		{name: "should report expected symbols without a quote, in order", expected: []string{"USDC", "BTC", "ADA"}, wantKept: []app.Quote{btc, doge, eth}, wantMissing: []string{"USDC", "ADA"}},
+		{
+			name:        "should report expected symbols whose quotes were filtered out",
+			expected:    []string{"BTC", "ETH"},
+			keep:        func(q app.Quote) bool { return q.Symbol != "ETH" },
+			wantKept:    []app.Quote{btc, doge},
+			wantMissing: []string{"ETH"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kept, missing := app.FilterDay([]app.Quote{btc, doge, eth}, tt.expected, tt.keep)
+			assert.Equal(t, tt.wantKept, kept)
+			assert.Equal(t, tt.wantMissing, missing)
+		})
+	}
+}
@@ -2,6 +2,7 @@ package app
 
 import (
 	"context"
+	"errors"
 )
 
 // CacheDailySourceDataDeps application dependencies for CacheDailySourceData use-case.
@@ -12,29 +13,33 @@ type CacheDailySourceDataDeps interface {
 }
 
 // CacheDailySourceData retrieves the daily prices for the source if it hasn't already, and caches the data.
-func CacheDailySourceData(_ context.Context, a CacheDailySourceDataDeps) error {
-	data, err := a.Cache().ReadCurrent()
+func CacheDailySourceData(ctx context.Context, a CacheDailySourceDataDeps) error {
+	data, err := a.Cache().ReadCurrent(ctx)
 	if err != nil {
 		return err
 	}
 
 	if data != nil {
-		a.Log().Println("daily cache file found")
+		a.Log().Info("daily cache found")
 		return nil
 	}
 
-	a.Log().Println("no daily cache file found, retrieving from API")
+	a.Log().Info("no daily cache found, retrieving from API")
 
-	data, err = a.Provider().QueryLatest()
+	data, err = a.Provider().QueryLatest(ctx)
 	if err != nil {
 		return err
 	}
 
-	if err := a.Cache().WriteCurrent(data); err != nil {
+	if err := a.Cache().WriteCurrent(ctx, data); err != nil {
+		if errors.Is(err, ErrCacheExists) {
+			a.Log().Warn("daily cache was written concurrently, keeping existing data", "error", err)
+			return nil
+		}
 		return err
 	}
 
-	a.Log().Printf("cached %d bytes", len(data))
+	a.Log().Info("cached daily source data", "bytes", len(data))
 
 	return nil
 }
@@ -3,7 +3,7 @@ package app_test
 import (
 	"context"
 	"fmt"
-	"log"
+	"log/slog"
 	"os"
 	"testing"
 
@@ -69,6 +69,24 @@ func TestApp_CacheDailySourceData(t *testing.T) {
 			}},
 			wantErr: true,
 		},
+		{
+			name: "should succeed if cache WriteCurrent() returns an error because the data was written concurrently",
+			app: app.App{Config: app.Config{
+				Cache: func() app.Cache {
+					c := mockCache{}
+					c.On("ReadCurrent").Return(nil, nil)
+					c.On("WriteCurrent", []byte("query data response")).Return(fmt.Errorf("%w: key exists", app.ErrCacheExists))
+					return &c
+				}(),
+				Provider: func() app.Provider {
+					p := mockProvider{}
+					p.On("QueryLatest").Return([]byte("query data response"), nil)
+					return &p
+				}(),
+				Output: &mockOutput{},
+			}},
+			wantErr: false,
+		},
 		{
 			name: "should succeed if cache ReadCurrent() returns data",
 			app: app.App{Config: app.Config{
@@ -107,7 +125,7 @@ func TestApp_CacheDailySourceData(t *testing.T) {
 				tt.args.ctx = context.Background()
 			}
 			if tt.app.Config.Log == nil {
-				tt.app.Config.Log = log.New(os.Stdout, "test: ", log.LstdFlags)
+				tt.app.Config.Log = slog.New(slog.NewTextHandler(os.Stdout, nil))
 			}
 			err := app.CacheDailySourceData(tt.args.ctx, tt.app)
 			if tt.wantErr {
@@ -0,0 +1,112 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// CompactDailyQuotesDeps application dependencies for CompactDailyQuotes use-case.
+type CompactDailyQuotesDeps interface {
+	Cache() Cache
+	Provider() Provider
+	Store() QuoteStore
+	Log() Log
+}
+
+// CompactDailyQuotes parses the cached source data that hasn't been compacted yet, and writes the quotes for the given symbols to the time-series store.
+func CompactDailyQuotes(ctx context.Context, a CompactDailyQuotesDeps, symbols []string) error {
+	if len(symbols) == 0 {
+		return fmt.Errorf("at least one symbol is required for compaction")
+	}
+
+	from, err := a.Store().Compacted(symbols...)
+	if err != nil {
+		return err
+	}
+
+	until := truncateDay(Now().UTC())
+	if !from.Before(until) {
+		a.Log().Info("quotes already compacted", "until", from.Format(DateFormat))
+		return nil
+	}
+
+	if c, ok := a.Cache().(DatedCache); ok {
+		return compactByDate(ctx, a, c, from, until, symbols)
+	}
+
+	set, err := a.Cache().ReadSince(ctx, from)
+	if err != nil {
+		return err
+	}
+
+	a.Log().Info("compacting cached data", "entries", len(set), "since", from.Format(DateFormat))
+
+	var quotes []Quote
+	for _, data := range set {
+		q, err := a.Provider().ParseQuotes(ctx, data, symbols...)
+		if err != nil {
+			return err
+		}
+		quotes = append(quotes, q...)
+	}
+
+	if err := a.Store().WriteQuotes(until, symbols, quotes); err != nil {
+		return err
+	}
+
+	a.Log().Info("compacted quotes", "quotes", len(quotes), "until", until.Format(DateFormat))
+
+	return nil
+}
+
+// compactByDate parses the cached data one date at a time, writing each year's quotes once parsed, so that only a year of quotes is held at once.
+func compactByDate(ctx context.Context, a CompactDailyQuotesDeps, c DatedCache, from, until time.Time, symbols []string) error {
+	start := truncateDay(from.UTC())
+	if start.Before(OldestCacheDate) {
+		start = OldestCacheDate
+	}
+	a.Log().Info("compacting cached data one date at a time", "since", start.Format(DateFormat))
+
+	var quotes []Quote
+	entries, total := 0, 0
+	write := func(until time.Time) error {
+		if err := a.Store().WriteQuotes(until, symbols, quotes); err != nil {
+			return err
+		}
+		total += len(quotes)
+		quotes = nil
+		return nil
+	}
+	for date := start; !date.After(until); date = date.AddDate(0, 0, 1) {
+		if date.YearDay() == 1 && len(quotes) > 0 {
+			if err := write(date); err != nil {
+				return err
+			}
+		}
+		data, err := c.ReadDate(ctx, date)
+		if err != nil {
+			return err
+		}
+		if data == nil {
+			continue
+		}
+		q, err := a.Provider().ParseQuotes(ctx, data, symbols...)
+		if err != nil {
+			return err
+		}
+		entries++
+		quotes = append(quotes, q...)
+	}
+	if err := write(until); err != nil {
+		return err
+	}
+
+	a.Log().Info("compacted quotes", "entries", entries, "quotes", total, "until", until.Format(DateFormat))
+
+	return nil
+}
+
+func truncateDay(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+}
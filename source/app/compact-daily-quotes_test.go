@@ -0,0 +1,254 @@
+package app_test
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/benjohns1/invest-source/app"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestApp_CompactDailyQuotes(t *testing.T) {
+	now, _ := time.Parse("2006-01-02", "2021-06-21")
+	app.Now = func() time.Time {
+		return now
+	}
+	lastCompacted, _ := time.Parse("2006-01-02", "2021-06-19")
+	type args struct {
+		ctx     context.Context
+		symbols []string
+	}
+	tests := []struct {
+		name    string
+		app     app.App
+		args    args
+		wantErr bool
+	}{
+		{
+			name: "should fail if no symbols are given",
+			app: app.App{app.Config{
+				Cache:    &mockCache{},
+				Provider: &mockProvider{},
+				Store:    &mockStore{},
+			}},
+			wantErr: true,
+		},
+		{
+			name: "should fail if store Compacted() returns an error",
+			app: app.App{app.Config{
+				Cache:    &mockCache{},
+				Provider: &mockProvider{},
+				Store: func() app.QuoteStore {
+					s := mockStore{}
+					s.On("Compacted", []string{"BTC"}).Return(nil, fmt.Errorf("store error"))
+					return &s
+				}(),
+			}},
+			args:    args{symbols: []string{"BTC"}},
+			wantErr: true,
+		},
+		{
+			name: "should succeed without reading the cache if already compacted until today",
+			app: app.App{app.Config{
+				Cache:    &mockCache{},
+				Provider: &mockProvider{},
+				Store: func() app.QuoteStore {
+					s := mockStore{}
+					s.On("Compacted", []string{"BTC"}).Return(now, nil)
+					return &s
+				}(),
+			}},
+			args:    args{symbols: []string{"BTC"}},
+			wantErr: false,
+		},
+		{
+			name: "should fail if cache ReadSince() returns an error",
+			app: app.App{app.Config{
+				Cache: func() app.Cache {
+					c := mockCache{}
+					c.On("ReadSince", lastCompacted).Return(nil, fmt.Errorf("read cache error"))
+					return &c
+				}(),
+				Provider: &mockProvider{},
+				Store: func() app.QuoteStore {
+					s := mockStore{}
+					s.On("Compacted", []string{"BTC"}).Return(lastCompacted, nil)
+					return &s
+				}(),
+			}},
+			args:    args{symbols: []string{"BTC"}},
+			wantErr: true,
+		},
+		{
+			name: "should fail if provider ParseQuotes() returns an error",
+			app: app.App{app.Config{
+				Cache: func() app.Cache {
+					c := mockCache{}
+					c.On("ReadSince", lastCompacted).Return([][]byte{[]byte("{}")}, nil)
+					return &c
+				}(),
+				Provider: func() app.Provider {
+					p := mockProvider{}
+					p.On("ParseQuotes", []byte("{}"), []string{"BTC"}).Return(nil, fmt.Errorf("provider parsing error"))
+					return &p
+				}(),
+				Store: func() app.QuoteStore {
+					s := mockStore{}
+					s.On("Compacted", []string{"BTC"}).Return(lastCompacted, nil)
+					return &s
+				}(),
+			}},
+			args:    args{symbols: []string{"BTC"}},
+			wantErr: true,
+		},
+		{
+			name: "should fail if store WriteQuotes() returns an error",
+			app: app.App{app.Config{
+				Cache: func() app.Cache {
+					c := mockCache{}
+					c.On("ReadSince", lastCompacted).Return([][]byte{[]byte("{}")}, nil)
+					return &c
+				}(),
+				Provider: func() app.Provider {
+					p := mockProvider{}
+					p.On("ParseQuotes", []byte("{}"), []string{"BTC"}).Return([]app.Quote{{Symbol: "BTC"}}, nil)
+					return &p
+				}(),
+				Store: func() app.QuoteStore {
+					s := mockStore{}
+					s.On("Compacted", []string{"BTC"}).Return(lastCompacted, nil)
+					s.On("WriteQuotes", now, []string{"BTC"}, []app.Quote{{Symbol: "BTC"}}).Return(fmt.Errorf("store error"))
+					return &s
+				}(),
+			}},
+			args:    args{symbols: []string{"BTC"}},
+			wantErr: true,
+		},
+		{
+			name: "should succeed writing parsed quotes from all cache entries since the last compaction",
+			app: app.App{app.Config{
+				Cache: func() app.Cache {
+					c := mockCache{}
+					c.On("ReadSince", lastCompacted).Return([][]byte{[]byte("day1"), []byte("day2")}, nil)
+					return &c
+				}(),
+				Provider: func() app.Provider {
+					p := mockProvider{}
+					p.On("ParseQuotes", []byte("day1"), []string{"BTC"}).Return([]app.Quote{{Symbol: "BTC"}}, nil)
+					p.On("ParseQuotes", []byte("day2"), []string{"BTC"}).Return([]app.Quote{}, nil)
+					return &p
+				}(),
+				Store: func() app.QuoteStore {
+					s := mockStore{}
+					s.On("Compacted", []string{"BTC"}).Return(lastCompacted, nil)
+					s.On("WriteQuotes", now, []string{"BTC"}, []app.Quote{{Symbol: "BTC"}}).Return(nil)
+					return &s
+				}(),
+			}},
+			args:    args{symbols: []string{"BTC"}},
+			wantErr: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.args.ctx == nil {
+				tt.args.ctx = context.Background()
+			}
+			if tt.app.Config.Log == nil {
+				tt.app.Config.Log = slog.New(slog.NewTextHandler(os.Stdout, nil))
+			}
+			err := app.CompactDailyQuotes(tt.args.ctx, tt.app, tt.args.symbols)
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+			if c, ok := tt.app.Config.Cache.(*mockCache); ok {
+				c.AssertExpectations(t)
+			}
+			if p, ok := tt.app.Config.Provider.(*mockProvider); ok {
+				p.AssertExpectations(t)
+			}
+			if s, ok := tt.app.Config.Store.(*mockStore); ok {
+				s.AssertExpectations(t)
+			}
+		})
+	}
+}
+
+func TestApp_CompactDailyQuotes_datedCache(t *testing.T) {
+	now := time.Date(2022, time.January, 2, 12, 0, 0, 0, time.UTC)
+	app.Now = func() time.Time {
+		return now
+	}
+	lastCompacted := time.Date(2021, time.December, 30, 0, 0, 0, 0, time.UTC)
+	dec30 := app.Quote{Symbol: "BTC", Time: lastCompacted}
+	jan1 := app.Quote{Symbol: "BTC", Time: time.Date(2022, time.January, 1, 0, 0, 0, 0, time.UTC)}
+	tests := []struct {
+		name    string
+		app     app.App
+		wantErr bool
+	}{
+		{
+			name: "should fail if cache ReadDate() returns an error",
+			app: app.App{app.Config{
+				Cache: func() app.Cache {
+					c := mockDatedCache{}
+					c.On("ReadDate", lastCompacted).Return(nil, fmt.Errorf("read cache error"))
+					return &c
+				}(),
+				Provider: &mockProvider{},
+				Store: func() app.QuoteStore {
+					s := mockStore{}
+					s.On("Compacted", []string{"BTC"}).Return(lastCompacted, nil)
+					return &s
+				}(),
+			}},
+			wantErr: true,
+		},
+		{
+			name: "should write each year's quotes as it's parsed, one date at a time",
+			app: app.App{app.Config{
+				Cache: func() app.Cache {
+					c := mockDatedCache{}
+					c.On("ReadDate", lastCompacted).Return([]byte("day30"), nil)
+					c.On("ReadDate", time.Date(2021, time.December, 31, 0, 0, 0, 0, time.UTC)).Return(nil, nil)
+					c.On("ReadDate", jan1.Time).Return([]byte("day1"), nil)
+					c.On("ReadDate", time.Date(2022, time.January, 2, 0, 0, 0, 0, time.UTC)).Return(nil, nil)
+					return &c
+				}(),
+				Provider: func() app.Provider {
+					p := mockProvider{}
+					p.On("ParseQuotes", []byte("day30"), []string{"BTC"}).Return([]app.Quote{dec30}, nil)
+					p.On("ParseQuotes", []byte("day1"), []string{"BTC"}).Return([]app.Quote{jan1}, nil)
+					return &p
+				}(),
+				Store: func() app.QuoteStore {
+					s := mockStore{}
+					s.On("Compacted", []string{"BTC"}).Return(lastCompacted, nil)
+					s.On("WriteQuotes", jan1.Time, []string{"BTC"}, []app.Quote{dec30}).Return(nil).Once()
+					s.On("WriteQuotes", time.Date(2022, time.January, 2, 0, 0, 0, 0, time.UTC), []string{"BTC"}, []app.Quote{jan1}).Return(nil).Once()
+					return &s
+				}(),
+			}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.app.Config.Log = slog.New(slog.NewTextHandler(os.Stdout, nil))
+			err := app.CompactDailyQuotes(context.Background(), tt.app, []string{"BTC"})
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+			tt.app.Config.Cache.(*mockDatedCache).AssertExpectations(t)
+			tt.app.Config.Provider.(*mockProvider).AssertExpectations(t)
+			tt.app.Config.Store.(*mockStore).AssertExpectations(t)
+		})
+	}
+}
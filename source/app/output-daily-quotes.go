@@ -17,11 +17,13 @@ type OutputDailyQuotesDeps interface {
 	Cache() Cache
 	Provider() Provider
 	Output() Output
+	Store() QuoteStore
+	Converter() QuoteConverter
 	Log() Log
 }
 
-// OutputDailyQuotes outputs the daily quotes since the last output, using cached source data.
-func OutputDailyQuotes(_ context.Context, a OutputDailyQuotesDeps, since string, symbols []string) error {
+// OutputDailyQuotes outputs the daily quotes since the last output, using compacted quotes when available or cached source data otherwise.
+func OutputDailyQuotes(ctx context.Context, a OutputDailyQuotesDeps, since string, symbols []string) error {
 	var sinceDate time.Time
 	if since != "" {
 		var err error
@@ -31,28 +33,133 @@ func OutputDailyQuotes(_ context.Context, a OutputDailyQuotesDeps, since string,
 		sinceDate = sinceDate.UTC()
 	}
 
-	set, err := a.Cache().ReadSince(sinceDate)
+	days, err := readDailyQuotes(ctx, a, sinceDate, symbols)
 	if err != nil {
 		return err
 	}
 
-	a.Log().Printf("retrieved %d entries of cached data since %s", len(set), sinceDate.Format(DateFormat))
+	filename := fmt.Sprintf("%s_to_%s.csv", sinceDate.Format(DateFormat), Now().UTC().Format(DateFormat))
+	w, err := a.Output().Begin(filename, symbols...)
+	if err != nil {
+		return err
+	}
+	missing := make(map[int][]string)
+	carried := make(map[string]string)
+	n, err := writeDailyQuotes(ctx, a, w, days, missing, carried)
+	if len(missing) > 0 {
+		a.Log().Warn("missing symbols from output", "missing", missing)
+	}
+	if len(carried) > 0 {
+		a.Log().Warn("converted with exchange rates carried forward from an earlier day", "rateDates", carried)
+	}
+	if err != nil {
+		if abortErr := w.Abort(); abortErr != nil {
+			a.Log().Error("error aborting output", "error", abortErr)
+		}
+		return err
+	}
 
-	quotes := make([][]Quote, len(set))
-	for i, data := range set {
-		var err error
-		if quotes[i], err = a.Provider().ParseQuotes(data, symbols...); err != nil {
-			return err
+	if err := w.Close(); err != nil {
+		return err
+	}
+	a.Log().Info("wrote output", "days", n)
+	return nil
+}
+
+// writeDailyQuotes reads, converts and writes one day at a time, recording the symbols missing from each day, and the earlier day's exchange rate used for each day converted without its own. Returns the number of days written.
+func writeDailyQuotes(ctx context.Context, a OutputDailyQuotesDeps, w DayWriter, days dailyQuotes, missing map[int][]string, carried map[string]string) (int, error) {
+	i := 0
+	err := days(func(quotes []Quote) error {
+		if c := a.Converter(); c != nil {
+			for j, q := range quotes {
+				var err error
+				if quotes[j], err = c.Convert(ctx, q); err != nil {
+					return err
+				}
+				if rateDate := quotes[j].RateDate; !rateDate.IsZero() {
+					carried[q.Time.UTC().Format(DateFormat)] = rateDate.Format(DateFormat)
+				}
+			}
+		}
+		m, err := w.WriteDay(quotes)
+		if len(m) > 0 {
+			missing[i] = m
+		}
+		i++
+		return err
+	})
+	return i, err
+}
+
+// OldestCacheDate is the earliest date a DatedCache is read back to, matching the caches' own floor for reading since a zero time.
+var OldestCacheDate = time.Date(2021, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// dailyQuotes calls each with one day's quotes at a time, most recent day first, so that only a single day is read and parsed at once.
+type dailyQuotes func(each func(quotes []Quote) error) error
+
+// readDailyQuotes reads the daily quotes from the time-series store if it has been compacted up to today, otherwise it falls back to parsing the raw cached source data.
+// Cached data is read one date at a time from a DatedCache, or all at once from any other Cache.
+func readDailyQuotes(ctx context.Context, a OutputDailyQuotesDeps, sinceDate time.Time, symbols []string) (dailyQuotes, error) {
+	if store := a.Store(); store != nil && len(symbols) > 0 {
+		until, err := store.Compacted(symbols...)
+		if err != nil {
+			return nil, err
 		}
+		if !until.Before(truncateDay(Now().UTC())) {
+			a.Log().Info("reading compacted quotes", "since", sinceDate.Format(DateFormat))
+			return func(each func(quotes []Quote) error) error {
+				return store.ReadSince(sinceDate, symbols, each)
+			}, nil
+		}
+		a.Log().Info("compacted quotes are out of date, falling back to cached data", "until", until.Format(DateFormat))
 	}
 
-	a.Log().Printf("writing output")
+	if c, ok := a.Cache().(DatedCache); ok {
+		a.Log().Info("reading cached data one date at a time", "since", sinceDate.Format(DateFormat))
+		return func(each func(quotes []Quote) error) error {
+			from := sinceDate
+			if from.Before(OldestCacheDate) {
+				from = OldestCacheDate
+			}
+			for date := truncateDay(Now().UTC()); !date.Before(truncateDay(from)); date = date.AddDate(0, 0, -1) {
+				data, err := c.ReadDate(ctx, date)
+				if err != nil {
+					return err
+				}
+				if data == nil {
+					continue
+				}
+				quotes, err := a.Provider().ParseQuotes(ctx, data, symbols...)
+				if err != nil {
+					return err
+				}
+				if err := each(quotes); err != nil {
+					return err
+				}
+			}
+			return nil
+		}, nil
+	}
 
-	filename := fmt.Sprintf("%s_to_%s.csv", sinceDate.Format(DateFormat), Now().UTC().Format(DateFormat))
-	missing, err := a.Output().WriteSet(filename, quotes, symbols...)
-	if len(missing) > 0 {
-		a.Log().Printf("missing symbols from output: %v", missing)
+	set, err := a.Cache().ReadSince(ctx, sinceDate)
+	if err != nil {
+		return nil, err
 	}
 
-	return err
+	a.Log().Info("retrieved cached data", "entries", len(set), "since", sinceDate.Format(DateFormat))
+
+	return func(each func(quotes []Quote) error) error {
+		for day := range set {
+			quotes, err := a.Provider().ParseQuotes(ctx, set[day], symbols...)
+			if err != nil {
+				return err
+			}
+			// release the raw data once parsed
+			set[day] = nil
+			if err := each(quotes); err != nil {
+				return err
+			}
+		}
+		return nil
+	}, nil
 }
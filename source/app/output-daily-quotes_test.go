@@ -3,7 +3,7 @@ package app_test
 import (
 	"context"
 	"fmt"
-	"log"
+	"log/slog"
 	"os"
 	"testing"
 	"time"
@@ -55,8 +55,11 @@ func TestApp_OutputDailyQuotes(t *testing.T) {
 					return &p
 				}(),
 				Output: func() app.Output {
-					o := mockOutput{}
-					o.On("WriteSet", "0001-01-01_to_2021-06-21.csv", [][]app.Quote{{}}, []string(nil)).Return(nil, nil)
+					w := mockDayWriter{}
+					w.On("WriteDay", []app.Quote{}).Return(nil, nil)
+					w.On("Close").Return(nil)
+					o := mockOutput{writer: &w}
+					o.On("Begin", "0001-01-01_to_2021-06-21.csv", []string(nil)).Return(&w, nil)
 					return &o
 				}(),
 			}},
@@ -75,12 +78,18 @@ func TestApp_OutputDailyQuotes(t *testing.T) {
 					p.On("ParseQuotes", []byte("{}"), []string(nil)).Return(nil, fmt.Errorf("provider parsing error"))
 					return &p
 				}(),
-				Output: &mockOutput{},
+				Output: func() app.Output {
+					w := mockDayWriter{}
+					w.On("Abort").Return(nil)
+					o := mockOutput{writer: &w}
+					o.On("Begin", "0001-01-01_to_2021-06-21.csv", []string(nil)).Return(&w, nil)
+					return &o
+				}(),
 			}},
 			wantErr: true,
 		},
 		{
-			name: "should fail if output WriteSet() returns an error",
+			name: "should fail if output WriteDay() returns an error",
 			app: app.App{app.Config{
 				Cache: func() app.Cache {
 					c := mockCache{}
@@ -92,9 +101,256 @@ func TestApp_OutputDailyQuotes(t *testing.T) {
 					p.On("ParseQuotes", []byte("{}"), []string(nil)).Return([]app.Quote{}, nil)
 					return &p
 				}(),
+				Output: func() app.Output {
+					w := mockDayWriter{}
+					w.On("WriteDay", []app.Quote{}).Return(nil, fmt.Errorf("output writer error"))
+					w.On("Abort").Return(nil)
+					o := mockOutput{writer: &w}
+					o.On("Begin", "0001-01-01_to_2021-06-21.csv", []string(nil)).Return(&w, nil)
+					return &o
+				}(),
+			}},
+			wantErr: true,
+		},
+		{
+			name: "should fail if output Begin() returns an error",
+			app: app.App{app.Config{
+				Cache: func() app.Cache {
+					c := mockCache{}
+					c.On("ReadSince", time.Time{}).Return([][]byte{[]byte("{}")}, nil)
+					return &c
+				}(),
+				Provider: &mockProvider{},
 				Output: func() app.Output {
 					o := mockOutput{}
-					o.On("WriteSet", "0001-01-01_to_2021-06-21.csv", [][]app.Quote{{}}, []string(nil)).Return(nil, fmt.Errorf("output writer error"))
+					o.On("Begin", "0001-01-01_to_2021-06-21.csv", []string(nil)).Return(nil, fmt.Errorf("output open error"))
+					return &o
+				}(),
+			}},
+			wantErr: true,
+		},
+		{
+			name: "should fail if output Close() returns an error",
+			app: app.App{app.Config{
+				Cache: func() app.Cache {
+					c := mockCache{}
+					c.On("ReadSince", time.Time{}).Return([][]byte{}, nil)
+					return &c
+				}(),
+				Provider: &mockProvider{},
+				Output: func() app.Output {
+					w := mockDayWriter{}
+					w.On("Close").Return(fmt.Errorf("output close error"))
+					o := mockOutput{writer: &w}
+					o.On("Begin", "0001-01-01_to_2021-06-21.csv", []string(nil)).Return(&w, nil)
+					return &o
+				}(),
+			}},
+			wantErr: true,
+		},
+		{
+			name: "should parse and write each cached day in turn",
+			app: app.App{app.Config{
+				Cache: func() app.Cache {
+					c := mockCache{}
+					c.On("ReadSince", time.Time{}).Return([][]byte{[]byte("day1"), []byte("day2")}, nil)
+					return &c
+				}(),
+				Provider: func() app.Provider {
+					p := mockProvider{}
+					p.On("ParseQuotes", []byte("day1"), []string{"BTC"}).Return([]app.Quote{{Symbol: "BTC"}}, nil).Once()
+					p.On("ParseQuotes", []byte("day2"), []string{"BTC"}).Return([]app.Quote{}, nil).Once()
+					return &p
+				}(),
+				Output: func() app.Output {
+					w := mockDayWriter{}
+					w.On("WriteDay", []app.Quote{{Symbol: "BTC"}}).Return(nil, nil).Once()
+					w.On("WriteDay", []app.Quote{}).Return([]string{"BTC"}, nil).Once()
+					w.On("Close").Return(nil)
+					o := mockOutput{writer: &w}
+					o.On("Begin", "0001-01-01_to_2021-06-21.csv", []string{"BTC"}).Return(&w, nil)
+					return &o
+				}(),
+			}},
+			args:    args{symbols: []string{"BTC"}},
+			wantErr: false,
+		},
+		{
+			name: "should succeed reading a dated cache one date at a time, skipping dates without data",
+			app: app.App{app.Config{
+				Cache: func() app.Cache {
+					c := mockDatedCache{}
+					c.On("ReadDate", time.Date(2021, time.June, 21, 0, 0, 0, 0, time.UTC)).Return([]byte("day21"), nil)
+					c.On("ReadDate", time.Date(2021, time.June, 20, 0, 0, 0, 0, time.UTC)).Return(nil, nil)
+					c.On("ReadDate", time.Date(2021, time.June, 19, 0, 0, 0, 0, time.UTC)).Return([]byte("day19"), nil)
+					return &c
+				}(),
+				Provider: func() app.Provider {
+					p := mockProvider{}
+					p.On("ParseQuotes", []byte("day21"), []string{"BTC"}).Return([]app.Quote{{Symbol: "BTC"}}, nil).Once()
+					p.On("ParseQuotes", []byte("day19"), []string{"BTC"}).Return([]app.Quote{}, nil).Once()
+					return &p
+				}(),
+				Output: func() app.Output {
+					w := mockDayWriter{}
+					w.On("WriteDay", []app.Quote{{Symbol: "BTC"}}).Return(nil, nil).Once()
+					w.On("WriteDay", []app.Quote{}).Return([]string{"BTC"}, nil).Once()
+					w.On("Close").Return(nil)
+					o := mockOutput{writer: &w}
+					o.On("Begin", "2021-06-19_to_2021-06-21.csv", []string{"BTC"}).Return(&w, nil)
+					return &o
+				}(),
+			}},
+			args:    args{since: "2021-06-19", symbols: []string{"BTC"}},
+			wantErr: false,
+		},
+		{
+			name: "should fail if dated cache ReadDate() returns an error, aborting the output",
+			app: app.App{app.Config{
+				Cache: func() app.Cache {
+					c := mockDatedCache{}
+					c.On("ReadDate", time.Date(2021, time.June, 21, 0, 0, 0, 0, time.UTC)).Return(nil, fmt.Errorf("read cache error"))
+					return &c
+				}(),
+				Provider: &mockProvider{},
+				Output: func() app.Output {
+					w := mockDayWriter{}
+					w.On("Abort").Return(nil)
+					o := mockOutput{writer: &w}
+					o.On("Begin", "2021-06-19_to_2021-06-21.csv", []string{"BTC"}).Return(&w, nil)
+					return &o
+				}(),
+			}},
+			args:    args{since: "2021-06-19", symbols: []string{"BTC"}},
+			wantErr: true,
+		},
+		{
+			name: "should succeed reading from the store if it has been compacted until today",
+			app: app.App{app.Config{
+				Cache:    &mockCache{},
+				Provider: &mockProvider{},
+				Store: func() app.QuoteStore {
+					s := mockStore{}
+					s.On("Compacted", []string{"BTC"}).Return(app.Now(), nil)
+					s.On("ReadSince", time.Time{}, []string{"BTC"}).Return([][]app.Quote{{{Symbol: "BTC"}}}, nil)
+					return &s
+				}(),
+				Output: func() app.Output {
+					w := mockDayWriter{}
+					w.On("WriteDay", []app.Quote{{Symbol: "BTC"}}).Return(nil, nil)
+					w.On("Close").Return(nil)
+					o := mockOutput{writer: &w}
+					o.On("Begin", "0001-01-01_to_2021-06-21.csv", []string{"BTC"}).Return(&w, nil)
+					return &o
+				}(),
+			}},
+			args:    args{symbols: []string{"BTC"}},
+			wantErr: false,
+		},
+		{
+			name: "should fail if store ReadSince() returns an error",
+			app: app.App{app.Config{
+				Cache:    &mockCache{},
+				Provider: &mockProvider{},
+				Store: func() app.QuoteStore {
+					s := mockStore{}
+					s.On("Compacted", []string{"BTC"}).Return(app.Now(), nil)
+					s.On("ReadSince", time.Time{}, []string{"BTC"}).Return(nil, fmt.Errorf("store error"))
+					return &s
+				}(),
+				Output: func() app.Output {
+					w := mockDayWriter{}
+					w.On("Abort").Return(nil)
+					o := mockOutput{writer: &w}
+					o.On("Begin", "0001-01-01_to_2021-06-21.csv", []string{"BTC"}).Return(&w, nil)
+					return &o
+				}(),
+			}},
+			args:    args{symbols: []string{"BTC"}},
+			wantErr: true,
+		},
+		{
+			name: "should succeed falling back to cached data if the store has not been compacted until today",
+			app: app.App{app.Config{
+				Cache: func() app.Cache {
+					c := mockCache{}
+					c.On("ReadSince", time.Time{}).Return([][]byte{[]byte("{}")}, nil)
+					return &c
+				}(),
+				Provider: func() app.Provider {
+					p := mockProvider{}
+					p.On("ParseQuotes", []byte("{}"), []string{"BTC"}).Return([]app.Quote{{Symbol: "BTC"}}, nil)
+					return &p
+				}(),
+				Store: func() app.QuoteStore {
+					s := mockStore{}
+					s.On("Compacted", []string{"BTC"}).Return(time.Time{}, nil)
+					return &s
+				}(),
+				Output: func() app.Output {
+					w := mockDayWriter{}
+					w.On("WriteDay", []app.Quote{{Symbol: "BTC"}}).Return(nil, nil)
+					w.On("Close").Return(nil)
+					o := mockOutput{writer: &w}
+					o.On("Begin", "0001-01-01_to_2021-06-21.csv", []string{"BTC"}).Return(&w, nil)
+					return &o
+				}(),
+			}},
+			args:    args{symbols: []string{"BTC"}},
+			wantErr: false,
+		},
+		{
+			name: "should succeed converting each quote before writing output",
+			app: app.App{app.Config{
+				Cache: func() app.Cache {
+					c := mockCache{}
+					c.On("ReadSince", time.Time{}).Return([][]byte{[]byte("{}")}, nil)
+					return &c
+				}(),
+				Provider: func() app.Provider {
+					p := mockProvider{}
+					p.On("ParseQuotes", []byte("{}"), []string(nil)).Return([]app.Quote{{Symbol: "BTC"}}, nil)
+					return &p
+				}(),
+				Converter: func() app.QuoteConverter {
+					c := mockConverter{}
+					c.On("Convert", app.Quote{Symbol: "BTC"}).Return(app.Quote{Symbol: "BTC", Currency: "EUR"}, nil)
+					return &c
+				}(),
+				Output: func() app.Output {
+					w := mockDayWriter{}
+					w.On("WriteDay", []app.Quote{{Symbol: "BTC", Currency: "EUR"}}).Return(nil, nil)
+					w.On("Close").Return(nil)
+					o := mockOutput{writer: &w}
+					o.On("Begin", "0001-01-01_to_2021-06-21.csv", []string(nil)).Return(&w, nil)
+					return &o
+				}(),
+			}},
+			wantErr: false,
+		},
+		{
+			name: "should fail if converter Convert() returns an error",
+			app: app.App{app.Config{
+				Cache: func() app.Cache {
+					c := mockCache{}
+					c.On("ReadSince", time.Time{}).Return([][]byte{[]byte("{}")}, nil)
+					return &c
+				}(),
+				Provider: func() app.Provider {
+					p := mockProvider{}
+					p.On("ParseQuotes", []byte("{}"), []string(nil)).Return([]app.Quote{{Symbol: "BTC"}}, nil)
+					return &p
+				}(),
+				Converter: func() app.QuoteConverter {
+					c := mockConverter{}
+					c.On("Convert", app.Quote{Symbol: "BTC"}).Return(nil, fmt.Errorf("missing rate"))
+					return &c
+				}(),
+				Output: func() app.Output {
+					w := mockDayWriter{}
+					w.On("Abort").Return(nil)
+					o := mockOutput{writer: &w}
+					o.On("Begin", "0001-01-01_to_2021-06-21.csv", []string(nil)).Return(&w, nil)
 					return &o
 				}(),
 			}},
@@ -107,7 +363,7 @@ func TestApp_OutputDailyQuotes(t *testing.T) {
 				tt.args.ctx = context.Background()
 			}
 			if tt.app.Config.Log == nil {
-				tt.app.Config.Log = log.New(os.Stdout, "test: ", log.LstdFlags)
+				tt.app.Config.Log = slog.New(slog.NewTextHandler(os.Stdout, nil))
 			}
 			err := app.OutputDailyQuotes(tt.args.ctx, tt.app, tt.args.since, tt.args.symbols)
 			if tt.wantErr {
@@ -118,11 +374,23 @@ func TestApp_OutputDailyQuotes(t *testing.T) {
 			if c, ok := tt.app.Config.Cache.(*mockCache); ok {
 				c.AssertExpectations(t)
 			}
+			if c, ok := tt.app.Config.Cache.(*mockDatedCache); ok {
+				c.AssertExpectations(t)
+			}
 			if p, ok := tt.app.Config.Provider.(*mockProvider); ok {
 				p.AssertExpectations(t)
 			}
 			if o, ok := tt.app.Config.Output.(*mockOutput); ok {
 				o.AssertExpectations(t)
+				if o.writer != nil {
+					o.writer.AssertExpectations(t)
+				}
+			}
+			if s, ok := tt.app.Config.Store.(*mockStore); ok {
+				s.AssertExpectations(t)
+			}
+			if c, ok := tt.app.Config.Converter.(*mockConverter); ok {
+				c.AssertExpectations(t)
 			}
 		})
 	}
@@ -0,0 +1,109 @@
+package app
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"time"
+)
+
+// SyncDirection to copy cache data between a local and a remote cache.
+type SyncDirection string
+
+const (
+	// SyncUp copies local data to the remote cache, overwriting changed remote data.
+	SyncUp SyncDirection = "up"
+	// SyncDown copies remote data to the local cache, overwriting changed local data.
+	SyncDown SyncDirection = "down"
+	// SyncBoth copies data missing from either cache, and reports changed data as conflicts without overwriting it.
+	SyncBoth SyncDirection = "both"
+)
+
+// SyncCachesDeps application dependencies for SyncCaches use-case.
+type SyncCachesDeps interface {
+	Log() Log
+}
+
+// SyncOptions for the SyncCaches use-case.
+type SyncOptions struct {
+	Since     time.Time
+	Direction SyncDirection
+	DryRun    bool
+}
+
+// SyncResult summarizes the dates synced between caches.
+type SyncResult struct {
+	Uploaded   []time.Time
+	Downloaded []time.Time
+	Conflicts  []time.Time
+	Unchanged  int
+}
+
+// SyncCaches diffs each day's data in the local and remote caches since the given date by checksum, and copies missing or changed data in the given direction.
+func SyncCaches(ctx context.Context, a SyncCachesDeps, local, remote DatedCache, opts SyncOptions) (SyncResult, error) {
+	switch opts.Direction {
+	case SyncUp, SyncDown, SyncBoth:
+	default:
+		return SyncResult{}, fmt.Errorf("invalid sync direction '%s', must be one of %s, %s or %s", opts.Direction, SyncUp, SyncDown, SyncBoth)
+	}
+	if opts.Since.IsZero() {
+		return SyncResult{}, fmt.Errorf("sync 'since' date must be set")
+	}
+
+	var result SyncResult
+	today := truncateDay(Now().UTC())
+	for date := truncateDay(opts.Since.UTC()); !date.After(today); date = date.AddDate(0, 0, 1) {
+		l, err := local.ReadDate(ctx, date)
+		if err != nil {
+			return result, fmt.Errorf("error reading local cache for %s: %v", date.Format(DateFormat), err)
+		}
+		r, err := remote.ReadDate(ctx, date)
+		if err != nil {
+			return result, fmt.Errorf("error reading remote cache for %s: %v", date.Format(DateFormat), err)
+		}
+
+		upload, download := false, false
+		switch {
+		case l == nil && r == nil:
+			continue
+		case r == nil:
+			upload = opts.Direction != SyncDown
+		case l == nil:
+			download = opts.Direction != SyncUp
+		case sha256.Sum256(l) == sha256.Sum256(r):
+			result.Unchanged++
+			continue
+		case opts.Direction == SyncUp:
+			upload = true
+		case opts.Direction == SyncDown:
+			download = true
+		default:
+			a.Log().Warn("conflict, local and remote data differ, skipping", "date", date.Format(DateFormat), "dryRun", opts.DryRun)
+			result.Conflicts = append(result.Conflicts, date)
+			continue
+		}
+
+		if upload {
+			a.Log().Info("uploading", "date", date.Format(DateFormat), "bytes", len(l), "dryRun", opts.DryRun)
+			if !opts.DryRun {
+				if err := remote.WriteDate(ctx, date, l); err != nil {
+					return result, fmt.Errorf("error uploading %s: %v", date.Format(DateFormat), err)
+				}
+			}
+			result.Uploaded = append(result.Uploaded, date)
+		}
+		if download {
+			a.Log().Info("downloading", "date", date.Format(DateFormat), "bytes", len(r), "dryRun", opts.DryRun)
+			if !opts.DryRun {
+				if err := local.WriteDate(ctx, date, r); err != nil {
+					return result, fmt.Errorf("error downloading %s: %v", date.Format(DateFormat), err)
+				}
+			}
+			result.Downloaded = append(result.Downloaded, date)
+		}
+	}
+
+	a.Log().Info("synced caches", "uploaded", len(result.Uploaded), "downloaded", len(result.Downloaded), "conflicts", len(result.Conflicts), "unchanged", result.Unchanged, "dryRun", opts.DryRun)
+
+	return result, nil
+}
@@ -0,0 +1,182 @@
+package app_test
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/benjohns1/invest-source/app"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestApp_SyncCaches(t *testing.T) {
+	day1 := time.Date(2021, time.June, 20, 0, 0, 0, 0, time.UTC)
+	day2 := day1.AddDate(0, 0, 1)
+	app.Now = func() time.Time {
+		return day2.Add(12 * time.Hour)
+	}
+	type args struct {
+		ctx  context.Context
+		opts app.SyncOptions
+	}
+	tests := []struct {
+		name    string
+		local   func() *mockDatedCache
+		remote  func() *mockDatedCache
+		args    args
+		want    app.SyncResult
+		wantErr bool
+	}{
+		{
+			name:    "should fail with an invalid direction",
+			local:   func() *mockDatedCache { return &mockDatedCache{} },
+			remote:  func() *mockDatedCache { return &mockDatedCache{} },
+			args:    args{opts: app.SyncOptions{Since: day1, Direction: "sideways"}},
+			wantErr: true,
+		},
+		{
+			name:    "should fail without a since date",
+			local:   func() *mockDatedCache { return &mockDatedCache{} },
+			remote:  func() *mockDatedCache { return &mockDatedCache{} },
+			args:    args{opts: app.SyncOptions{Direction: app.SyncBoth}},
+			wantErr: true,
+		},
+		{
+			name: "should fail if local ReadDate() returns an error",
+			local: func() *mockDatedCache {
+				c := mockDatedCache{}
+				c.On("ReadDate", day1).Return(nil, fmt.Errorf("read error"))
+				return &c
+			},
+			remote:  func() *mockDatedCache { return &mockDatedCache{} },
+			args:    args{opts: app.SyncOptions{Since: day1, Direction: app.SyncBoth}},
+			wantErr: true,
+		},
+		{
+			name: "should copy missing data in both directions",
+			local: func() *mockDatedCache {
+				c := mockDatedCache{}
+				c.On("ReadDate", day1).Return([]byte("local1"), nil)
+				c.On("ReadDate", day2).Return(nil, nil)
+				c.On("WriteDate", day2, []byte("remote2")).Return(nil)
+				return &c
+			},
+			remote: func() *mockDatedCache {
+				c := mockDatedCache{}
+				c.On("ReadDate", day1).Return(nil, nil)
+				c.On("ReadDate", day2).Return([]byte("remote2"), nil)
+				c.On("WriteDate", day1, []byte("local1")).Return(nil)
+				return &c
+			},
+			args: args{opts: app.SyncOptions{Since: day1, Direction: app.SyncBoth}},
+			want: app.SyncResult{Uploaded: []time.Time{day1}, Downloaded: []time.Time{day2}},
+		},
+		{
+			name: "should not write anything in a dry run",
+			local: func() *mockDatedCache {
+				c := mockDatedCache{}
+				c.On("ReadDate", day1).Return([]byte("local1"), nil)
+				c.On("ReadDate", day2).Return(nil, nil)
+				return &c
+			},
+			remote: func() *mockDatedCache {
+				c := mockDatedCache{}
+				c.On("ReadDate", day1).Return(nil, nil)
+				c.On("ReadDate", day2).Return([]byte("remote2"), nil)
+				return &c
+			},
+			args: args{opts: app.SyncOptions{Since: day1, Direction: app.SyncBoth, DryRun: true}},
+			want: app.SyncResult{Uploaded: []time.Time{day1}, Downloaded: []time.Time{day2}},
+		},
+		{
+			name: "should only upload when syncing up",
+			local: func() *mockDatedCache {
+				c := mockDatedCache{}
+				c.On("ReadDate", day1).Return([]byte("local1"), nil)
+				c.On("ReadDate", day2).Return(nil, nil)
+				return &c
+			},
+			remote: func() *mockDatedCache {
+				c := mockDatedCache{}
+				c.On("ReadDate", day1).Return([]byte("changed1"), nil)
+				c.On("ReadDate", day2).Return([]byte("remote2"), nil)
+				c.On("WriteDate", day1, []byte("local1")).Return(nil)
+				return &c
+			},
+			args: args{opts: app.SyncOptions{Since: day1, Direction: app.SyncUp}},
+			want: app.SyncResult{Uploaded: []time.Time{day1}},
+		},
+		{
+			name: "should overwrite changed local data when syncing down",
+			local: func() *mockDatedCache {
+				c := mockDatedCache{}
+				c.On("ReadDate", day1).Return([]byte("local1"), nil)
+				c.On("ReadDate", day2).Return([]byte("same2"), nil)
+				c.On("WriteDate", day1, []byte("changed1")).Return(nil)
+				return &c
+			},
+			remote: func() *mockDatedCache {
+				c := mockDatedCache{}
+				c.On("ReadDate", day1).Return([]byte("changed1"), nil)
+				c.On("ReadDate", day2).Return([]byte("same2"), nil)
+				return &c
+			},
+			args: args{opts: app.SyncOptions{Since: day1, Direction: app.SyncDown}},
+			want: app.SyncResult{Downloaded: []time.Time{day1}, Unchanged: 1},
+		},
+		{
+			name: "should report changed data as a conflict when syncing both ways",
+			local: func() *mockDatedCache {
+				c := mockDatedCache{}
+				c.On("ReadDate", day1).Return([]byte("local1"), nil)
+				c.On("ReadDate", day2).Return(nil, nil)
+				return &c
+			},
+			remote: func() *mockDatedCache {
+				c := mockDatedCache{}
+				c.On("ReadDate", day1).Return([]byte("changed1"), nil)
+				c.On("ReadDate", day2).Return(nil, nil)
+				return &c
+			},
+			args: args{opts: app.SyncOptions{Since: day1, Direction: app.SyncBoth}},
+			want: app.SyncResult{Conflicts: []time.Time{day1}},
+		},
+		{
+			name: "should fail if remote WriteDate() returns an error",
+			local: func() *mockDatedCache {
+				c := mockDatedCache{}
+				c.On("ReadDate", day1).Return([]byte("local1"), nil)
+				return &c
+			},
+			remote: func() *mockDatedCache {
+				c := mockDatedCache{}
+				c.On("ReadDate", day1).Return(nil, nil)
+				c.On("WriteDate", day1, []byte("local1")).Return(fmt.Errorf("write error"))
+				return &c
+			},
+			args:    args{opts: app.SyncOptions{Since: day1, Direction: app.SyncUp}},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.args.ctx == nil {
+				tt.args.ctx = context.Background()
+			}
+			a := app.App{app.Config{Log: slog.New(slog.NewTextHandler(os.Stdout, nil))}}
+			local, remote := tt.local(), tt.remote()
+			got, err := app.SyncCaches(tt.args.ctx, a, local, remote, tt.args.opts)
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tt.want, got)
+			}
+			local.AssertExpectations(t)
+			remote.AssertExpectations(t)
+		})
+	}
+}
@@ -14,8 +14,8 @@ var (
 	// OpenForReading opens a file for reading.
 	OpenForReading = func(filename string) (io.Reader, error) { return os.Open(filename) }
 
-	// CreateFile for creating a local file.
-	CreateFile = func(name string) (io.Writer, error) { return os.Create(name) }
+	// WriteFile atomically writes a local file, so a failed write never leaves a partial cache file.
+	WriteFile = filesystem.WriteFileAtomic
 
 	// Mkdir makes a directory if it doesn't exist.
 	Mkdir = filesystem.Mkdir
@@ -1,11 +1,15 @@
 package file
 
 import (
+	"context"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"strings"
 	"time"
+
+	"github.com/benjohns1/invest-source/app"
 )
 
 // Cache file implementation.
@@ -36,7 +40,7 @@ func (c Cache) Validate() error {
 }
 
 // ReadCurrent retrieves the current day's cache file data, or nil if it doesn't exist.
-func (c Cache) ReadCurrent() ([]byte, error) {
+func (c Cache) ReadCurrent(context.Context) ([]byte, error) {
 	return c.read(0)
 }
 
@@ -48,12 +52,15 @@ func (c Cache) read(dayOffset int) ([]byte, error) {
 		}
 		return nil, err
 	}
+	if closer, ok := f.(io.Closer); ok {
+		defer func() { _ = closer.Close() }()
+	}
 
 	return ioutil.ReadAll(f)
 }
 
 // ReadSince retrieves all caches since the given time.
-func (c Cache) ReadSince(since time.Time) ([][]byte, error) {
+func (c Cache) ReadSince(_ context.Context, since time.Time) ([][]byte, error) {
 	if since.Before(oldestCacheDate) {
 		since = oldestCacheDate
 	}
@@ -77,18 +84,23 @@ func (c Cache) ReadSince(since time.Time) ([][]byte, error) {
 	return set, nil
 }
 
+// ReadDate retrieves the cache file data for the given date, or nil if it doesn't exist.
+func (c Cache) ReadDate(_ context.Context, date time.Time) ([]byte, error) {
+	return c.read(app.DayOffset(Now(), date))
+}
+
 // Write writes the data to a daily cache.
-func (c Cache) WriteCurrent(data []byte) error {
-	f, err := CreateFile(c.Filename(0))
-	if err != nil {
-		return err
-	}
+func (c Cache) WriteCurrent(_ context.Context, data []byte) error {
+	return c.write(0, data)
+}
 
-	if _, err := f.Write(data); err != nil {
-		return err
-	}
+// WriteDate writes the data to the cache file for the given date.
+func (c Cache) WriteDate(_ context.Context, date time.Time, data []byte) error {
+	return c.write(app.DayOffset(Now(), date), data)
+}
 
-	return nil
+func (c Cache) write(dayOffset int, data []byte) error {
+	return WriteFile(c.Filename(dayOffset), data)
 }
 
 // FilenameGen returns a function to generate cache file names.
@@ -1,9 +1,15 @@
 package keyval
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"strings"
 	"time"
+
+	"github.com/benjohns1/invest-source/app"
 )
 
 // Cache key-value store implementation.
@@ -11,15 +17,23 @@ type Cache struct {
 	Provider Provider
 	Bucket   string
 	Key      func(int) string
+	// Prefix of the cache's keys, under which its ledgers are kept too.
+	Prefix string
+	// Log optionally records each key read and written at debug level.
+	Log app.Log
 }
 
 // OldestCacheDate ...
 var OldestCacheDate = time.Date(2021, time.January, 1, 0, 0, 0, 0, time.UTC)
 
-// Uploader for uploading files to a key-value store.
+// ErrKeyExists is returned by a Provider that only allows conditional writes, when uploading to a key that already exists.
+var ErrKeyExists = errors.New("key already exists")
+
+// Provider for streaming values to and from a key-value store.
 type Provider interface {
-	Upload(bucket, key string, value []byte) error
-	Download(bucket, key string) ([]byte, error)
+	Upload(ctx context.Context, bucket, key string, value io.Reader) error
+	// Download writes the value to w, returning false if the key doesn't exist.
+	Download(ctx context.Context, bucket, key string, w io.Writer) (bool, error)
 }
 
 // NewDailyCache instantiates a daily cache.
@@ -28,6 +42,7 @@ func NewDailyCache(provider Provider, bucket, pathPrefix string) (Cache, error)
 		Provider: provider,
 		Bucket:   bucket,
 		Key:      KeyGen(pathPrefix),
+		Prefix:   pathPrefix,
 	}
 	if err := c.Validate(); err != nil {
 		return Cache{}, err
@@ -52,12 +67,25 @@ func (c Cache) Validate() error {
 }
 
 // ReadCurrent retrieves the current day's cache data, or nil if it doesn't exist.
-func (c Cache) ReadCurrent() ([]byte, error) {
-	return c.Provider.Download(c.Bucket, c.Key(0))
+func (c Cache) ReadCurrent(ctx context.Context) ([]byte, error) {
+	return c.read(ctx, 0)
+}
+
+func (c Cache) read(ctx context.Context, dayOffset int) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	key := c.Key(dayOffset)
+	found, err := c.Provider.Download(ctx, c.Bucket, key, buf)
+	if err != nil || !found {
+		return nil, err
+	}
+	if c.Log != nil {
+		c.Log.Debug("read cache", "bucket", c.Bucket, "cacheKey", key, "bytes", buf.Len())
+	}
+	return buf.Bytes(), nil
 }
 
 // ReadSince retrieves all caches since the given time.
-func (c Cache) ReadSince(since time.Time) ([][]byte, error) {
+func (c Cache) ReadSince(ctx context.Context, since time.Time) ([][]byte, error) {
 	if since.Before(OldestCacheDate) {
 		since = OldestCacheDate
 	}
@@ -69,7 +97,7 @@ func (c Cache) ReadSince(since time.Time) ([][]byte, error) {
 		if curr.Before(since) {
 			break
 		}
-		data, err := c.Provider.Download(c.Bucket, c.Key(i))
+		data, err := c.read(ctx, i)
 		if err != nil {
 			return nil, err
 		}
@@ -81,15 +109,45 @@ func (c Cache) ReadSince(since time.Time) ([][]byte, error) {
 	return set, nil
 }
 
+// ReadDate retrieves the cache data for the given date, or nil if it doesn't exist.
+func (c Cache) ReadDate(ctx context.Context, date time.Time) ([]byte, error) {
+	return c.read(ctx, app.DayOffset(Now(), date))
+}
+
 // Write writes the data to a daily cache.
-func (c Cache) WriteCurrent(data []byte) error {
-	if err := c.Provider.Upload(c.Bucket, c.Key(0), data); err != nil {
+func (c Cache) WriteCurrent(ctx context.Context, data []byte) error {
+	return c.write(ctx, 0, data)
+}
+
+// WriteDate writes the data to the cache for the given date.
+func (c Cache) WriteDate(ctx context.Context, date time.Time, data []byte) error {
+	return c.write(ctx, app.DayOffset(Now(), date), data)
+}
+
+func (c Cache) write(ctx context.Context, dayOffset int, data []byte) error {
+	key := c.Key(dayOffset)
+	if err := c.Provider.Upload(ctx, c.Bucket, key, bytes.NewReader(data)); err != nil {
+		if errors.Is(err, ErrKeyExists) {
+			return fmt.Errorf("%w: %v", app.ErrCacheExists, err)
+		}
 		return err
 	}
+	if c.Log != nil {
+		c.Log.Debug("wrote cache", "bucket", c.Bucket, "cacheKey", key, "bytes", len(data))
+	}
 
 	return nil
 }
 
+// Ledger returns the named ledger kept alongside the cache's data, e.g. prefix/ledger/name/2021-06/1.json.
+func (c Cache) Ledger(name string) (Ledger, error) {
+	prefix := strings.TrimSuffix(strings.ReplaceAll(c.Prefix, "\\", "/"), "/")
+	if prefix != "" {
+		prefix += "/"
+	}
+	return NewLedger(c.Provider, c.Bucket, prefix+"ledger/"+name)
+}
+
 // KeyGen returns a function to generate cache key names.
 func KeyGen(path string) func(int) string {
 	dirPath := strings.ReplaceAll(path, "\\", "/")
@@ -0,0 +1,141 @@
+package keyval_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/benjohns1/invest-source/app"
+	"github.com/benjohns1/invest-source/cache/keyval"
+	"github.com/benjohns1/invest-source/cache/keyval/provider"
+)
+
+func TestCache(t *testing.T) {
+	keyval.Now = func() time.Time {
+		return time.Date(2021, time.January, 3, 12, 0, 0, 0, time.UTC)
+	}
+	tests := []struct {
+		name    string
+		entries map[string][]byte
+		since   time.Time
+		current []byte
+		want    [][]byte
+	}{
+		{
+			name: "should return nil for an empty cache",
+		},
+		{
+			name:    "should read the current day's entry",
+			entries: map[string][]byte{"prefix/2021-01-03.json": []byte("day3")},
+			current: []byte("day3"),
+			want:    [][]byte{[]byte("day3")},
+		},
+		{
+			name: "should read all entries since the given date, most recent first",
+			entries: map[string][]byte{
+				"prefix/2021-01-01.json": []byte("day1"),
+				"prefix/2021-01-02.json": []byte("day2"),
+				"prefix/2021-01-03.json": []byte("day3"),
+			},
+			since:   time.Date(2021, time.January, 2, 0, 0, 0, 0, time.UTC),
+			current: []byte("day3"),
+			want:    [][]byte{[]byte("day3"), []byte("day2")},
+		},
+		{
+			name: "should skip missing days",
+			entries: map[string][]byte{
+				"prefix/2021-01-01.json": []byte("day1"),
+				"prefix/2021-01-03.json": []byte("day3"),
+			},
+			current: []byte("day3"),
+			want:    [][]byte{[]byte("day3"), []byte("day1")},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := provider.NewMemory()
+			for key, value := range tt.entries {
+				if err := p.Upload(context.Background(), "bucket", key, bytes.NewReader(value)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			c, err := keyval.NewDailyCache(p, "bucket", "prefix")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			current, err := c.ReadCurrent(context.Background())
+			assert.NoError(t, err)
+			assert.Equal(t, tt.current, current)
+
+			got, err := c.ReadSince(context.Background(), tt.since)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestCache_WriteCurrent(t *testing.T) {
+	keyval.Now = func() time.Time {
+		return time.Date(2021, time.January, 3, 12, 0, 0, 0, time.UTC)
+	}
+	p := provider.NewMemory()
+	c, err := keyval.NewDailyCache(p, "bucket", "prefix")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.NoError(t, c.WriteCurrent(context.Background(), []byte("day3")))
+
+	got := &bytes.Buffer{}
+	found, err := p.Download(context.Background(), "bucket", "prefix/2021-01-03.json", got)
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, "day3", got.String())
+}
+
+type existingKeyProvider struct{}
+
+func (existingKeyProvider) Upload(_ context.Context, bucket, key string, _ io.Reader) error {
+	return fmt.Errorf("%w: %s/%s", keyval.ErrKeyExists, bucket, key)
+}
+
+func (existingKeyProvider) Download(context.Context, string, string, io.Writer) (bool, error) {
+	return true, nil
+}
+
+func TestCache_WriteCurrent_keyExists(t *testing.T) {
+	c, err := keyval.NewDailyCache(existingKeyProvider{}, "bucket", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = c.WriteCurrent(context.Background(), []byte("data"))
+	assert.True(t, errors.Is(err, app.ErrCacheExists), "expected app.ErrCacheExists, got %v", err)
+}
+
+func TestCache_Log(t *testing.T) {
+	keyval.Now = func() time.Time {
+		return time.Date(2021, time.January, 3, 12, 0, 0, 0, time.UTC)
+	}
+	c, err := keyval.NewDailyCache(provider.NewMemory(), "bucket", "prefix")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	c.Log = slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+
+	assert.NoError(t, c.WriteCurrent(context.Background(), []byte("day3")))
+	_, err = c.ReadCurrent(context.Background())
+	assert.NoError(t, err)
+
+	assert.Contains(t, buf.String(), "msg=\"wrote cache\" bucket=bucket cacheKey=prefix/2021-01-03.json bytes=4")
+	assert.Contains(t, buf.String(), "msg=\"read cache\" bucket=bucket cacheKey=prefix/2021-01-03.json bytes=4")
+}
@@ -0,0 +1,150 @@
+// Package keyvaltest provides a conformance test suite that every keyval.Provider implementation must pass.
+package keyvaltest
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/benjohns1/invest-source/cache/keyval"
+)
+
+// TestProvider runs the conformance test suite against providers created by newProvider, which should return an empty provider and an existing bucket name.
+func TestProvider(t *testing.T, newProvider func(t *testing.T) (keyval.Provider, string)) {
+	binary := make([]byte, 0, 512)
+	for i := 0; i < 512; i++ {
+		binary = append(binary, byte(i%256))
+	}
+
+	tests := []struct {
+		name string
+		test func(t *testing.T, p keyval.Provider, bucket string)
+	}{
+		{
+			name: "should report a missing key as not found without an error",
+			test: func(t *testing.T, p keyval.Provider, bucket string) {
+				w := &bytes.Buffer{}
+				found, err := p.Download(context.Background(), bucket, "missing.json", w)
+				assert.NoError(t, err)
+				assert.False(t, found)
+				assert.Zero(t, w.Len())
+			},
+		},
+		{
+			name: "should download the same value that was uploaded",
+			test: func(t *testing.T, p keyval.Provider, bucket string) {
+				require.NoError(t, upload(p, bucket, "2021-01-01.json", []byte(`{"data":[]}`)))
+				got, err := download(p, bucket, "2021-01-01.json")
+				assert.NoError(t, err)
+				assert.Equal(t, []byte(`{"data":[]}`), got)
+			},
+		},
+		{
+			name: "should support keys with path prefixes",
+			test: func(t *testing.T, p keyval.Provider, bucket string) {
+				require.NoError(t, upload(p, bucket, "prefix/nested/2021-01-01.json", []byte("nested")))
+				got, err := download(p, bucket, "prefix/nested/2021-01-01.json")
+				assert.NoError(t, err)
+				assert.Equal(t, []byte("nested"), got)
+
+				got, err = download(p, bucket, "2021-01-01.json")
+				assert.NoError(t, err)
+				assert.Nil(t, got)
+			},
+		},
+		{
+			name: "should overwrite an existing key with the latest value",
+			test: func(t *testing.T, p keyval.Provider, bucket string) {
+				require.NoError(t, upload(p, bucket, "key", []byte("first value, which is longer")))
+				require.NoError(t, upload(p, bucket, "key", []byte("second")))
+				got, err := download(p, bucket, "key")
+				assert.NoError(t, err)
+				assert.Equal(t, []byte("second"), got)
+			},
+		},
+		{
+			name: "should store binary values unmodified",
+			test: func(t *testing.T, p keyval.Provider, bucket string) {
+				require.NoError(t, upload(p, bucket, "binary", binary))
+				got, err := download(p, bucket, "binary")
+				assert.NoError(t, err)
+				assert.Equal(t, binary, got)
+			},
+		},
+		{
+			name: "should not be affected by modifying uploaded or downloaded values",
+			test: func(t *testing.T, p keyval.Provider, bucket string) {
+				value := []byte("original")
+				require.NoError(t, upload(p, bucket, "key", value))
+				value[0] = 'X'
+				got, err := download(p, bucket, "key")
+				require.NoError(t, err)
+				assert.Equal(t, []byte("original"), got)
+				got[0] = 'Y'
+				got, err = download(p, bucket, "key")
+				assert.NoError(t, err)
+				assert.Equal(t, []byte("original"), got)
+			},
+		},
+		{
+			name: "should stream values larger than a single upload part",
+			test: func(t *testing.T, p keyval.Provider, bucket string) {
+				large := bytes.Repeat(binary, (6<<20)/len(binary))
+				require.NoError(t, upload(p, bucket, "large", large))
+				got, err := download(p, bucket, "large")
+				assert.NoError(t, err)
+				assert.Equal(t, large, got)
+			},
+		},
+		{
+			name: "should store many keys independently",
+			test: func(t *testing.T, p keyval.Provider, bucket string) {
+				for i := 0; i < 10; i++ {
+					require.NoError(t, upload(p, bucket, fmt.Sprintf("key-%d", i), []byte(fmt.Sprintf("value-%d", i))))
+				}
+				for i := 0; i < 10; i++ {
+					got, err := download(p, bucket, fmt.Sprintf("key-%d", i))
+					assert.NoError(t, err)
+					assert.Equal(t, []byte(fmt.Sprintf("value-%d", i)), got)
+				}
+			},
+		},
+		{
+			name: "should abort calls with a cancelled context",
+			test: func(t *testing.T, p keyval.Provider, bucket string) {
+				require.NoError(t, upload(p, bucket, "key", []byte("value")))
+				ctx, cancel := context.WithCancel(context.Background())
+				cancel()
+				assert.Error(t, p.Upload(ctx, bucket, "key", bytes.NewReader([]byte("cancelled"))))
+				_, err := p.Download(ctx, bucket, "key", &bytes.Buffer{})
+				assert.Error(t, err)
+				got, err := download(p, bucket, "key")
+				assert.NoError(t, err)
+				assert.Equal(t, []byte("value"), got)
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, bucket := newProvider(t)
+			tt.test(t, p, bucket)
+		})
+	}
+}
+
+func upload(p keyval.Provider, bucket, key string, value []byte) error {
+	return p.Upload(context.Background(), bucket, key, bytes.NewReader(value))
+}
+
+func download(p keyval.Provider, bucket, key string) ([]byte, error) {
+	w := &bytes.Buffer{}
+	found, err := p.Download(context.Background(), bucket, key, w)
+	if err != nil || !found {
+		return nil, err
+	}
+	return w.Bytes(), nil
+}
@@ -0,0 +1,70 @@
+package keyvaltest
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// S3Server stands in for an S3-compatible store at its URL, for provider URLs with an endpoint param. Like S3, it refuses conditional uploads to existing keys.
+type S3Server struct {
+	*httptest.Server
+	mu      sync.Mutex
+	objects map[string]string
+}
+
+// NewS3Server starts a server that is closed when the test ends, with test AWS credentials set in the environment.
+func NewS3Server(t *testing.T) *S3Server {
+	t.Setenv("AWS_ACCESS_KEY_ID", "test")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "test")
+	t.Setenv("AWS_CONFIG_FILE", filepath.Join(t.TempDir(), "config"))
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", filepath.Join(t.TempDir(), "credentials"))
+	s := &S3Server{objects: make(map[string]string)}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// Objects returns the stored values by bucket/key.
+func (s *S3Server) Objects() map[string]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	objects := make(map[string]string, len(s.objects))
+	for k, v := range s.objects {
+		objects[k] = v
+	}
+	return objects
+}
+
+func (s *S3Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := r.URL.Path[1:]
+	value, exists := s.objects[key]
+	switch r.Method {
+	case http.MethodGet:
+		if !exists {
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = io.WriteString(w, `<Error><Code>NoSuchKey</Code><Message>The specified key does not exist.</Message></Error>`)
+			return
+		}
+		_, _ = io.WriteString(w, value)
+	case http.MethodPut:
+		if exists && r.Header.Get("If-None-Match") == "*" {
+			w.WriteHeader(http.StatusPreconditionFailed)
+			_, _ = io.WriteString(w, `<Error><Code>PreconditionFailed</Code><Message>At least one of the pre-conditions you specified did not hold</Message></Error>`)
+			return
+		}
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		s.objects[key] = string(body)
+	default:
+		w.WriteHeader(http.StatusNotImplemented)
+	}
+}
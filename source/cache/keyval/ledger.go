@@ -0,0 +1,103 @@
+package keyval
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// maxLedgerRetries is the number of times Add retries with the next entry number, when another writer adds an entry at the same time.
+const maxLedgerRetries = 10
+
+// Ledger records amounts per calendar month, e.g. API credits used, in its own keys apart from the cached data.
+// Each amount is a new numbered entry under the month's key, e.g. prefix/2021-06/1.json, so that entries are never overwritten, and providers with conditional writes keep concurrent writers' entries apart.
+type Ledger struct {
+	Provider Provider
+	Bucket   string
+	Prefix   string
+}
+
+type ledgerEntry struct {
+	Time   time.Time `json:"time"`
+	Amount int       `json:"amount"`
+}
+
+// NewLedger creates a ledger with its keys under the prefix.
+func NewLedger(provider Provider, bucket, prefix string) (Ledger, error) {
+	l := Ledger{
+		Provider: provider,
+		Bucket:   bucket,
+		Prefix:   prefix,
+	}
+	if err := l.Validate(); err != nil {
+		return Ledger{}, err
+	}
+	return l, nil
+}
+
+// Validate returns an error if the ledger was not correctly instantiated.
+func (l Ledger) Validate() error {
+	if l.Provider == nil {
+		return fmt.Errorf("ledger Provider must be set")
+	}
+	if l.Bucket == "" {
+		return fmt.Errorf("ledger Bucket must be set")
+	}
+	if l.Prefix == "" {
+		return fmt.Errorf("ledger Prefix must be set")
+	}
+
+	return nil
+}
+
+// Total returns the sum of the amounts added in the calendar month of the given time.
+func (l Ledger) Total(ctx context.Context, month time.Time) (int, error) {
+	total, _, err := l.read(ctx, month)
+	return total, err
+}
+
+// Add adds an amount to the ledger at the given time.
+func (l Ledger) Add(ctx context.Context, at time.Time, amount int) error {
+	_, entries, err := l.read(ctx, at)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(ledgerEntry{Time: at.UTC(), Amount: amount})
+	if err != nil {
+		return err
+	}
+	for retry := 0; ; retry++ {
+		err := l.Provider.Upload(ctx, l.Bucket, l.key(at, entries+1+retry), bytes.NewReader(data))
+		if !errors.Is(err, ErrKeyExists) || retry >= maxLedgerRetries {
+			return err
+		}
+	}
+}
+
+// read returns the month's total and number of entries, reading numbered entries until one is missing.
+func (l Ledger) read(ctx context.Context, month time.Time) (total int, entries int, err error) {
+	for n := 1; ; n++ {
+		key := l.key(month, n)
+		buf := &bytes.Buffer{}
+		found, err := l.Provider.Download(ctx, l.Bucket, key, buf)
+		if err != nil {
+			return 0, 0, err
+		}
+		if !found {
+			return total, n - 1, nil
+		}
+		var e ledgerEntry
+		if err := json.Unmarshal(buf.Bytes(), &e); err != nil {
+			return 0, 0, fmt.Errorf("invalid ledger entry '%s': %v", key, err)
+		}
+		total += e.Amount
+	}
+}
+
+func (l Ledger) key(month time.Time, n int) string {
+	return fmt.Sprintf("%s/%s/%d.json", strings.TrimSuffix(strings.ReplaceAll(l.Prefix, "\\", "/"), "/"), month.UTC().Format("2006-01"), n)
+}
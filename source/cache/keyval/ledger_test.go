@@ -0,0 +1,91 @@
+package keyval_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/benjohns1/invest-source/cache/keyval"
+	"github.com/benjohns1/invest-source/cache/keyval/provider"
+)
+
+func TestLedger(t *testing.T) {
+	p := provider.NewMemory()
+	c, err := keyval.NewDailyCache(p, "bucket", "prefix")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := c.Ledger("credits")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	may := time.Date(2021, time.May, 31, 23, 0, 0, 0, time.UTC)
+	june := time.Date(2021, time.June, 2, 12, 0, 0, 0, time.UTC)
+	assert.NoError(t, l.Add(context.Background(), may, 7))
+	assert.NoError(t, l.Add(context.Background(), june, 25))
+	assert.NoError(t, l.Add(context.Background(), june.Add(time.Hour), 1))
+
+	tests := []struct {
+		name  string
+		month time.Time
+		want  int
+	}{
+		{name: "should total a month's amounts", month: time.Date(2021, time.June, 1, 0, 0, 0, 0, time.UTC), want: 26},
+		{name: "should total the month of any time in it", month: may, want: 7},
+		{name: "should total zero for a month without entries", month: time.Date(2021, time.July, 1, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := l.Total(context.Background(), tt.month)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+
+	found, err := p.Download(context.Background(), "bucket", "prefix/ledger/credits/2021-06/2.json", &bytes.Buffer{})
+	assert.NoError(t, err)
+	assert.True(t, found, "should number the month's entries under the cache's prefix")
+}
+
+// racingProvider refuses uploads to keys another writer has just taken, but that it can't download yet.
+type racingProvider struct {
+	*provider.Memory
+	taken map[string]bool
+}
+
+func (p racingProvider) Upload(ctx context.Context, bucket, key string, value io.Reader) error {
+	if p.taken[key] {
+		return fmt.Errorf("%w: %s/%s", keyval.ErrKeyExists, bucket, key)
+	}
+	return p.Memory.Upload(ctx, bucket, key, value)
+}
+
+func TestLedger_Add_keyExists(t *testing.T) {
+	p := racingProvider{Memory: provider.NewMemory(), taken: map[string]bool{"credits/2021-06/1.json": true, "credits/2021-06/2.json": true}}
+	l, err := keyval.NewLedger(p, "bucket", "credits")
+	if err != nil {
+		t.Fatal(err)
+	}
+	june := time.Date(2021, time.June, 2, 12, 0, 0, 0, time.UTC)
+
+	assert.NoError(t, l.Add(context.Background(), june, 25))
+
+	found, err := p.Download(context.Background(), "bucket", "credits/2021-06/3.json", &bytes.Buffer{})
+	assert.NoError(t, err)
+	assert.True(t, found, "should add the entry after the ones taken by other writers")
+}
+
+func TestNewLedger(t *testing.T) {
+	_, err := keyval.NewLedger(nil, "bucket", "credits")
+	assert.Error(t, err)
+	_, err = keyval.NewLedger(provider.NewMemory(), "", "credits")
+	assert.Error(t, err)
+	_, err = keyval.NewLedger(provider.NewMemory(), "bucket", "")
+	assert.Error(t, err)
+}
@@ -0,0 +1,64 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/bloberror"
+)
+
+// AzureBlob Azure Blob Storage provider for a key-value cache, using containers as buckets.
+type AzureBlob struct {
+	client *azblob.Client
+}
+
+// NewAzureBlob creates a new Azure Blob Storage key-value cache provider from a storage account connection string, which can also point to a local Azurite emulator.
+func NewAzureBlob(connectionString string) (*AzureBlob, error) {
+	client, err := azblob.NewClientFromConnectionString(connectionString, nil)
+	if err != nil {
+		return nil, fmt.Errorf("could not construct Azure Blob client: %v", err)
+	}
+	return &AzureBlob{client}, nil
+}
+
+// NewAzureBlobWithSharedKey creates a new Azure Blob Storage key-value cache provider for the given storage account service URL and shared key.
+func NewAzureBlobWithSharedKey(serviceURL, account, key string) (*AzureBlob, error) {
+	cred, err := azblob.NewSharedKeyCredential(account, key)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Azure Blob shared key credential: %v", err)
+	}
+	client, err := azblob.NewClientWithSharedKeyCredential(serviceURL, cred, nil)
+	if err != nil {
+		return nil, fmt.Errorf("could not construct Azure Blob client: %v", err)
+	}
+	return &AzureBlob{client}, nil
+}
+
+// Upload streams a value to an Azure Blob container at the given key location.
+func (ab AzureBlob) Upload(ctx context.Context, container, key string, value io.Reader) error {
+	if _, err := ab.client.UploadStream(ctx, container, key, value, nil); err != nil {
+		return fmt.Errorf("error uploading Azure blob: %v", err)
+	}
+
+	return nil
+}
+
+// Download streams a value from an Azure Blob container with the given key.
+func (ab AzureBlob) Download(ctx context.Context, container, key string, w io.Writer) (bool, error) {
+	resp, err := ab.client.DownloadStream(ctx, container, key, nil)
+	if err != nil {
+		if bloberror.HasCode(err, bloberror.BlobNotFound) {
+			return false, nil // key doesn't exist
+		}
+		return false, fmt.Errorf("error downloading Azure blob: %v", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if _, err := io.Copy(w, resp.Body); err != nil {
+		return false, fmt.Errorf("error downloading Azure blob: %v", err)
+	}
+
+	return true, nil
+}
@@ -0,0 +1,92 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/benjohns1/invest-source/utils/filesystem"
+)
+
+// Dir local filesystem provider for a key-value cache, storing each bucket as a subdirectory of Root.
+type Dir struct {
+	Root string
+}
+
+// NewDir creates a new directory-backed key-value cache provider.
+func NewDir(root string) (*Dir, error) {
+	if root == "" {
+		return nil, fmt.Errorf("provider Root must be set")
+	}
+	if err := filesystem.Mkdir(root); err != nil {
+		return nil, err
+	}
+	return &Dir{Root: root}, nil
+}
+
+// Upload streams a value to a file in the bucket directory at the given key location.
+func (d Dir) Upload(ctx context.Context, bucket, key string, value io.Reader) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	path, err := d.path(bucket, key)
+	if err != nil {
+		return err
+	}
+	if err := filesystem.Mkdir(filepath.Dir(path)); err != nil {
+		return err
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("error creating file for key '%s': %v", key, err)
+	}
+	if _, err := io.Copy(f, value); err != nil {
+		_ = f.Close()
+		return fmt.Errorf("error writing file for key '%s': %v", key, err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("error writing file for key '%s': %v", key, err)
+	}
+
+	return nil
+}
+
+// Download streams a value from a file in the bucket directory with the given key.
+func (d Dir) Download(ctx context.Context, bucket, key string, w io.Writer) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+	path, err := d.path(bucket, key)
+	if err != nil {
+		return false, err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil // key doesn't exist
+		}
+		return false, fmt.Errorf("error reading file for key '%s': %v", key, err)
+	}
+	defer func() { _ = f.Close() }()
+
+	if _, err := io.Copy(w, f); err != nil {
+		return false, fmt.Errorf("error reading file for key '%s': %v", key, err)
+	}
+
+	return true, nil
+}
+
+func (d Dir) path(bucket, key string) (string, error) {
+	if bucket == "" || strings.ContainsAny(bucket, `/\`) || bucket == "." || bucket == ".." {
+		return "", fmt.Errorf("invalid bucket name '%s'", bucket)
+	}
+	bucketDir := filepath.Join(d.Root, bucket)
+	path := filepath.Join(bucketDir, filepath.FromSlash(key))
+	if !strings.HasPrefix(path, bucketDir+string(filepath.Separator)) {
+		return "", fmt.Errorf("invalid key '%s', must be within bucket '%s'", key, bucket)
+	}
+	return path, nil
+}
@@ -0,0 +1,61 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/option"
+)
+
+// GCS Google Cloud Storage provider for a key-value cache.
+type GCS struct {
+	client *storage.Client
+}
+
+// NewGCS creates a new Google Cloud Storage key-value cache provider. Credentials are resolved from the environment, and the STORAGE_EMULATOR_HOST environment variable can be set to use a local emulator.
+func NewGCS(ctx context.Context, opts ...option.ClientOption) (*GCS, error) {
+	client, err := storage.NewClient(ctx, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("could not construct GCS client: %v", err)
+	}
+	return &GCS{client}, nil
+}
+
+// Upload streams a value to a GCS bucket at the given key location.
+func (gcs GCS) Upload(ctx context.Context, bucket, key string, value io.Reader) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	w := gcs.client.Bucket(bucket).Object(key).NewWriter(ctx)
+	if _, err := io.Copy(w, value); err != nil {
+		// cancel instead of closing the writer, which would commit the partial object
+		cancel()
+		_ = w.Close()
+		return fmt.Errorf("error uploading GCS object: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("error uploading GCS object: %v", err)
+	}
+
+	return nil
+}
+
+// Download streams a value from a GCS bucket with the given key.
+func (gcs GCS) Download(ctx context.Context, bucket, key string, w io.Writer) (bool, error) {
+	r, err := gcs.client.Bucket(bucket).Object(key).NewReader(ctx)
+	if err != nil {
+		if errors.Is(err, storage.ErrObjectNotExist) {
+			return false, nil // key doesn't exist
+		}
+		return false, fmt.Errorf("error downloading GCS object: %v", err)
+	}
+	defer func() { _ = r.Close() }()
+
+	if _, err := io.Copy(w, r); err != nil {
+		return false, fmt.Errorf("error downloading GCS object: %v", err)
+	}
+
+	return true, nil
+}
@@ -0,0 +1,59 @@
+package provider
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sync"
+)
+
+// Memory in-memory provider for a key-value cache, useful for tests and running offline.
+type Memory struct {
+	mu      sync.RWMutex
+	buckets map[string]map[string][]byte
+}
+
+// NewMemory creates a new in-memory key-value cache provider.
+func NewMemory() *Memory {
+	return &Memory{buckets: make(map[string]map[string][]byte)}
+}
+
+// Upload stores the value in the bucket at the given key location.
+func (m *Memory) Upload(ctx context.Context, bucket, key string, value io.Reader) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	data, err := ioutil.ReadAll(value)
+	if err != nil {
+		return fmt.Errorf("error reading value for key '%s': %v", key, err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	b, ok := m.buckets[bucket]
+	if !ok {
+		b = make(map[string][]byte)
+		m.buckets[bucket] = b
+	}
+	b[key] = data
+	return nil
+}
+
+// Download writes the value from the bucket with the given key.
+func (m *Memory) Download(ctx context.Context, bucket, key string, w io.Writer) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+	m.mu.RLock()
+	value, ok := m.buckets[bucket][key]
+	m.mu.RUnlock()
+	if !ok {
+		return false, nil // key doesn't exist
+	}
+	if _, err := io.Copy(w, bytes.NewReader(value)); err != nil {
+		return false, fmt.Errorf("error writing value for key '%s': %v", key, err)
+	}
+	return true, nil
+}
@@ -0,0 +1,300 @@
+package provider_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	awsS3 "github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/benjohns1/invest-source/app"
+	"github.com/benjohns1/invest-source/cache/keyval"
+	"github.com/benjohns1/invest-source/cache/keyval/keyvaltest"
+	"github.com/benjohns1/invest-source/cache/keyval/provider"
+)
+
+func TestMemory(t *testing.T) {
+	keyvaltest.TestProvider(t, func(t *testing.T) (keyval.Provider, string) {
+		return provider.NewMemory(), "bucket"
+	})
+}
+
+func TestDir(t *testing.T) {
+	keyvaltest.TestProvider(t, func(t *testing.T) (keyval.Provider, string) {
+		p, err := provider.NewDir(t.TempDir())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return p, "bucket"
+	})
+}
+
+func TestDir_Download(t *testing.T) {
+	p, err := provider.NewDir(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"../escaped", "../../escaped", "a/../../escaped"} {
+		if _, err := p.Download(context.Background(), "bucket", key, ioutil.Discard); err == nil {
+			t.Errorf("expected an error for key '%s' outside of the bucket", key)
+		}
+	}
+	for _, bucket := range []string{"", ".", "..", "a/b"} {
+		if _, err := p.Download(context.Background(), bucket, "key", ioutil.Discard); err == nil {
+			t.Errorf("expected an error for invalid bucket '%s'", bucket)
+		}
+	}
+}
+
+// TestS3 runs against localstack when the AWSEndpoint environment variable is set, e.g. AWSEndpoint=http://localhost:4566 after running `mage awsLocal`.
+func TestS3(t *testing.T) {
+	endpoint := os.Getenv("AWSEndpoint")
+	if endpoint == "" {
+		t.Skip("AWSEndpoint not set, skipping S3 provider tests")
+	}
+	cfg, err := config.LoadDefaultConfig(context.Background(), config.WithRegion("us-west-2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := provider.NewS3Client(cfg, endpoint)
+	createBucket := func(t *testing.T) string {
+		bucket := fmt.Sprintf("keyvaltest-%d", time.Now().UnixNano())
+		if _, err := client.CreateBucket(context.Background(), &awsS3.CreateBucketInput{Bucket: aws.String(bucket)}); err != nil {
+			t.Fatal(err)
+		}
+		return bucket
+	}
+
+	p, err := provider.NewS3(client, provider.S3Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyvaltest.TestProvider(t, func(t *testing.T) (keyval.Provider, string) {
+		return p, createBucket(t)
+	})
+
+	t.Run("should not overwrite an existing key with NoOverwrite", func(t *testing.T) {
+		p, err := provider.NewS3(client, provider.S3Options{NoOverwrite: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		bucket := createBucket(t)
+		assert.NoError(t, p.Upload(context.Background(), bucket, "key", strings.NewReader("first")))
+		err = p.Upload(context.Background(), bucket, "key", strings.NewReader("second"))
+		assert.True(t, errors.Is(err, keyval.ErrKeyExists), "expected keyval.ErrKeyExists, got %v", err)
+	})
+}
+
+func TestNewS3(t *testing.T) {
+	client := provider.NewS3Client(aws.Config{Region: "us-west-2"}, "")
+	tests := []struct {
+		name    string
+		opts    provider.S3Options
+		wantErr bool
+	}{
+		{
+			name: "should succeed with default options",
+		},
+		{
+			name: "should succeed with KMS encryption and a storage class",
+			opts: provider.S3Options{ServerSideEncryption: "aws:kms", SSEKMSKeyID: "key-id", StorageClass: "STANDARD_IA"},
+		},
+		{
+			name:    "should fail with an unsupported encryption algorithm",
+			opts:    provider.S3Options{ServerSideEncryption: "rot13"},
+			wantErr: true,
+		},
+		{
+			name:    "should fail with a KMS key ID without KMS encryption",
+			opts:    provider.S3Options{ServerSideEncryption: "AES256", SSEKMSKeyID: "key-id"},
+			wantErr: true,
+		},
+		{
+			name:    "should fail with an unsupported storage class",
+			opts:    provider.S3Options{StorageClass: "CARDBOARD_BOX"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := provider.NewS3(client, tt.opts)
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+// TestGCS runs against a GCS emulator when the STORAGE_EMULATOR_HOST environment variable is set, e.g. STORAGE_EMULATOR_HOST=localhost:4443 with deploy/emulators running.
+func TestGCS(t *testing.T) {
+	if os.Getenv("STORAGE_EMULATOR_HOST") == "" {
+		t.Skip("STORAGE_EMULATOR_HOST not set, skipping GCS provider tests")
+	}
+	ctx := context.Background()
+	client, err := storage.NewClient(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := provider.NewGCS(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyvaltest.TestProvider(t, func(t *testing.T) (keyval.Provider, string) {
+		bucket := fmt.Sprintf("keyvaltest-%d", time.Now().UnixNano())
+		if err := client.Bucket(bucket).Create(ctx, "test-project", nil); err != nil {
+			t.Fatal(err)
+		}
+		return p, bucket
+	})
+}
+
+// TestAzureBlob runs against Azurite when the AZURE_STORAGE_CONNECTION_STRING environment variable is set, e.g. to the Azurite development storage connection string with deploy/emulators running.
+func TestAzureBlob(t *testing.T) {
+	connectionString := os.Getenv("AZURE_STORAGE_CONNECTION_STRING")
+	if connectionString == "" {
+		t.Skip("AZURE_STORAGE_CONNECTION_STRING not set, skipping Azure Blob provider tests")
+	}
+	client, err := azblob.NewClientFromConnectionString(connectionString, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := provider.NewAzureBlob(connectionString)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyvaltest.TestProvider(t, func(t *testing.T) (keyval.Provider, string) {
+		container := fmt.Sprintf("keyvaltest-%d", time.Now().UnixNano())
+		if _, err := client.CreateContainer(context.Background(), container, nil); err != nil {
+			t.Fatal(err)
+		}
+		return p, container
+	})
+}
+
+func TestOpen(t *testing.T) {
+	dir := filepath.ToSlash(t.TempDir())
+	tests := []struct {
+		name         string
+		url          string
+		wantLocation provider.Location
+		wantErr      bool
+	}{
+		{
+			name:    "should fail with an unsupported scheme",
+			url:     "ftp://bucket/prefix",
+			wantErr: true,
+		},
+		{
+			name:    "should fail without a bucket",
+			url:     "s3:///prefix",
+			wantErr: true,
+		},
+		{
+			name:         "should open an S3 provider",
+			url:          "s3://bucket/prefix/path",
+			wantLocation: provider.Location{Bucket: "bucket", Prefix: "prefix/path"},
+		},
+		{
+			name:         "should open an S3-compatible provider with a custom endpoint",
+			url:          "s3://bucket?endpoint=http://localhost:9000&region=us-east-1",
+			wantLocation: provider.Location{Bucket: "bucket"},
+		},
+		{
+			name:         "should open a directory provider",
+			url:          "file://" + dir + "/bucket",
+			wantLocation: provider.Location{Bucket: "bucket"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, loc, err := provider.Open(context.Background(), tt.url)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.NotNil(t, p)
+			assert.Equal(t, tt.wantLocation, loc)
+		})
+	}
+}
+
+func TestOpen_s3NoOverwrite(t *testing.T) {
+	tests := []struct {
+		name string
+		url  string
+		want bool
+	}{
+		{name: "should not overwrite by default", url: "s3://bucket?region=us-east-1", want: true},
+		{name: "should overwrite with no_overwrite=false", url: "s3://bucket?region=us-east-1&no_overwrite=false", want: false},
+		{name: "should not overwrite with no_overwrite=true", url: "s3://bucket?region=us-east-1&no_overwrite=true", want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, _, err := provider.Open(context.Background(), tt.url)
+			assert.NoError(t, err)
+			if assert.IsType(t, &provider.S3{}, p) {
+				assert.Equal(t, tt.want, p.(*provider.S3).Options.NoOverwrite)
+			}
+		})
+	}
+}
+
+func TestOpenOverwriting(t *testing.T) {
+	srv := keyvaltest.NewS3Server(t)
+	today := time.Now().UTC()
+	tests := []struct {
+		name    string
+		open    func(context.Context, string) (keyval.Provider, provider.Location, error)
+		query   string
+		wantErr bool
+	}{
+		{name: "should sync changed entries up", open: provider.OpenOverwriting},
+		{name: "should not overwrite with no_overwrite=true", open: provider.OpenOverwriting, query: "&no_overwrite=true", wantErr: true},
+		{name: "should not overwrite when opened like a cache", open: provider.Open, wantErr: true},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, loc, err := tt.open(context.Background(), fmt.Sprintf("s3://bucket%d/cache?endpoint=%s%s", i, srv.URL, tt.query))
+			if err != nil {
+				t.Fatal(err)
+			}
+			remote, err := keyval.NewDailyCache(p, loc.Bucket, loc.Prefix)
+			if err != nil {
+				t.Fatal(err)
+			}
+			local, err := keyval.NewDailyCache(provider.NewMemory(), "bucket", "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.NoError(t, remote.WriteDate(context.Background(), today, []byte("remote")))
+			assert.NoError(t, local.WriteDate(context.Background(), today, []byte("changed")))
+
+			a := app.App{Config: app.Config{Log: slog.New(slog.NewTextHandler(io.Discard, nil))}}
+			_, err = app.SyncCaches(context.Background(), a, local, remote, app.SyncOptions{Since: today, Direction: app.SyncUp})
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			got, err := remote.ReadDate(context.Background(), today)
+			assert.NoError(t, err)
+			assert.Equal(t, []byte("changed"), got)
+		})
+	}
+}
@@ -1,63 +1,136 @@
 package provider
 
 import (
-	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"io"
 
-	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	awsS3 "github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
 
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/client"
-	awsS3 "github.com/aws/aws-sdk-go/service/s3"
-	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/benjohns1/invest-source/cache/keyval"
 )
 
 // S3 provider for a key-value cache.
 type S3 struct {
-	uploader   *s3manager.Uploader
-	downloader *s3manager.Downloader
+	client   *awsS3.Client
+	uploader *manager.Uploader
+	Options  S3Options
+}
+
+// S3Options configure how objects are written to S3.
+type S3Options struct {
+	// ServerSideEncryption algorithm for uploaded objects, e.g. AES256 or aws:kms.
+	ServerSideEncryption string
+	// SSEKMSKeyID of the KMS key to use with aws:kms encryption, defaults to the AWS managed key.
+	SSEKMSKeyID string
+	// StorageClass for uploaded objects, e.g. STANDARD_IA, defaults to STANDARD.
+	StorageClass string
+	// NoOverwrite makes uploads conditional on the key not existing yet, returning keyval.ErrKeyExists if it does.
+	NoOverwrite bool
+}
+
+// NewS3Client creates an S3 client, using path-style addressing for a custom endpoint such as localstack or MinIO.
+func NewS3Client(cfg aws.Config, endpoint string) *awsS3.Client {
+	return awsS3.NewFromConfig(cfg, func(o *awsS3.Options) {
+		if endpoint != "" {
+			o.BaseEndpoint = aws.String(endpoint)
+			o.UsePathStyle = true
+		}
+	})
 }
 
 // NewS3 creates a new S3 key-value cache provider.
-func NewS3(cfgProvider client.ConfigProvider) (*S3, error) {
-	uploader := s3manager.NewUploader(cfgProvider)
-	if uploader == nil {
-		return nil, fmt.Errorf("could not construct S3 uploader")
+func NewS3(client *awsS3.Client, opts S3Options) (*S3, error) {
+	if client == nil {
+		return nil, fmt.Errorf("S3 client must be set")
+	}
+	if err := opts.Validate(); err != nil {
+		return nil, err
 	}
-	downloader := s3manager.NewDownloader(cfgProvider)
-	if downloader == nil {
-		return nil, fmt.Errorf("could not construct S3 downloader")
+	return &S3{
+		client:   client,
+		uploader: manager.NewUploader(client),
+		Options:  opts,
+	}, nil
+}
+
+// Validate returns an error if the options are not supported by S3.
+func (o S3Options) Validate() error {
+	if o.ServerSideEncryption != "" && !isOneOf(o.ServerSideEncryption, types.ServerSideEncryption("").Values()) {
+		return fmt.Errorf("unsupported S3 server-side encryption '%s', must be one of %v", o.ServerSideEncryption, types.ServerSideEncryption("").Values())
+	}
+	if o.SSEKMSKeyID != "" && o.ServerSideEncryption != string(types.ServerSideEncryptionAwsKms) && o.ServerSideEncryption != string(types.ServerSideEncryptionAwsKmsDsse) {
+		return fmt.Errorf("S3 KMS key ID can only be set with aws:kms server-side encryption")
 	}
-	return &S3{uploader, downloader}, nil
+	if o.StorageClass != "" && !isOneOf(o.StorageClass, types.StorageClass("").Values()) {
+		return fmt.Errorf("unsupported S3 storage class '%s', must be one of %v", o.StorageClass, types.StorageClass("").Values())
+	}
+	return nil
 }
 
-// Upload a byte array to an S3 bucket at the given key location.
-func (s3 S3) Upload(bucket, key string, value []byte) error {
-	if _, err := s3.uploader.Upload(&s3manager.UploadInput{
+// Upload streams a value to an S3 bucket at the given key location.
+func (s3 S3) Upload(ctx context.Context, bucket, key string, value io.Reader) error {
+	in := &awsS3.PutObjectInput{
 		Bucket: aws.String(bucket),
 		Key:    aws.String(key),
-		Body:   bytes.NewReader(value),
-	}); err != nil {
+		Body:   value,
+	}
+	if s3.Options.ServerSideEncryption != "" {
+		in.ServerSideEncryption = types.ServerSideEncryption(s3.Options.ServerSideEncryption)
+	}
+	if s3.Options.SSEKMSKeyID != "" {
+		in.SSEKMSKeyId = aws.String(s3.Options.SSEKMSKeyID)
+	}
+	if s3.Options.StorageClass != "" {
+		in.StorageClass = types.StorageClass(s3.Options.StorageClass)
+	}
+	if s3.Options.NoOverwrite {
+		in.IfNoneMatch = aws.String("*")
+	}
+
+	if _, err := s3.uploader.Upload(ctx, in); err != nil {
+		var apiErr smithy.APIError
+		if errors.As(err, &apiErr) && (apiErr.ErrorCode() == "PreconditionFailed" || apiErr.ErrorCode() == "ConditionalRequestConflict") {
+			return fmt.Errorf("%w: S3 object '%s' in bucket '%s'", keyval.ErrKeyExists, key, bucket)
+		}
 		return fmt.Errorf("error uploading S3 object: %v", err)
 	}
 
 	return nil
 }
 
-// Download a byte array from an S3 bucket with the given key.
-func (s3 S3) Download(bucket, key string) ([]byte, error) {
-	buf := &aws.WriteAtBuffer{}
-	if _, err := s3.downloader.Download(buf, &awsS3.GetObjectInput{
+// Download streams a value from an S3 bucket with the given key.
+func (s3 S3) Download(ctx context.Context, bucket, key string, w io.Writer) (bool, error) {
+	resp, err := s3.client.GetObject(ctx, &awsS3.GetObjectInput{
 		Bucket: aws.String(bucket),
 		Key:    aws.String(key),
-	}); err != nil {
-		if awsErr, ok := err.(awserr.Error); ok {
-			if awsErr.Code() == awsS3.ErrCodeNoSuchKey {
-				return nil, nil // return nil if key doesn't exist
-			}
+	})
+	if err != nil {
+		var noSuchKey *types.NoSuchKey
+		if errors.As(err, &noSuchKey) {
+			return false, nil // key doesn't exist
 		}
-		return nil, fmt.Errorf("error downloading S3 object: %v", err)
+		return false, fmt.Errorf("error downloading S3 object: %v", err)
 	}
+	defer func() { _ = resp.Body.Close() }()
 
-	return buf.Bytes(), nil
+	if _, err := io.Copy(w, resp.Body); err != nil {
+		return false, fmt.Errorf("error downloading S3 object: %v", err)
+	}
+
+	return true, nil
+}
+
+func isOneOf[T ~string](value string, allowed []T) bool {
+	for _, v := range allowed {
+		if string(v) == value {
+			return true
+		}
+	}
+	return false
 }
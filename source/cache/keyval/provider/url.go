@@ -0,0 +1,131 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/config"
+
+	"github.com/benjohns1/invest-source/cache/keyval"
+)
+
+// Location of cache data within a key-value provider.
+type Location struct {
+	Bucket string
+	Prefix string
+}
+
+// Open creates a key-value cache provider selected by the URL scheme, and returns it with the bucket and key prefix parsed from the URL:
+//
+//	s3://bucket/prefix for AWS S3, or S3-compatible stores like MinIO with ?endpoint=http://localhost:9000 (optionally &region=us-east-1),
+//	  with optional sse, kms_key_id, storage_class and no_overwrite query params matching S3Options, no_overwrite defaulting to true so concurrent writers can't overwrite each other
+//	gs://bucket/prefix for Google Cloud Storage, using the STORAGE_EMULATOR_HOST environment variable to target an emulator
+//	azblob://container/prefix for Azure Blob Storage, using the AZURE_STORAGE_CONNECTION_STRING or the AZURE_STORAGE_ACCOUNT and AZURE_STORAGE_KEY environment variables
+//	file:///path/to/bucket for a local directory
+func Open(ctx context.Context, rawURL string) (keyval.Provider, Location, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, Location{}, fmt.Errorf("invalid key-value provider URL '%s': %v", rawURL, err)
+	}
+	loc := Location{
+		Bucket: u.Host,
+		Prefix: strings.TrimPrefix(u.Path, "/"),
+	}
+	if u.Scheme != "file" && loc.Bucket == "" {
+		return nil, Location{}, fmt.Errorf("key-value provider URL '%s' must include a bucket", rawURL)
+	}
+
+	var p keyval.Provider
+	switch u.Scheme {
+	case "s3":
+		p, err = openS3(ctx, u.Query())
+	case "gs":
+		p, err = NewGCS(ctx)
+	case "azblob":
+		p, err = openAzureBlob(u.Query())
+	case "file":
+		p, loc, err = openDir(u)
+	default:
+		return nil, Location{}, fmt.Errorf("unsupported key-value provider URL scheme '%s', must be one of s3, gs, azblob or file", u.Scheme)
+	}
+	if err != nil {
+		return nil, Location{}, err
+	}
+
+	return p, loc, nil
+}
+
+// OpenOverwriting opens a provider like Open, except that an s3 URL without a no_overwrite param overwrites existing keys, for writers that replace data rather than race each other.
+func OpenOverwriting(ctx context.Context, rawURL string) (keyval.Provider, Location, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, Location{}, fmt.Errorf("invalid key-value provider URL '%s': %v", rawURL, err)
+	}
+	if q := u.Query(); u.Scheme == "s3" && !q.Has("no_overwrite") {
+		q.Set("no_overwrite", "false")
+		u.RawQuery = q.Encode()
+	}
+	return Open(ctx, u.String())
+}
+
+func openS3(ctx context.Context, q url.Values) (keyval.Provider, error) {
+	var loadOpts []func(*config.LoadOptions) error
+	region := q.Get("region")
+	endpoint := q.Get("endpoint")
+	if region == "" && endpoint != "" && os.Getenv("AWS_REGION") == "" {
+		region = "us-east-1"
+	}
+	if region != "" {
+		loadOpts = append(loadOpts, config.WithRegion(region))
+	}
+	cfg, err := config.LoadDefaultConfig(ctx, loadOpts...)
+	if err != nil {
+		return nil, fmt.Errorf("error loading AWS config: %v", err)
+	}
+
+	opts := S3Options{
+		ServerSideEncryption: q.Get("sse"),
+		SSEKMSKeyID:          q.Get("kms_key_id"),
+		StorageClass:         q.Get("storage_class"),
+		NoOverwrite:          true,
+	}
+	if noOverwrite := q.Get("no_overwrite"); noOverwrite != "" {
+		if opts.NoOverwrite, err = strconv.ParseBool(noOverwrite); err != nil {
+			return nil, fmt.Errorf("invalid no_overwrite value '%s': %v", noOverwrite, err)
+		}
+	}
+
+	return NewS3(NewS3Client(cfg, endpoint), opts)
+}
+
+func openAzureBlob(q url.Values) (keyval.Provider, error) {
+	if connectionString := os.Getenv("AZURE_STORAGE_CONNECTION_STRING"); connectionString != "" {
+		return NewAzureBlob(connectionString)
+	}
+	account := os.Getenv("AZURE_STORAGE_ACCOUNT")
+	if account == "" {
+		return nil, fmt.Errorf("AZURE_STORAGE_CONNECTION_STRING or AZURE_STORAGE_ACCOUNT must be set for azblob URLs")
+	}
+	serviceURL := q.Get("endpoint")
+	if serviceURL == "" {
+		serviceURL = fmt.Sprintf("https://%s.blob.core.windows.net/", account)
+	}
+	return NewAzureBlobWithSharedKey(serviceURL, account, os.Getenv("AZURE_STORAGE_KEY"))
+}
+
+func openDir(u *url.URL) (keyval.Provider, Location, error) {
+	path := filepath.FromSlash(u.Host + u.Path)
+	if path == "" {
+		return nil, Location{}, fmt.Errorf("file URL '%s' must include a path", u.String())
+	}
+	p, err := NewDir(filepath.Dir(path))
+	if err != nil {
+		return nil, Location{}, err
+	}
+	return p, Location{Bucket: filepath.Base(path)}, nil
+}
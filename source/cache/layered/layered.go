@@ -0,0 +1,107 @@
+package layered
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/benjohns1/invest-source/app"
+)
+
+var (
+	// Now function for retrieving the current timestamp. Override this for unit tests.
+	Now = time.Now
+
+	// OldestCacheDate is the earliest date read through the layers.
+	OldestCacheDate = time.Date(2021, time.January, 1, 0, 0, 0, 0, time.UTC)
+)
+
+// Cache layered implementation, reading through each layer in order and back-filling the layers in front of the one the data was found in, e.g. a local file cache in front of an S3 cache.
+type Cache struct {
+	Layers []app.DatedCache
+}
+
+// NewCache instantiates a layered cache, with the fastest layer first.
+func NewCache(layers ...app.DatedCache) (Cache, error) {
+	c := Cache{
+		Layers: layers,
+	}
+	if err := c.Validate(); err != nil {
+		return Cache{}, err
+	}
+	return c, nil
+}
+
+// Validate returns an error if the cache was not correctly instantiated.
+func (c Cache) Validate() error {
+	if len(c.Layers) == 0 {
+		return fmt.Errorf("cache must have at least one layer")
+	}
+	for i, l := range c.Layers {
+		if l == nil {
+			return fmt.Errorf("cache layer %d must be set", i)
+		}
+	}
+
+	return nil
+}
+
+// ReadCurrent retrieves the current day's cache data, or nil if no layer has it.
+func (c Cache) ReadCurrent(ctx context.Context) ([]byte, error) {
+	return c.ReadDate(ctx, Now().UTC())
+}
+
+// ReadDate retrieves the cache data for the given date from the first layer that has it, back-filling the layers in front of it.
+func (c Cache) ReadDate(ctx context.Context, date time.Time) ([]byte, error) {
+	for i, l := range c.Layers {
+		data, err := l.ReadDate(ctx, date)
+		if err != nil {
+			return nil, fmt.Errorf("error reading cache layer %d: %v", i, err)
+		}
+		if data == nil {
+			continue
+		}
+		for j := i - 1; j >= 0; j-- {
+			if err := c.Layers[j].WriteDate(ctx, date, data); err != nil {
+				return nil, fmt.Errorf("error back-filling cache layer %d: %v", j, err)
+			}
+		}
+		return data, nil
+	}
+	return nil, nil
+}
+
+// ReadSince retrieves all caches since the given time.
+func (c Cache) ReadSince(ctx context.Context, since time.Time) ([][]byte, error) {
+	if since.Before(OldestCacheDate) {
+		since = OldestCacheDate
+	}
+	since = since.AddDate(0, 0, -1) // offset -1 day to be inclusive
+	curr := Now().UTC()
+	var set [][]byte
+	for date := curr; ; date = date.AddDate(0, 0, -1) {
+		curr = curr.AddDate(0, 0, -1)
+		if curr.Before(since) {
+			break
+		}
+		data, err := c.ReadDate(ctx, date)
+		if err != nil {
+			return nil, err
+		}
+		if data == nil {
+			continue
+		}
+		set = append(set, data)
+	}
+	return set, nil
+}
+
+// WriteCurrent writes the data to the first layer.
+func (c Cache) WriteCurrent(ctx context.Context, data []byte) error {
+	return c.WriteDate(ctx, Now().UTC(), data)
+}
+
+// WriteDate writes the data for the given date to the first layer.
+func (c Cache) WriteDate(ctx context.Context, date time.Time, data []byte) error {
+	return c.Layers[0].WriteDate(ctx, date, data)
+}
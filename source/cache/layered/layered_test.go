@@ -0,0 +1,139 @@
+package layered_test
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/benjohns1/invest-source/app"
+	"github.com/benjohns1/invest-source/cache/keyval"
+	"github.com/benjohns1/invest-source/cache/keyval/provider"
+	"github.com/benjohns1/invest-source/cache/layered"
+)
+
+func TestCache(t *testing.T) {
+	now := func() time.Time {
+		return time.Date(2021, time.January, 3, 12, 0, 0, 0, time.UTC)
+	}
+	keyval.Now = now
+	layered.Now = now
+
+	type layer map[string][]byte
+	tests := []struct {
+		name        string
+		local       layer
+		remote      layer
+		since       time.Time
+		wantCurrent []byte
+		wantSince   [][]byte
+		wantLocal   layer
+	}{
+		{
+			name: "should return nil if no layer has data",
+		},
+		{
+			name:        "should read from the local layer without back-filling",
+			local:       layer{"2021-01-03.json": []byte("local3")},
+			remote:      layer{"2021-01-03.json": []byte("remote3")},
+			wantCurrent: []byte("local3"),
+			wantSince:   [][]byte{[]byte("local3")},
+			wantLocal:   layer{"2021-01-03.json": []byte("local3")},
+		},
+		{
+			name:        "should fall back to the remote layer and back-fill the local layer",
+			remote:      layer{"2021-01-03.json": []byte("remote3")},
+			wantCurrent: []byte("remote3"),
+			wantSince:   [][]byte{[]byte("remote3")},
+			wantLocal:   layer{"2021-01-03.json": []byte("remote3")},
+		},
+		{
+			name:  "should merge days from both layers since the given date, most recent first",
+			local: layer{"2021-01-01.json": []byte("local1"), "2021-01-03.json": []byte("local3")},
+			remote: layer{
+				"2021-01-01.json": []byte("remote1"),
+				"2021-01-02.json": []byte("remote2"),
+			},
+			wantCurrent: []byte("local3"),
+			wantSince:   [][]byte{[]byte("local3"), []byte("remote2"), []byte("local1")},
+			wantLocal: layer{
+				"2021-01-01.json": []byte("local1"),
+				"2021-01-02.json": []byte("remote2"),
+				"2021-01-03.json": []byte("local3"),
+			},
+		},
+		{
+			name:      "should only read through days since the given date",
+			remote:    layer{"2021-01-01.json": []byte("remote1"), "2021-01-02.json": []byte("remote2")},
+			since:     time.Date(2021, time.January, 2, 0, 0, 0, 0, time.UTC),
+			wantSince: [][]byte{[]byte("remote2")},
+			wantLocal: layer{"2021-01-02.json": []byte("remote2")},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			newLayer := func(entries layer) (*provider.Memory, app.DatedCache) {
+				p := provider.NewMemory()
+				for key, value := range entries {
+					if err := p.Upload(context.Background(), "bucket", key, bytes.NewReader(value)); err != nil {
+						t.Fatal(err)
+					}
+				}
+				c, err := keyval.NewDailyCache(p, "bucket", "")
+				if err != nil {
+					t.Fatal(err)
+				}
+				return p, c
+			}
+			localProvider, local := newLayer(tt.local)
+			_, remote := newLayer(tt.remote)
+			c, err := layered.NewCache(local, remote)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			current, err := c.ReadCurrent(context.Background())
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wantCurrent, current)
+
+			got, err := c.ReadSince(context.Background(), tt.since)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wantSince, got)
+
+			for key, want := range tt.wantLocal {
+				buf := &bytes.Buffer{}
+				found, err := localProvider.Download(context.Background(), "bucket", key, buf)
+				assert.NoError(t, err)
+				assert.True(t, found, "expected %s in the local layer", key)
+				assert.Equal(t, want, buf.Bytes())
+			}
+		})
+	}
+}
+
+func TestCache_WriteCurrent(t *testing.T) {
+	now := func() time.Time {
+		return time.Date(2021, time.January, 3, 12, 0, 0, 0, time.UTC)
+	}
+	keyval.Now = now
+	layered.Now = now
+
+	localProvider, remoteProvider := provider.NewMemory(), provider.NewMemory()
+	local, _ := keyval.NewDailyCache(localProvider, "bucket", "")
+	remote, _ := keyval.NewDailyCache(remoteProvider, "bucket", "")
+	c, err := layered.NewCache(local, remote)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.NoError(t, c.WriteCurrent(context.Background(), []byte("data")))
+
+	found, err := localProvider.Download(context.Background(), "bucket", "2021-01-03.json", &bytes.Buffer{})
+	assert.NoError(t, err)
+	assert.True(t, found, "expected data to be written to the local layer")
+	found, err = remoteProvider.Download(context.Background(), "bucket", "2021-01-03.json", &bytes.Buffer{})
+	assert.NoError(t, err)
+	assert.False(t, found, "expected data not to be written to the remote layer")
+}
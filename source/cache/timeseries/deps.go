@@ -0,0 +1,22 @@
+package timeseries
+
+import (
+	"io"
+	"os"
+
+	"github.com/benjohns1/invest-source/utils/filesystem"
+)
+
+var (
+	// OpenForReading opens a file for reading.
+	OpenForReading = func(filename string) (io.ReadCloser, error) { return os.Open(filename) }
+
+	// ReadDir lists a directory's entries.
+	ReadDir = os.ReadDir
+
+	// WriteFile atomically writes a local file, so a failed write never leaves a partial series or manifest.
+	WriteFile = filesystem.WriteFileAtomic
+
+	// Mkdir makes a directory if it doesn't exist.
+	Mkdir = filesystem.Mkdir
+)
@@ -0,0 +1,293 @@
+package timeseries
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/shopspring/decimal"
+
+	"github.com/benjohns1/invest-source/app"
+)
+
+// Store compacted time-series implementation, storing one JSON lines file per symbol per year.
+type Store struct {
+	Dir string
+}
+
+const (
+	manifestFile = "manifest.json"
+	dateFormat   = "2006-01-02"
+)
+
+type manifest struct {
+	Compacted map[string]string `json:"compacted"`
+}
+
+type point struct {
+	Time time.Time       `json:"t"`
+	USD  decimal.Decimal `json:"usd"`
+}
+
+// NewStore instantiates a compacted time-series store in the given directory.
+func NewStore(dir string) (Store, error) {
+	s := Store{
+		Dir: strings.TrimSuffix(strings.ReplaceAll(dir, "\\", "/"), "/"),
+	}
+	if err := s.Validate(); err != nil {
+		return Store{}, err
+	}
+	if err := Mkdir(s.Dir); err != nil {
+		return Store{}, err
+	}
+	return s, nil
+}
+
+// Validate returns an error if the store was not correctly instantiated.
+func (s Store) Validate() error {
+	if s.Dir == "" {
+		return fmt.Errorf("time-series store Dir must be set")
+	}
+
+	return nil
+}
+
+// Compacted returns the date up to which all the given symbols have been compacted, or the zero time if any have not.
+func (s Store) Compacted(symbols ...string) (time.Time, error) {
+	m, err := s.readManifest()
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	var until time.Time
+	for i, symbol := range symbols {
+		date, ok := m.Compacted[symbol]
+		if !ok {
+			return time.Time{}, nil
+		}
+		t, err := time.Parse(dateFormat, date)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("error parsing compacted date for %s: %v", symbol, err)
+		}
+		if i == 0 || t.Before(until) {
+			until = t
+		}
+	}
+	return until, nil
+}
+
+// WriteQuotes merges the quotes into each symbol's time-series, keeping the latest quote per day, and marks the symbols as compacted until the given date.
+func (s Store) WriteQuotes(until time.Time, symbols []string, quotes []app.Quote) error {
+	type seriesKey struct {
+		symbol string
+		year   int
+	}
+	grouped := make(map[seriesKey][]point)
+	for _, q := range quotes {
+		t := q.Time.UTC()
+		k := seriesKey{q.Symbol, t.Year()}
+		grouped[k] = append(grouped[k], point{Time: t, USD: q.USD})
+	}
+
+	for k, points := range grouped {
+		if err := s.mergeSeries(k.symbol, k.year, points); err != nil {
+			return err
+		}
+	}
+
+	m, err := s.readManifest()
+	if err != nil {
+		return err
+	}
+	for _, symbol := range symbols {
+		m.Compacted[symbol] = until.UTC().Format(dateFormat)
+	}
+	return s.writeManifest(m)
+}
+
+// ReadSince calls each with the quotes for the given symbols for each day since the given time, most recent day first.
+// The symbols' series are read a year at a time, so only a year of quotes is held at once.
+func (s Store) ReadSince(since time.Time, symbols []string, each func(quotes []app.Quote) error) error {
+	since = since.UTC()
+	sinceDate := since.Format(dateFormat)
+	yearSymbols := make(map[int][]string)
+	for _, symbol := range symbols {
+		years, err := s.seriesYears(symbol, since.Year())
+		if err != nil {
+			return err
+		}
+		for _, year := range years {
+			yearSymbols[year] = append(yearSymbols[year], symbol)
+		}
+	}
+	years := make([]int, 0, len(yearSymbols))
+	for year := range yearSymbols {
+		years = append(years, year)
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(years)))
+
+	for _, year := range years {
+		byDate := make(map[string][]app.Quote)
+		for _, symbol := range yearSymbols[year] {
+			points, err := s.readSeries(symbol, year)
+			if err != nil {
+				return err
+			}
+			for _, p := range points {
+				date := p.Time.Format(dateFormat)
+				if date < sinceDate {
+					continue
+				}
+				byDate[date] = append(byDate[date], app.Quote{Time: p.Time, Symbol: symbol, USD: p.USD})
+			}
+		}
+
+		dates := make([]string, 0, len(byDate))
+		for date := range byDate {
+			dates = append(dates, date)
+		}
+		sort.Sort(sort.Reverse(sort.StringSlice(dates)))
+		for _, date := range dates {
+			if err := each(byDate[date]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (s Store) mergeSeries(symbol string, year int, points []point) error {
+	existing, err := s.readSeries(symbol, year)
+	if err != nil {
+		return err
+	}
+
+	byDate := make(map[string]point, len(existing)+len(points))
+	for _, p := range append(existing, points...) {
+		date := p.Time.Format(dateFormat)
+		if prev, ok := byDate[date]; ok && prev.Time.After(p.Time) {
+			continue
+		}
+		byDate[date] = p
+	}
+
+	merged := make([]point, 0, len(byDate))
+	for _, p := range byDate {
+		merged = append(merged, p)
+	}
+	sort.Slice(merged, func(i, j int) bool { return merged[i].Time.Before(merged[j].Time) })
+
+	return s.writeSeries(symbol, year, merged)
+}
+
+func (s Store) seriesDir(symbol string) string {
+	return fmt.Sprintf("%s/%s", s.Dir, url.PathEscape(symbol))
+}
+
+func (s Store) seriesFilename(symbol string, year int) string {
+	return fmt.Sprintf("%s/%d.jsonl", s.seriesDir(symbol), year)
+}
+
+// seriesYears returns the years of the symbol's stored time-series files from the given year on, in order.
+func (s Store) seriesYears(symbol string, from int) ([]int, error) {
+	entries, err := ReadDir(s.seriesDir(symbol))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	years := make([]int, 0, len(entries))
+	for _, e := range entries {
+		name, ok := strings.CutSuffix(e.Name(), ".jsonl")
+		if !ok || e.IsDir() {
+			continue
+		}
+		year, err := strconv.Atoi(name)
+		if err != nil || year < from {
+			continue
+		}
+		years = append(years, year)
+	}
+	sort.Ints(years)
+	return years, nil
+}
+
+func (s Store) readSeries(symbol string, year int) ([]point, error) {
+	f, err := OpenForReading(s.seriesFilename(symbol, year))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+
+	var points []point
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var p point
+		if err := json.Unmarshal(scanner.Bytes(), &p); err != nil {
+			return nil, fmt.Errorf("error parsing %s time-series for %d, line %d: %v", symbol, year, line, err)
+		}
+		points = append(points, p)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading %s time-series for %d: %v", symbol, year, err)
+	}
+	return points, nil
+}
+
+func (s Store) writeSeries(symbol string, year int, points []point) error {
+	if err := Mkdir(s.seriesDir(symbol)); err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, p := range points {
+		if err := enc.Encode(p); err != nil {
+			return fmt.Errorf("error writing %s time-series for %d: %v", symbol, year, err)
+		}
+	}
+	return WriteFile(s.seriesFilename(symbol, year), buf.Bytes())
+}
+
+func (s Store) readManifest() (manifest, error) {
+	m := manifest{Compacted: make(map[string]string)}
+	f, err := OpenForReading(fmt.Sprintf("%s/%s", s.Dir, manifestFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return m, nil
+		}
+		return m, err
+	}
+	defer func() { _ = f.Close() }()
+
+	if err := json.NewDecoder(f).Decode(&m); err != nil {
+		return m, fmt.Errorf("error parsing time-series manifest: %v", err)
+	}
+	if m.Compacted == nil {
+		m.Compacted = make(map[string]string)
+	}
+	return m, nil
+}
+
+func (s Store) writeManifest(m manifest) error {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return fmt.Errorf("error writing time-series manifest: %v", err)
+	}
+	return WriteFile(fmt.Sprintf("%s/%s", s.Dir, manifestFile), append(data, '\n'))
+}
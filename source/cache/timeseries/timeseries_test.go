@@ -0,0 +1,217 @@
+package timeseries_test
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/benjohns1/invest-source/app"
+	"github.com/benjohns1/invest-source/cache/timeseries"
+)
+
+func quote(symbol, datetime, usd string) app.Quote {
+	t, err := time.Parse("2006-01-02 15:04", datetime)
+	if err != nil {
+		panic(err)
+	}
+	return app.Quote{Time: t, Symbol: symbol, USD: decimal.RequireFromString(usd)}
+}
+
+// days formats quotes grouped by day, to compare without decimal and time representation differences.
+func days(set [][]app.Quote) [][]string {
+	out := make([][]string, len(set))
+	for i, quotes := range set {
+		out[i] = make([]string, len(quotes))
+		for j, q := range quotes {
+			out[i][j] = fmt.Sprintf("%s %s %s", q.Time.Format("2006-01-02 15:04"), q.Symbol, q.USD)
+		}
+	}
+	return out
+}
+
+// readSince collects the days of quotes the store reads.
+func readSince(s timeseries.Store, since time.Time, symbols ...string) ([][]app.Quote, error) {
+	var set [][]app.Quote
+	err := s.ReadSince(since, symbols, func(quotes []app.Quote) error {
+		set = append(set, quotes)
+		return nil
+	})
+	return set, err
+}
+
+func date(s string) time.Time {
+	t, err := time.Parse("2006-01-02", s)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+func TestStore_WriteQuotes_ReadSince(t *testing.T) {
+	s, err := timeseries.NewStore(t.TempDir())
+	assert.NoError(t, err)
+
+	assert.NoError(t, s.WriteQuotes(date("2021-01-01"), []string{"BTC", "ETH"}, []app.Quote{
+		quote("BTC", "2020-12-31 12:00", "29000"),
+		quote("BTC", "2021-01-01 09:00", "29300"),
+		quote("BTC", "2021-01-01 12:00", "29400"),
+		quote("ETH", "2021-01-01 12:00", "730"),
+	}))
+	// merging into the stored series keeps the latest quote per day, whichever write it came from
+	assert.NoError(t, s.WriteQuotes(date("2021-01-02"), []string{"BTC", "ETH"}, []app.Quote{
+		quote("BTC", "2021-01-01 06:00", "29100"),
+		quote("BTC", "2021-01-02 12:00", "32000"),
+		quote("ETH", "2021-01-01 18:00", "740"),
+	}))
+
+	tests := []struct {
+		name    string
+		since   time.Time
+		symbols []string
+		want    [][]string
+	}{
+		{
+			name:    "should read every stored year with a zero since",
+			symbols: []string{"BTC", "ETH"},
+			want: [][]string{
+				{"2021-01-02 12:00 BTC 32000"},
+				{"2021-01-01 12:00 BTC 29400", "2021-01-01 18:00 ETH 740"},
+				{"2020-12-31 12:00 BTC 29000"},
+			},
+		},
+		{
+			name:    "should read days since the given date, most recent first",
+			since:   date("2021-01-01"),
+			symbols: []string{"BTC"},
+			want: [][]string{
+				{"2021-01-02 12:00 BTC 32000"},
+				{"2021-01-01 12:00 BTC 29400"},
+			},
+		},
+		{
+			name:    "should read nothing for a symbol that isn't stored",
+			symbols: []string{"DOGE"},
+			want:    [][]string{},
+		},
+		{
+			name:    "should read nothing since a date after the stored years",
+			since:   date("2022-01-01"),
+			symbols: []string{"BTC", "ETH"},
+			want:    [][]string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := readSince(s, tt.since, tt.symbols...)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, days(got))
+		})
+	}
+}
+
+func TestStore_ReadSince_storedYears(t *testing.T) {
+	s, err := timeseries.NewStore(t.TempDir())
+	assert.NoError(t, err)
+	assert.NoError(t, s.WriteQuotes(date("2021-01-01"), []string{"BTC"}, []app.Quote{
+		quote("BTC", "2020-12-31 12:00", "29000"),
+		quote("BTC", "2021-01-01 12:00", "29400"),
+	}))
+
+	orig := timeseries.OpenForReading
+	t.Cleanup(func() { timeseries.OpenForReading = orig })
+	var opened []string
+	timeseries.OpenForReading = func(filename string) (io.ReadCloser, error) {
+		opened = append(opened, filename)
+		return orig(filename)
+	}
+
+	_, err = readSince(s, time.Time{}, "BTC", "ETH")
+	assert.NoError(t, err)
+	assert.Len(t, opened, 2, "should only open the years actually stored")
+}
+
+func TestStore_ReadSince_stop(t *testing.T) {
+	s, err := timeseries.NewStore(t.TempDir())
+	assert.NoError(t, err)
+	assert.NoError(t, s.WriteQuotes(date("2021-01-02"), []string{"BTC"}, []app.Quote{
+		quote("BTC", "2020-12-31 12:00", "29000"),
+		quote("BTC", "2021-01-01 12:00", "29400"),
+		quote("BTC", "2021-01-02 12:00", "32000"),
+	}))
+
+	orig := timeseries.OpenForReading
+	t.Cleanup(func() { timeseries.OpenForReading = orig })
+	var opened []string
+	timeseries.OpenForReading = func(filename string) (io.ReadCloser, error) {
+		opened = append(opened, filename)
+		return orig(filename)
+	}
+
+	read := 0
+	err = s.ReadSince(time.Time{}, []string{"BTC"}, func([]app.Quote) error {
+		read++
+		return fmt.Errorf("output failed")
+	})
+	assert.EqualError(t, err, "output failed")
+	assert.Equal(t, 1, read, "should stop at the first error")
+	assert.Len(t, opened, 1, "should read only the most recent year before stopping")
+}
+
+func TestStore_WriteQuotes_writeError(t *testing.T) {
+	s, err := timeseries.NewStore(t.TempDir())
+	assert.NoError(t, err)
+	assert.NoError(t, s.WriteQuotes(date("2021-01-01"), []string{"BTC"}, []app.Quote{quote("BTC", "2021-01-01 12:00", "29400")}))
+
+	orig := timeseries.WriteFile
+	t.Cleanup(func() { timeseries.WriteFile = orig })
+	timeseries.WriteFile = func(path string, data []byte) error {
+		if strings.HasSuffix(path, "manifest.json") {
+			return fmt.Errorf("disk full")
+		}
+		return orig(path, data)
+	}
+
+	assert.Error(t, s.WriteQuotes(date("2021-01-02"), []string{"BTC"}, []app.Quote{quote("BTC", "2021-01-02 12:00", "32000")}))
+	got, err := s.Compacted("BTC")
+	assert.NoError(t, err)
+	assert.Equal(t, date("2021-01-01"), got, "should keep the last manifest written in full")
+}
+
+func TestStore_Compacted(t *testing.T) {
+	s, err := timeseries.NewStore(t.TempDir())
+	assert.NoError(t, err)
+
+	got, err := s.Compacted("BTC")
+	assert.NoError(t, err)
+	assert.True(t, got.IsZero(), "should be zero before anything is compacted")
+
+	assert.NoError(t, s.WriteQuotes(date("2021-01-03"), []string{"BTC"}, nil))
+	assert.NoError(t, s.WriteQuotes(date("2021-01-02"), []string{"ETH"}, nil))
+
+	tests := []struct {
+		name    string
+		symbols []string
+		want    time.Time
+	}{
+		{name: "should return a symbol's compacted date", symbols: []string{"BTC"}, want: date("2021-01-03")},
+		{name: "should return the earliest compacted date of the symbols", symbols: []string{"BTC", "ETH"}, want: date("2021-01-02")},
+		{name: "should return zero if any symbol isn't compacted", symbols: []string{"BTC", "DOGE"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := s.Compacted(tt.symbols...)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestNewStore(t *testing.T) {
+	_, err := timeseries.NewStore("")
+	assert.Error(t, err)
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+
+	"github.com/benjohns1/invest-source/app"
+	"github.com/benjohns1/invest-source/cache/file"
+	"github.com/benjohns1/invest-source/cache/keyval"
+	keyvalProvider "github.com/benjohns1/invest-source/cache/keyval/provider"
+	cmdConfig "github.com/benjohns1/invest-source/cmd/internal/config"
+	"github.com/benjohns1/invest-source/tracing"
+	"github.com/benjohns1/invest-source/utils/logging"
+)
+
+type config struct {
+	CacheDirectory  string
+	CacheURL        string
+	Since           string
+	Direction       string
+	DryRun          bool `mapstructure:"dry-run"`
+	LogLevel        string
+	LogFormat       string
+	TracingEndpoint string
+}
+
+func parseCfg() config {
+	pflag.String("since", "2021-01-01", "sync cache data since this date")
+	pflag.String("direction", string(app.SyncBoth), "sync direction: 'up' copies local data to CacheURL, 'down' copies CacheURL data to the local CacheDirectory, 'both' copies missing data both ways")
+	pflag.Bool("dry-run", false, "log the entries that would be synced without copying them")
+	pflag.Parse()
+	if err := viper.BindPFlags(pflag.CommandLine); err != nil {
+		logging.Fatal(slog.Default(), "error binding flags", "error", err)
+	}
+
+	viper.SetDefault("CacheDirectory", "./data/cache")
+	viper.SetDefault("LogLevel", "info")
+	viper.SetDefault("LogFormat", logging.FormatText)
+
+	cmdConfig.ReadFile("ConfigFile", "config.yaml")
+	cmdConfig.ReadFile("SecretConfigFile", ".secrets.yaml")
+
+	cfg := config{}
+	if err := viper.Unmarshal(&cfg); err != nil {
+		logging.Fatal(slog.Default(), "error parsing config", "error", err)
+	}
+
+	return cfg
+}
+
+func main() {
+	slog.Info("parsing config")
+	cfg := parseCfg()
+
+	l, err := logging.New(os.Stdout, cfg.LogFormat, cfg.LogLevel)
+	if err != nil {
+		logging.Fatal(slog.Default(), "error creating logger", "error", err)
+	}
+	slog.SetDefault(l)
+	l.Debug("parsed configs", "config", fmt.Sprintf("%#v", cfg))
+
+	ctx := context.Background()
+
+	if cfg.CacheURL == "" {
+		logging.Fatal(l, "CacheURL must be set to sync the local cache with")
+	}
+	since, err := time.Parse(app.DateFormat, cfg.Since)
+	if err != nil {
+		logging.Fatal(l, fmt.Sprintf("error parsing 'since' date, should be of the form '%s'", app.DateFormat), "since", cfg.Since, "error", err)
+	}
+
+	l.Info("injecting dependencies")
+	t, err := tracing.New(ctx, cfg.TracingEndpoint, "invest-source-cache-sync")
+	if err != nil {
+		logging.Fatal(l, "error creating tracing", "error", err)
+	}
+	local, err := file.NewDailyCache(cfg.CacheDirectory)
+	if err != nil {
+		logging.Fatal(l, "error creating local cache", "error", err)
+	}
+	openRemote := keyvalProvider.Open
+	if app.SyncDirection(cfg.Direction) == app.SyncUp {
+		// syncing up overwrites changed remote entries
+		openRemote = keyvalProvider.OpenOverwriting
+	}
+	p, loc, err := openRemote(ctx, cfg.CacheURL)
+	if err != nil {
+		logging.Fatal(l, "error opening CacheURL", "error", err)
+	}
+	remote, err := keyval.NewDailyCache(p, loc.Bucket, loc.Prefix)
+	if err != nil {
+		logging.Fatal(l, "error creating remote cache", "error", err)
+	}
+	remote.Log = l
+	a := app.App{
+		Config: app.Config{
+			Log: l,
+		},
+	}
+
+	l.Info("syncing caches", "cacheDirectory", cfg.CacheDirectory, "cacheURL", cfg.CacheURL)
+	err = t.Run(ctx, "sync-caches", func(ctx context.Context) error {
+		_, err := app.SyncCaches(ctx, a, t.Cache("file", local), t.Cache("keyval", remote), app.SyncOptions{
+			Since:     since,
+			Direction: app.SyncDirection(cfg.Direction),
+			DryRun:    cfg.DryRun,
+		})
+		return err
+	})
+	if shutdownErr := t.Shutdown(ctx); shutdownErr != nil {
+		l.Error("error exporting spans", "tracingEndpoint", cfg.TracingEndpoint, "error", shutdownErr)
+	}
+	if err != nil {
+		logging.Fatal(l, "error syncing caches", "error", err)
+	}
+
+	l.Info("complete")
+}
@@ -2,18 +2,31 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
+	"net/url"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/aws/aws-lambda-go/lambda"
 	"github.com/aws/aws-lambda-go/lambdacontext"
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/session"
+	awsConfig "github.com/aws/aws-sdk-go-v2/config"
 	"github.com/benjohns1/invest-source/app"
 	"github.com/benjohns1/invest-source/cache/keyval"
 	keyvalProvider "github.com/benjohns1/invest-source/cache/keyval/provider"
+	cmdConfig "github.com/benjohns1/invest-source/cmd/internal/config"
+	"github.com/benjohns1/invest-source/metrics"
+	"github.com/benjohns1/invest-source/output/csv"
+	"github.com/benjohns1/invest-source/output/destination"
+	"github.com/benjohns1/invest-source/output/gnucash"
+	"github.com/benjohns1/invest-source/provider/coingecko"
 	"github.com/benjohns1/invest-source/provider/coinmarketcap"
+	"github.com/benjohns1/invest-source/tracing"
+	"github.com/benjohns1/invest-source/utils/logging"
+	"github.com/benjohns1/invest-source/utils/secret"
 )
 
 func main() {
@@ -30,7 +43,7 @@ func main() {
 func getAWSMeta(ctx context.Context) lambdacontext.LambdaContext {
 	meta, _ := lambdacontext.FromContext(ctx)
 	if meta == nil {
-		log.Println("lambda context not found, using default")
+		slog.Warn("lambda context not found, using default")
 		meta = &lambdacontext.LambdaContext{}
 	}
 	return *meta
@@ -44,70 +57,367 @@ type application struct {
 func (a application) Provider() app.Provider { return a.cfg.Provider }
 
 // Cache ...
-func (a application) Cache() app.Cache { return a.cfg.Cache }
+func (a application) Cache() app.Cache { return a.cfg.Instruments.Cache("keyval", a.cfg.Cache) }
 
 // Log ...
 func (a application) Log() app.Log { return a.cfg.Log }
 
+// Output ...
+func (a application) Output() app.Output { return a.cfg.Output }
+
+// Store ...
+func (a application) Store() app.QuoteStore { return nil }
+
+// Converter ...
+func (a application) Converter() app.QuoteConverter { return nil }
+
+// handleRequest runs the use-cases with a logger, cache and provider that record the request id with every log, traced under a span for the request.
 func (a application) handleRequest(ctx context.Context) error {
 	meta := getAWSMeta(ctx)
-	log.Printf("request %s started", meta.AwsRequestID)
-	defer log.Printf("request %s complete", meta.AwsRequestID)
-	return app.CacheDailySourceData(ctx, a)
+	a.cfg.Log = a.cfg.Log.With("requestId", meta.AwsRequestID)
+	a.cfg.Cache.Log = a.cfg.Log
+	p, err := createProvider(a.cfg, a.cfg.Cache, a.cfg.Log)
+	if err != nil {
+		return err
+	}
+	a.cfg.Provider = p
+
+	a.cfg.Log.Info("request started")
+	defer a.cfg.Log.Info("request complete")
+	defer a.export(ctx)
+	return a.cfg.Instruments.Tracing.Run(ctx, "handle-request", a.runUseCases)
 }
 
+// runUseCases caches the day's source data, then publishes the output if OutputURL is set.
+func (a application) runUseCases(ctx context.Context) error {
+	err := a.cfg.Instruments.Run(ctx, "cache-daily-source-data", func(ctx context.Context) error { return app.CacheDailySourceData(ctx, a) })
+	if errors.Is(err, coinmarketcap.ErrBudgetExceeded) {
+		a.cfg.Log.Warn("refused to query CoinMarketCap, raise CoinMarketCapMonthlyCreditBudget or wait until next month", "provider", "coinmarketcap", "error", err)
+	}
+	if err != nil || a.cfg.Output == nil {
+		return err
+	}
+
+	since := app.Now().UTC().AddDate(0, 0, -a.cfg.OutputDays).Format(app.DateFormat)
+	a.cfg.Log.Info("publishing output", "since", since, "outputURL", a.cfg.OutputURL)
+	return a.cfg.Instruments.Run(ctx, "output-daily-quotes", func(ctx context.Context) error {
+		return app.OutputDailyQuotes(ctx, a, since, a.cfg.OutputSymbols)
+	})
+}
+
+// export flushes the request's spans, before the lambda is frozen, and pushes the metrics to the MetricsPushgatewayURL if set, since the lambda can't be scraped. Failing to export doesn't fail the request.
+// Each execution environment keeps its own counters between requests, so pushes are grouped by its log stream name, to keep concurrent environments from replacing each other's metrics.
+func (a application) export(ctx context.Context) {
+	if err := a.cfg.Instruments.Tracing.Flush(ctx); err != nil {
+		a.cfg.Log.Error("error exporting spans", "tracingEndpoint", a.cfg.TracingEndpoint, "error", err)
+	}
+	if a.cfg.MetricsPushgatewayURL == "" {
+		return
+	}
+	if err := a.cfg.Instruments.Metrics.Push(a.cfg.MetricsPushgatewayURL, "invest-source-lambda", a.cfg.MetricsInstance); err != nil {
+		a.cfg.Log.Error("error pushing metrics", "pushgatewayURL", a.cfg.MetricsPushgatewayURL, "error", err)
+	}
+}
+
+// createApp creates a JSON logger at the LogLevel, so logs can be searched in CloudWatch, and the application's dependencies.
 func createApp() (application, error) {
-	log.Println("parsing config")
-	cfg := parseCfg()
+	l, err := logging.New(os.Stdout, logging.FormatJSON, os.Getenv("LogLevel"))
+	if err != nil {
+		l, _ = logging.New(os.Stdout, logging.FormatJSON, "")
+		l.Warn("invalid LogLevel, defaulting to info", "error", err)
+	}
+	slog.SetDefault(l)
+
+	l.Info("parsing config")
+	cfg := parseCfg(l)
+	if cfg.CoinMarketCapApiKey, err = secret.Resolve(context.Background(), cfg.CoinMarketCapApiKey.Reveal()); err != nil {
+		return application{}, fmt.Errorf("error resolving CoinMarketCapApiKey: %v", err)
+	}
 
-	log.Println("injecting dependencies")
-	p, err := coinmarketcap.NewCoinMarketCapProvider(cfg.CoinMarketCapApiKey)
+	l.Info("injecting dependencies")
+	t, err := tracing.New(context.Background(), cfg.TracingEndpoint, "invest-source-lambda")
 	if err != nil {
 		return application{}, err
 	}
-
-	sess, err := session.NewSession(&aws.Config{
-		Endpoint:         aws.String(cfg.AWSEndpoint),
-		S3ForcePathStyle: aws.Bool(true),
-		Region:           aws.String(cfg.AWSRegion),
-	})
+	cfg.Instruments = cmdConfig.Instruments{Metrics: metrics.New(), Tracing: t}
+	c, err := createCache(cfg)
 	if err != nil {
-		return application{}, fmt.Errorf("error creating AWS session: %v", err)
+		return application{}, err
 	}
+	c.Log = l
 
-	s3, err := keyvalProvider.NewS3(sess)
-	c, err := keyval.NewDailyCache(s3, cfg.CacheS3Bucket, "")
+	// validate the providers' config up front, they're recreated with each request's logger
+	p, err := createProvider(cfg, c, l)
 	if err != nil {
 		return application{}, err
 	}
 
+	if cfg.OutputURL != "" {
+		d, err := destination.Open(context.Background(), cfg.OutputURL)
+		if err != nil {
+			return application{}, err
+		}
+		gc, err := gnuCashConfig(cfg)
+		if err != nil {
+			return application{}, err
+		}
+		o, err := csv.NewGnuCashCSVWithDestination(d, gc)
+		if err != nil {
+			return application{}, err
+		}
+		cfg.Output = cfg.Instruments.Metrics.Output(o)
+	}
+
 	cfg.Provider = p
 	cfg.Cache = c
-	cfg.Log = log.New(os.Stdout, "app: ", log.LstdFlags)
+	cfg.Log = l
 
 	return application{
 		cfg: cfg,
 	}, nil
 }
 
+// createProvider creates a failover chain of the quote providers listed in ProviderNames, in priority order, or a consensus of them if ConsensusTolerance is set.
+func createProvider(cfg config, c keyval.Cache, l app.Log) (app.Provider, error) {
+	return cmdConfig.CreateProvider(l, cfg.Instruments, cfg.ProviderNames, cfg.ConsensusTolerance, false, func(name string) (app.Provider, error) {
+		switch name {
+		case "coinmarketcap":
+			return newCoinMarketCapProvider(cfg, c)
+		case "coingecko":
+			return newCoinGeckoProvider(cfg)
+		default:
+			return nil, fmt.Errorf("unknown provider '%s', must be one of coinmarketcap or coingecko", name)
+		}
+	})
+}
+
+// newCoinMarketCapProvider creates the CoinMarketCap provider, with IDs parsed from a comma-separated list of SYMBOL:ID pairs, and its credit usage recorded in a ledger kept with the cache.
+func newCoinMarketCapProvider(cfg config, c keyval.Cache) (app.Provider, error) {
+	p, err := coinmarketcap.NewCoinMarketCapProvider(cfg.CoinMarketCapApiKey.Reveal())
+	if err != nil {
+		return nil, err
+	}
+	ledger, err := c.Ledger(creditsLedger)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.CoinMarketCapMode != "" {
+		p.Mode = cfg.CoinMarketCapMode
+	}
+	if cfg.CoinMarketCapMaxPages != 0 {
+		p.MaxPages = cfg.CoinMarketCapMaxPages
+	}
+	p.MonthlyCreditBudget, p.Ledger = cfg.CoinMarketCapMonthlyCreditBudget, ledger
+	if cfg.CoinMarketCapIDs != "" {
+		p.IDs = make(map[string]string)
+		for _, pair := range strings.Split(cfg.CoinMarketCapIDs, ",") {
+			kv := strings.SplitN(pair, ":", 2)
+			if len(kv) != 2 || strings.TrimSpace(kv[0]) == "" || strings.TrimSpace(kv[1]) == "" {
+				return nil, fmt.Errorf("invalid CoinMarketCapIDs pair '%s', must be SYMBOL:ID", pair)
+			}
+			p.IDs[strings.ToUpper(strings.TrimSpace(kv[0]))] = strings.TrimSpace(kv[1])
+		}
+	}
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func newCoinGeckoProvider(cfg config) (app.Provider, error) {
+	p, err := coingecko.NewCoinGeckoProvider(nil)
+	if err != nil {
+		return nil, err
+	}
+	p.PageDelay = cfg.CoinGeckoPageDelay
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// gnuCashConfig creates the output CSV's GnuCash config, with commodities parsed from a comma-separated list of SYMBOL:NAMESPACE or SYMBOL:NAMESPACE:DISPLAY entries, and comma-separated columns.
+func gnuCashConfig(cfg config) (csv.GnuCashConfig, error) {
+	gc := csv.GnuCashConfig{
+		Commodities: gnucash.Commodities{Namespace: cfg.GnuCashNamespace},
+		DateFormat:  cfg.GnuCashDateFormat,
+	}
+	if cfg.GnuCashSymbols != "" {
+		gc.Commodities.Symbols = make(map[string]gnucash.Commodity)
+		for _, entry := range strings.Split(cfg.GnuCashSymbols, ",") {
+			parts := strings.Split(entry, ":")
+			if len(parts) < 2 || len(parts) > 3 || strings.TrimSpace(parts[0]) == "" {
+				return csv.GnuCashConfig{}, fmt.Errorf("invalid GnuCashSymbols entry '%s', must be SYMBOL:NAMESPACE or SYMBOL:NAMESPACE:DISPLAY", entry)
+			}
+			c := gnucash.Commodity{Namespace: strings.TrimSpace(parts[1])}
+			if len(parts) == 3 {
+				c.Symbol = strings.TrimSpace(parts[2])
+			}
+			gc.Commodities.Symbols[strings.TrimSpace(parts[0])] = c
+		}
+	}
+	if cfg.GnuCashColumns != "" {
+		for _, column := range strings.Split(cfg.GnuCashColumns, ",") {
+			gc.Columns = append(gc.Columns, strings.TrimSpace(column))
+		}
+	}
+	return gc, nil
+}
+
+// creditsLedger is the name of the ledger of CoinMarketCap credits used, shared with the CLI.
+const creditsLedger = "coinmarketcap-credits"
+
+// createCache creates the daily cache from the CacheURL if set, otherwise from the AWS configs.
+func createCache(cfg config) (keyval.Cache, error) {
+	if cfg.CacheURL != "" {
+		p, loc, err := keyvalProvider.Open(context.Background(), cacheURL(cfg))
+		if err != nil {
+			return keyval.Cache{}, err
+		}
+		return keyval.NewDailyCache(p, loc.Bucket, loc.Prefix)
+	}
+
+	var loadOpts []func(*awsConfig.LoadOptions) error
+	if cfg.AWSRegion != "" {
+		loadOpts = append(loadOpts, awsConfig.WithRegion(cfg.AWSRegion))
+	}
+	awsCfg, err := awsConfig.LoadDefaultConfig(context.Background(), loadOpts...)
+	if err != nil {
+		return keyval.Cache{}, fmt.Errorf("error loading AWS config: %v", err)
+	}
+
+	s3, err := keyvalProvider.NewS3(keyvalProvider.NewS3Client(awsCfg, cfg.AWSEndpoint), keyvalProvider.S3Options{
+		ServerSideEncryption: cfg.CacheS3ServerSideEncryption,
+		SSEKMSKeyID:          cfg.CacheS3KMSKeyID,
+		StorageClass:         cfg.CacheS3StorageClass,
+		NoOverwrite:          cfg.CacheS3NoOverwrite,
+	})
+	if err != nil {
+		return keyval.Cache{}, err
+	}
+	return keyval.NewDailyCache(s3, cfg.CacheS3Bucket, "")
+}
+
+// cacheURL applies CacheS3NoOverwrite to an s3 CacheURL that doesn't set no_overwrite itself, so both ways of configuring the cache behave the same.
+func cacheURL(cfg config) string {
+	u, err := url.Parse(cfg.CacheURL)
+	if err != nil || u.Scheme != "s3" || u.Query().Has("no_overwrite") {
+		return cfg.CacheURL
+	}
+	q := u.Query()
+	q.Set("no_overwrite", strconv.FormatBool(cfg.CacheS3NoOverwrite))
+	u.RawQuery = q.Encode()
+	return u.String()
+}
+
 type config struct {
-	CoinMarketCapApiKey string
-	AWSEndpoint         string
-	AWSRegion           string
-	CacheS3Bucket       string
-	Provider            app.Provider
-	Cache               app.Cache
-	Log                 app.Log
+	ProviderNames                    string
+	ConsensusTolerance               string
+	CoinMarketCapApiKey              secret.String
+	CoinMarketCapMode                string
+	CoinMarketCapMaxPages            int
+	CoinMarketCapIDs                 string
+	CoinMarketCapMonthlyCreditBudget int
+	CoinGeckoPageDelay               time.Duration
+	AWSEndpoint                      string
+	AWSRegion                        string
+	CacheS3Bucket                    string
+	CacheS3ServerSideEncryption      string
+	CacheS3KMSKeyID                  string
+	CacheS3StorageClass              string
+	CacheS3NoOverwrite               bool
+	CacheURL                         string
+	OutputURL                        string
+	OutputSymbols                    []string
+	OutputDays                       int
+	GnuCashNamespace                 string
+	GnuCashSymbols                   string
+	GnuCashColumns                   string
+	GnuCashDateFormat                string
+	MetricsPushgatewayURL            string
+	MetricsInstance                  string
+	TracingEndpoint                  string
+	Instruments                      cmdConfig.Instruments
+	Provider                         app.Provider
+	Cache                            keyval.Cache
+	Log                              *slog.Logger
+	Output                           app.Output
 }
 
-func parseCfg() config {
+func parseCfg(l *slog.Logger) config {
 	cfg := config{
-		CoinMarketCapApiKey: os.Getenv("CoinMarketCapApiKey"),
-		AWSEndpoint:         os.Getenv("AWSEndpoint"),
-		AWSRegion:           os.Getenv("AWSRegion"),
-		CacheS3Bucket:       os.Getenv("CacheS3Bucket"),
+		ProviderNames:               os.Getenv("Provider"),
+		ConsensusTolerance:          os.Getenv("ConsensusTolerance"),
+		CoinMarketCapApiKey:         secret.String(os.Getenv("CoinMarketCapApiKey")),
+		CoinMarketCapMode:           os.Getenv("CoinMarketCapMode"),
+		CoinMarketCapIDs:            os.Getenv("CoinMarketCapIDs"),
+		AWSEndpoint:                 os.Getenv("AWSEndpoint"),
+		AWSRegion:                   os.Getenv("AWSRegion"),
+		CacheS3Bucket:               os.Getenv("CacheS3Bucket"),
+		CacheS3ServerSideEncryption: os.Getenv("CacheS3ServerSideEncryption"),
+		CacheS3KMSKeyID:             os.Getenv("CacheS3KMSKeyID"),
+		CacheS3StorageClass:         os.Getenv("CacheS3StorageClass"),
+		CacheS3NoOverwrite:          true,
+		CacheURL:                    os.Getenv("CacheURL"),
+		OutputURL:                   os.Getenv("OutputURL"),
+		GnuCashNamespace:            os.Getenv("GnuCashNamespace"),
+		GnuCashSymbols:              os.Getenv("GnuCashSymbols"),
+		GnuCashColumns:              os.Getenv("GnuCashColumns"),
+		GnuCashDateFormat:           os.Getenv("GnuCashDateFormat"),
+		OutputDays:                  30,
+		MetricsPushgatewayURL:       os.Getenv("MetricsPushgatewayURL"),
+		MetricsInstance:             os.Getenv("AWS_LAMBDA_LOG_STREAM_NAME"),
+		TracingEndpoint:             os.Getenv("TracingEndpoint"),
+	}
+	if cfg.ProviderNames == "" {
+		cfg.ProviderNames = "coinmarketcap"
+	}
+	if cfg.MetricsInstance == "" {
+		// outside of Lambda, e.g. under the runtime interface emulator
+		cfg.MetricsInstance, _ = os.Hostname()
+	}
+	if maxPages := os.Getenv("CoinMarketCapMaxPages"); maxPages != "" {
+		var err error
+		if cfg.CoinMarketCapMaxPages, err = strconv.Atoi(maxPages); err != nil {
+			l.Warn("invalid CoinMarketCapMaxPages value, defaulting to 1", "value", maxPages, "error", err)
+			cfg.CoinMarketCapMaxPages = 1
+		}
+	}
+	if budget := os.Getenv("CoinMarketCapMonthlyCreditBudget"); budget != "" {
+		var err error
+		if cfg.CoinMarketCapMonthlyCreditBudget, err = strconv.Atoi(budget); err != nil {
+			l.Warn("invalid CoinMarketCapMonthlyCreditBudget value, defaulting to no budget", "value", budget, "error", err)
+			cfg.CoinMarketCapMonthlyCreditBudget = 0
+		}
+	}
+	cfg.CoinGeckoPageDelay = 5 * time.Second
+	if delay := os.Getenv("CoinGeckoPageDelay"); delay != "" {
+		var err error
+		if cfg.CoinGeckoPageDelay, err = time.ParseDuration(delay); err != nil {
+			l.Warn("invalid CoinGeckoPageDelay value, defaulting to 5s", "value", delay, "error", err)
+			cfg.CoinGeckoPageDelay = 5 * time.Second
+		}
+	}
+	if symbols := os.Getenv("OutputSymbols"); symbols != "" {
+		for _, symbol := range strings.Split(symbols, ",") {
+			cfg.OutputSymbols = append(cfg.OutputSymbols, strings.TrimSpace(symbol))
+		}
+	}
+	if days := os.Getenv("OutputDays"); days != "" {
+		var err error
+		if cfg.OutputDays, err = strconv.Atoi(days); err != nil {
+			l.Warn("invalid OutputDays value, defaulting to 30", "value", days, "error", err)
+			cfg.OutputDays = 30
+		}
+	}
+	if noOverwrite := os.Getenv("CacheS3NoOverwrite"); noOverwrite != "" {
+		var err error
+		if cfg.CacheS3NoOverwrite, err = strconv.ParseBool(noOverwrite); err != nil {
+			l.Warn("invalid CacheS3NoOverwrite value, defaulting to true", "value", noOverwrite, "error", err)
+			cfg.CacheS3NoOverwrite = true
+		}
 	}
 
-	log.Printf("parsed configs: %#v", cfg)
+	l.Debug("parsed configs", "config", fmt.Sprintf("%#v", cfg))
 	return cfg
 }
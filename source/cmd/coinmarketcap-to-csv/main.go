@@ -2,101 +2,385 @@ package main
 
 import (
 	"context"
-	"log"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/benjohns1/invest-source/app"
 	"github.com/benjohns1/invest-source/cache/file"
+	"github.com/benjohns1/invest-source/cache/keyval"
+	keyvalProvider "github.com/benjohns1/invest-source/cache/keyval/provider"
+	"github.com/benjohns1/invest-source/cache/layered"
+	"github.com/benjohns1/invest-source/cache/timeseries"
+	cmdConfig "github.com/benjohns1/invest-source/cmd/internal/config"
+	"github.com/benjohns1/invest-source/convert/fx"
+	"github.com/benjohns1/invest-source/metrics"
 	"github.com/benjohns1/invest-source/output/csv"
+	"github.com/benjohns1/invest-source/output/destination"
+	"github.com/benjohns1/invest-source/output/gnucash"
+	"github.com/benjohns1/invest-source/output/report"
+	"github.com/benjohns1/invest-source/output/xlsx"
+	"github.com/benjohns1/invest-source/provider/coingecko"
 	"github.com/benjohns1/invest-source/provider/coinmarketcap"
+	"github.com/benjohns1/invest-source/provider/ecb"
+	"github.com/benjohns1/invest-source/provider/rest"
+	"github.com/benjohns1/invest-source/tracing"
+	"github.com/benjohns1/invest-source/utils/logging"
+	"github.com/benjohns1/invest-source/utils/secret"
 	"github.com/spf13/pflag"
 	"github.com/spf13/viper"
 )
 
 type config struct {
-	CoinMarketCapApiKey string
-	CacheDirectory      string
-	OutputDirectory     string
-	OutputSymbols       []string
-	Since               string
+	Provider                         string
+	CoinMarketCapApiKey              secret.String
+	CoinMarketCapMode                string
+	CoinMarketCapMaxPages            int
+	CoinMarketCapIDs                 map[string]string
+	CoinMarketCapMonthlyCreditBudget int
+	CoinGeckoIDs                     map[string]string
+	CoinGeckoPageDelay               time.Duration
+	ConsensusTolerance               string
+	ConsensusMedian                  bool
+	REST                             rest.Config
+	CacheDirectory                   string
+	CacheURL                         string
+	TimeSeriesDirectory              string
+	FXCurrency                       string
+	FXCacheDirectory                 string
+	OutputDirectory                  string
+	OutputURL                        string
+	OutputFormat                     string
+	GnuCash                          csv.GnuCashConfig
+	GnuCashBook                      string
+	Pivot                            csv.PivotConfig
+	OutputSymbols                    []string
+	Since                            string
+	LogLevel                         string
+	LogFormat                        string
+	MetricsAddress                   string
+	MetricsPushgatewayURL            string
+	MetricsTextfile                  string
+	TracingEndpoint                  string
 }
 
 func parseCfg() config {
 	pflag.String("since", "2021-01-01", "output quote data since this date")
 	pflag.Parse()
 	if err := viper.BindPFlags(pflag.CommandLine); err != nil {
-		log.Fatal(err)
+		logging.Fatal(slog.Default(), "error binding flags", "error", err)
 	}
 
+	viper.SetDefault("Provider", "coinmarketcap")
+	viper.SetDefault("CoinMarketCapMode", coinmarketcap.ModeListings)
+	viper.SetDefault("CoinMarketCapMaxPages", 1)
+	viper.SetDefault("CoinGeckoPageDelay", "5s")
 	viper.SetDefault("CacheDirectory", "./data/cache")
+	viper.SetDefault("TimeSeriesDirectory", "./data/timeseries")
+	viper.SetDefault("FXCacheDirectory", "./data/cache-fx")
 	viper.SetDefault("OutputDirectory", "./data/out")
+	viper.SetDefault("OutputFormat", "gnucash")
 	viper.SetDefault("Since", "2021-01-01")
+	viper.SetDefault("LogLevel", "info")
+	viper.SetDefault("LogFormat", logging.FormatText)
 
-	readCfgFile("ConfigFile", "config.yaml")
-	readCfgFile("SecretConfigFile", ".secrets.yaml")
+	cmdConfig.ReadFile("ConfigFile", "config.yaml")
+	cmdConfig.ReadFile("SecretConfigFile", ".secrets.yaml")
 
 	cfg := config{}
 	if err := viper.Unmarshal(&cfg); err != nil {
-		log.Fatal(err)
+		logging.Fatal(slog.Default(), "error parsing config", "error", err)
 	}
 	for i, symbol := range cfg.OutputSymbols {
 		cfg.OutputSymbols[i] = strings.TrimSpace(symbol)
 	}
+	var err error
+	if cfg.CoinMarketCapApiKey, err = secret.Resolve(context.Background(), cfg.CoinMarketCapApiKey.Reveal()); err != nil {
+		logging.Fatal(slog.Default(), "error resolving CoinMarketCapApiKey", "error", err)
+	}
 
-	log.Printf("parsed configs: %#v", cfg)
 	return cfg
 }
 
-func readCfgFile(key string, defaultFile string) {
-	viper.SetDefault(key, defaultFile)
-	cfgFile := viper.GetString(key)
-	log.Printf("reading %s from '%s'\n", key, cfgFile)
-	viper.SetConfigFile(cfgFile)
-	if err := viper.ReadInConfig(); err != nil {
-		log.Printf("unable to read %s file '%s', continuing with defaults: %v", key, cfgFile, err)
+// creditsLedger is the name of the ledger of CoinMarketCap credits used, shared with the cache lambda.
+const creditsLedger = "coinmarketcap-credits"
+
+// createCache creates a local file cache, layered in front of the remote cache at CacheURL if set, and the ledger of credits used kept with the remote cache, or else in the CacheDirectory.
+func createCache(ctx context.Context, cfg config, l app.Log, in cmdConfig.Instruments) (app.Cache, keyval.Ledger, error) {
+	local, err := file.NewDailyCache(cfg.CacheDirectory)
+	if err != nil {
+		return nil, keyval.Ledger{}, err
+	}
+	if cfg.CacheURL == "" {
+		dir, err := keyvalProvider.NewDir(cfg.CacheDirectory)
+		if err != nil {
+			return nil, keyval.Ledger{}, err
+		}
+		ledger, err := keyval.NewLedger(dir, "ledger", creditsLedger)
+		if err != nil {
+			return nil, keyval.Ledger{}, err
+		}
+		return in.Cache("file", local), ledger, nil
+	}
+
+	p, loc, err := keyvalProvider.Open(ctx, cfg.CacheURL)
+	if err != nil {
+		return nil, keyval.Ledger{}, err
+	}
+	remote, err := keyval.NewDailyCache(p, loc.Bucket, loc.Prefix)
+	if err != nil {
+		return nil, keyval.Ledger{}, err
+	}
+	remote.Log = l
+	ledger, err := remote.Ledger(creditsLedger)
+	if err != nil {
+		return nil, keyval.Ledger{}, err
+	}
+	l.Info("reading through local cache", "cacheURL", cfg.CacheURL)
+	c, err := layered.NewCache(in.Cache("file", local), in.Cache("keyval", remote))
+	return c, ledger, err
+}
+
+// createProvider creates a failover chain of the quote providers listed in the Provider config, in priority order, or a consensus of them if ConsensusTolerance is set.
+func createProvider(cfg config, ledger coinmarketcap.Ledger, l app.Log, in cmdConfig.Instruments) (app.Provider, error) {
+	return cmdConfig.CreateProvider(l, in, cfg.Provider, cfg.ConsensusTolerance, cfg.ConsensusMedian, func(name string) (app.Provider, error) {
+		return newProvider(cfg, name, ledger)
+	})
+}
+
+func newProvider(cfg config, name string, ledger coinmarketcap.Ledger) (app.Provider, error) {
+	switch name {
+	case "coinmarketcap":
+		p, err := coinmarketcap.NewCoinMarketCapProvider(cfg.CoinMarketCapApiKey.Reveal())
+		if err != nil {
+			return nil, err
+		}
+		p.Mode, p.MaxPages, p.IDs = cfg.CoinMarketCapMode, cfg.CoinMarketCapMaxPages, make(map[string]string, len(cfg.CoinMarketCapIDs))
+		p.MonthlyCreditBudget, p.Ledger = cfg.CoinMarketCapMonthlyCreditBudget, ledger
+		for symbol, id := range cfg.CoinMarketCapIDs {
+			p.IDs[strings.ToUpper(symbol)] = id
+		}
+		if err := p.Validate(); err != nil {
+			return nil, err
+		}
+		return p, nil
+	case "coingecko":
+		p, err := coingecko.NewCoinGeckoProvider(cfg.CoinGeckoIDs)
+		if err != nil {
+			return nil, err
+		}
+		p.PageDelay = cfg.CoinGeckoPageDelay
+		if err := p.Validate(); err != nil {
+			return nil, err
+		}
+		return p, nil
+	case "rest":
+		var s secret.String
+		if cfg.REST.SecretKey != "" {
+			var err error
+			if s, err = secret.Resolve(context.Background(), viper.GetString(cfg.REST.SecretKey)); err != nil {
+				return nil, fmt.Errorf("error resolving REST secret '%s': %v", cfg.REST.SecretKey, err)
+			}
+		}
+		return rest.NewProvider(cfg.REST, s.Reveal())
+	default:
+		return nil, fmt.Errorf("unknown Provider '%s', must be one of coinmarketcap, coingecko or rest", name)
+	}
+}
+
+// createOutput writes prices into the GnuCashBook if set, otherwise exports files in the OutputFormat to the OutputURL, or the OutputDirectory.
+func createOutput(ctx context.Context, cfg config) (app.Output, error) {
+	if cfg.GnuCashBook != "" {
+		return gnucash.OpenBook(cfg.GnuCashBook, cfg.GnuCash.Commodities)
+	}
+	var d destination.Destination
+	var err error
+	if cfg.OutputURL == "" {
+		d, err = destination.NewDir(cfg.OutputDirectory)
+	} else {
+		d, err = destination.Open(ctx, cfg.OutputURL)
+	}
+	if err != nil {
+		return nil, err
+	}
+	switch format := strings.ToLower(strings.TrimSpace(cfg.OutputFormat)); format {
+	case "gnucash":
+		return csv.NewGnuCashCSVWithDestination(d, cfg.GnuCash)
+	case "pivot":
+		return csv.NewPivotCSV(d, cfg.Pivot)
+	case "xlsx":
+		return xlsx.NewOutput(d)
+	case "html":
+		return report.NewOutput(d, "")
+	default:
+		return nil, fmt.Errorf("unknown OutputFormat '%s', must be one of gnucash, pivot, xlsx or html", format)
+	}
+}
+
+// createConverter caches today's ECB exchange rates and returns a converter into FXCurrency, or nil if no conversion is configured.
+func createConverter(ctx context.Context, cfg config, l app.Log, in cmdConfig.Instruments) (app.QuoteConverter, error) {
+	if cfg.FXCurrency == "" {
+		return nil, nil
+	}
+	fc, err := file.NewDailyCache(cfg.FXCacheDirectory)
+	if err != nil {
+		return nil, err
+	}
+	ep, err := ecb.NewECBProvider()
+	if err != nil {
+		return nil, err
+	}
+	c, p := in.Cache("fx", fc), in.Provider("ecb", ep)
+
+	l.Info("caching daily exchange rates")
+	if err := in.Run(ctx, "cache-daily-exchange-rates", func(ctx context.Context) error {
+		return app.CacheDailySourceData(ctx, app.App{Config: app.Config{Provider: p, Cache: c, Log: l}})
+	}); err != nil {
+		return nil, err
+	}
+	conv, err := fx.NewConverter(strings.ToUpper(strings.TrimSpace(cfg.FXCurrency)), c, p)
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.Since == "" {
+		return conv, nil
+	}
+	since, err := time.Parse(app.DateFormat, cfg.Since)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing 'since' date, should be of the form '%s', got '%s': %v", app.DateFormat, cfg.Since, err)
+	}
+	l.Info("backfilling exchange rates", "since", cfg.Since)
+	if err := in.Run(ctx, "backfill-exchange-rates", func(ctx context.Context) error {
+		days, err := conv.Backfill(ctx, since, ep)
+		if days > 0 {
+			l.Info("backfilled exchange rates", "days", days)
+		}
+		return err
+	}); err != nil {
+		return nil, err
+	}
+	return conv, nil
+}
+
+// serveMetrics serves the metrics on the MetricsAddress while the CLI runs, which is only long enough to be scraped for large runs, export covers the rest.
+func serveMetrics(cfg config, m *metrics.Metrics, l app.Log) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", m.Handler())
+	l.Info("serving metrics", "address", cfg.MetricsAddress)
+	go func() {
+		if err := http.ListenAndServe(cfg.MetricsAddress, mux); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			l.Error("error serving metrics", "error", err)
+		}
+	}()
+}
+
+// export pushes the metrics to the MetricsPushgatewayURL and writes them to the MetricsTextfile if set, since the CLI exits before it could be scraped, and exports the remaining spans.
+func export(ctx context.Context, cfg config, in cmdConfig.Instruments, l app.Log) {
+	if err := in.Tracing.Shutdown(ctx); err != nil {
+		l.Error("error exporting spans", "tracingEndpoint", cfg.TracingEndpoint, "error", err)
+	}
+	m := in.Metrics
+	if cfg.MetricsPushgatewayURL != "" {
+		if err := m.Push(cfg.MetricsPushgatewayURL, "invest-source", ""); err != nil {
+			l.Error("error pushing metrics", "pushgatewayURL", cfg.MetricsPushgatewayURL, "error", err)
+		}
+	}
+	if cfg.MetricsTextfile != "" {
+		if err := m.WriteTextfile(cfg.MetricsTextfile); err != nil {
+			l.Error("error writing metrics", "textfile", cfg.MetricsTextfile, "error", err)
+		}
 	}
 }
 
 func main() {
-	log.Println("parsing config")
+	slog.Info("parsing config")
 	cfg := parseCfg()
 
+	// keep standard output for the CSV when it's the output destination
+	logOut := os.Stdout
+	if cfg.OutputURL == destination.Stdout {
+		logOut = os.Stderr
+	}
+	l, err := logging.New(logOut, cfg.LogFormat, cfg.LogLevel)
+	if err != nil {
+		logging.Fatal(slog.Default(), "error creating logger", "error", err)
+	}
+	slog.SetDefault(l)
+	l.Debug("parsed configs", "config", fmt.Sprintf("%#v", cfg))
+
 	ctx := context.Background()
 
-	log.Println("injecting dependencies")
-	c, err := file.NewDailyCache(cfg.CacheDirectory)
+	t, err := tracing.New(ctx, cfg.TracingEndpoint, "invest-source")
 	if err != nil {
-		log.Fatal(err)
+		logging.Fatal(l, "error creating tracing", "error", err)
 	}
-	p, err := coinmarketcap.NewCoinMarketCapProvider(cfg.CoinMarketCapApiKey)
+	in := cmdConfig.Instruments{Metrics: metrics.New(), Tracing: t}
+	if cfg.MetricsAddress != "" {
+		serveMetrics(cfg, in.Metrics, l)
+	}
+	// fail exports the metrics and spans recorded so far, so failed runs are visible too, before exiting
+	fail := func(msg string, err error) {
+		export(ctx, cfg, in, l)
+		logging.Fatal(l, msg, "error", err)
+	}
+
+	l.Info("injecting dependencies")
+	c, ledger, err := createCache(ctx, cfg, l, in)
 	if err != nil {
-		log.Fatal(err)
+		logging.Fatal(l, "error creating cache", "error", err)
 	}
-	log.Printf("symbols to output: %v\n", cfg.OutputSymbols)
-	o, err := csv.NewGnuCashCSV(cfg.OutputDirectory)
+	p, err := createProvider(cfg, ledger, l, in)
 	if err != nil {
-		log.Fatal(err)
+		logging.Fatal(l, "error creating provider", "error", err)
+	}
+	l.Info("symbols to output", "symbols", cfg.OutputSymbols)
+	o, err := createOutput(ctx, cfg)
+	if err != nil {
+		logging.Fatal(l, "error creating output", "error", err)
+	}
+	s, err := timeseries.NewStore(cfg.TimeSeriesDirectory)
+	if err != nil {
+		logging.Fatal(l, "error creating time-series store", "error", err)
+	}
+	fxc, err := createConverter(ctx, cfg, l, in)
+	if err != nil {
+		fail("error creating currency converter", err)
 	}
-	l := log.New(os.Stdout, "app: ", log.LstdFlags)
 	a := app.App{
 		Config: app.Config{
-			Provider: p,
-			Cache:    c,
-			Output:   o,
-			Log:      l,
+			Provider:  p,
+			Cache:     c,
+			Output:    in.Metrics.Output(o),
+			Store:     s,
+			Converter: fxc,
+			Log:       l,
 		},
 	}
 
-	log.Println("caching daily source data")
-	if err := app.CacheDailySourceData(ctx, a); err != nil {
-		log.Fatal(err)
+	l.Info("caching daily source data")
+	if err := in.Run(ctx, "cache-daily-source-data", func(ctx context.Context) error { return app.CacheDailySourceData(ctx, a) }); err != nil {
+		fail("error caching daily source data", err)
+	}
+
+	if len(cfg.OutputSymbols) > 0 {
+		l.Info("compacting daily quotes")
+		if err := in.Run(ctx, "compact-daily-quotes", func(ctx context.Context) error { return app.CompactDailyQuotes(ctx, a, cfg.OutputSymbols) }); err != nil {
+			fail("error compacting daily quotes", err)
+		}
 	}
 
-	log.Println("outputting daily quotes")
-	if err := app.OutputDailyQuotes(ctx, a, cfg.Since, cfg.OutputSymbols); err != nil {
-		log.Fatal(err)
+	l.Info("outputting daily quotes")
+	if err := in.Run(ctx, "output-daily-quotes", func(ctx context.Context) error {
+		return app.OutputDailyQuotes(ctx, a, cfg.Since, cfg.OutputSymbols)
+	}); err != nil {
+		fail("error outputting daily quotes", err)
 	}
 
-	log.Println("complete")
+	export(ctx, cfg, in, l)
+	l.Info("complete")
 }
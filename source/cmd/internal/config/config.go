@@ -0,0 +1,70 @@
+// Package config wires up the dependencies shared by the commands.
+package config
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/shopspring/decimal"
+	"github.com/spf13/viper"
+
+	"github.com/benjohns1/invest-source/app"
+	"github.com/benjohns1/invest-source/metrics"
+	"github.com/benjohns1/invest-source/provider/composite"
+	"github.com/benjohns1/invest-source/tracing"
+)
+
+// ReadFile merges the config file named by the key, or the default file, into viper's config, continuing with defaults if it can't be read.
+func ReadFile(key string, defaultFile string) {
+	viper.SetDefault(key, defaultFile)
+	cfgFile := viper.GetString(key)
+	slog.Info("reading config file", "key", key, "file", cfgFile)
+	viper.SetConfigFile(cfgFile)
+	if err := viper.MergeInConfig(); err != nil {
+		slog.Warn("unable to read config file, continuing with defaults", "key", key, "file", cfgFile, "error", err)
+	}
+}
+
+// Instruments records metrics and traces of the use-case runs, and the providers and caches they use.
+type Instruments struct {
+	Metrics *metrics.Metrics
+	Tracing *tracing.Tracing
+}
+
+// Run runs a use-case, counted and traced under its name.
+func (i Instruments) Run(ctx context.Context, usecase string, run func(ctx context.Context) error) error {
+	return i.Metrics.Run(usecase, func() error { return i.Tracing.Run(ctx, usecase, run) })
+}
+
+// Provider instruments a named provider.
+func (i Instruments) Provider(name string, p app.Provider) app.Provider {
+	return i.Metrics.Provider(name, i.Tracing.Provider(name, p))
+}
+
+// Cache instruments a named cache.
+func (i Instruments) Cache(name string, c app.DatedCache) app.DatedCache {
+	return i.Metrics.Cache(name, i.Tracing.Cache(name, c))
+}
+
+// CreateProvider creates a failover chain of the comma-separated provider names, in priority order, or a consensus of them if the tolerance is set. Each provider is created by newProvider, and instrumented.
+func CreateProvider(l app.Log, in Instruments, names string, tolerance string, median bool, newProvider func(name string) (app.Provider, error)) (app.Provider, error) {
+	var providers []composite.Named
+	for _, name := range strings.Split(names, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		p, err := newProvider(name)
+		if err != nil {
+			return nil, err
+		}
+		providers = append(providers, composite.Named{Name: name, Provider: in.Provider(name, p)})
+	}
+	if tolerance == "" {
+		return composite.NewChain(l, providers...)
+	}
+	t, err := decimal.NewFromString(tolerance)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ConsensusTolerance '%s': %v", tolerance, err)
+	}
+	return composite.NewConsensus(l, t, median, providers...)
+}
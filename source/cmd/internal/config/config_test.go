@@ -0,0 +1,66 @@
+package config_test
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/benjohns1/invest-source/app"
+	"github.com/benjohns1/invest-source/cmd/internal/config"
+	"github.com/benjohns1/invest-source/metrics"
+	"github.com/benjohns1/invest-source/provider/composite"
+	"github.com/benjohns1/invest-source/tracing"
+)
+
+type stubProvider struct{}
+
+func (stubProvider) QueryLatest(context.Context) ([]byte, error) { return []byte("{}"), nil }
+
+func (stubProvider) ParseQuotes(context.Context, []byte, ...string) ([]app.Quote, error) {
+	return nil, nil
+}
+
+func TestCreateProvider(t *testing.T) {
+	tr, err := tracing.New(context.Background(), "", "invest-source")
+	if err != nil {
+		t.Fatal(err)
+	}
+	in := config.Instruments{Metrics: metrics.New(), Tracing: tr}
+	l := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	tests := []struct {
+		name      string
+		names     string
+		tolerance string
+		wantNames []string
+		wantType  app.Provider
+		wantErr   bool
+	}{
+		{name: "should create a chain of the providers in order", names: " CoinGecko ,coinmarketcap", wantNames: []string{"coingecko", "coinmarketcap"}, wantType: composite.Chain{}},
+		{name: "should create a consensus with a tolerance", names: "coingecko,coinmarketcap", tolerance: "0.02", wantNames: []string{"coingecko", "coinmarketcap"}, wantType: composite.Consensus{}},
+		{name: "should fail with an invalid tolerance", names: "coingecko", tolerance: "2%", wantErr: true},
+		{name: "should fail with an unknown provider", names: "coingecko,unknown", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var created []string
+			p, err := config.CreateProvider(l, in, tt.names, tt.tolerance, false, func(name string) (app.Provider, error) {
+				if name == "unknown" {
+					return nil, fmt.Errorf("unknown provider '%s'", name)
+				}
+				created = append(created, name)
+				return stubProvider{}, nil
+			})
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.IsType(t, tt.wantType, p)
+			assert.Equal(t, tt.wantNames, created)
+		})
+	}
+}
@@ -0,0 +1,10 @@
+package fx
+
+import (
+	"time"
+)
+
+var (
+	// Now function for retrieving the current timestamp. Override this for unit tests.
+	Now = time.Now
+)
@@ -0,0 +1,182 @@
+package fx
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/shopspring/decimal"
+
+	"github.com/benjohns1/invest-source/app"
+)
+
+// Converter re-denominates USD quotes into another currency, using the day's exchange rate from a cache of FX provider data.
+type Converter struct {
+	Currency string
+	Rates    app.DatedCache
+	Provider app.Provider
+
+	mu    sync.Mutex
+	byDay map[string]dayRate
+}
+
+const dateFormat = "2006-01-02"
+
+// NewConverter instantiates a converter into the given currency.
+func NewConverter(currency string, rates app.DatedCache, provider app.Provider) (*Converter, error) {
+	c := &Converter{
+		Currency: currency,
+		Rates:    rates,
+		Provider: provider,
+	}
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Validate returns an error if the converter was not correctly instantiated.
+func (c *Converter) Validate() error {
+	if c.Currency == "" {
+		return fmt.Errorf("converter Currency must be set")
+	}
+	if c.Rates == nil {
+		return fmt.Errorf("converter Rates cache must be set")
+	}
+	if c.Provider == nil {
+		return fmt.Errorf("converter Provider must be set")
+	}
+
+	return nil
+}
+
+// Convert re-denominates the quote's USD price into the converter's currency, with the latest rate published on or before the quote's day, returning an error if no rate was cached for the quote's day.
+// The ECB only publishes rates on business days, so a day without its own rate, e.g. a weekend, uses the previous business day's rate, recorded in the quote's RateDate.
+func (c *Converter) Convert(ctx context.Context, q app.Quote) (app.Quote, error) {
+	if c.Currency == "USD" {
+		q.Currency, q.Price = "USD", q.USD
+		return q, nil
+	}
+
+	r, err := c.rate(ctx, q)
+	if err != nil {
+		return app.Quote{}, err
+	}
+	q.Currency, q.Price = c.Currency, q.USD.Div(r.usdPerUnit)
+	if r.date.Format(dateFormat) != q.Time.UTC().Format(dateFormat) {
+		q.RateDate = r.date
+	}
+	return q, nil
+}
+
+type dayRate struct {
+	usdPerUnit decimal.Decimal
+	date       time.Time
+}
+
+func (c *Converter) rate(ctx context.Context, q app.Quote) (dayRate, error) {
+	day := q.Time.UTC().Format(dateFormat)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if r, ok := c.byDay[day]; ok {
+		return r, nil
+	}
+
+	data, err := c.Rates.ReadDate(ctx, q.Time)
+	if err != nil {
+		return dayRate{}, fmt.Errorf("error reading %s exchange rates for %s: %v", c.Currency, day, err)
+	}
+	if data == nil {
+		return dayRate{}, fmt.Errorf("no %s exchange rate cached for %s, needed to convert %s", c.Currency, day, q.Symbol)
+	}
+	rates, err := c.Provider.ParseQuotes(ctx, data, c.Currency)
+	if err != nil {
+		return dayRate{}, fmt.Errorf("error parsing %s exchange rates for %s: %v", c.Currency, day, err)
+	}
+	if len(rates) == 0 {
+		return dayRate{}, fmt.Errorf("no %s exchange rate found in cached rates for %s, needed to convert %s", c.Currency, day, q.Symbol)
+	}
+
+	var latest *app.Quote
+	for i, r := range rates {
+		if r.Time.UTC().Format(dateFormat) > day {
+			continue
+		}
+		if latest == nil || r.Time.After(latest.Time) {
+			latest = &rates[i]
+		}
+	}
+	if latest == nil {
+		return dayRate{}, fmt.Errorf("cached %s exchange rates for %s were all published after that day, needed to convert %s", c.Currency, day, q.Symbol)
+	}
+	if !latest.USD.IsPositive() {
+		return dayRate{}, fmt.Errorf("invalid %s exchange rate %s for %s", c.Currency, latest.USD, day)
+	}
+
+	if c.byDay == nil {
+		c.byDay = make(map[string]dayRate)
+	}
+	r := dayRate{usdPerUnit: latest.USD, date: latest.Time.UTC()}
+	c.byDay[day] = r
+	return r, nil
+}
+
+// HistoryProvider is an FX provider that can also retrieve its data for past days.
+type HistoryProvider interface {
+	// QueryHistory retrieves the data of each day with published rates, keyed by day in the app.DateFormat.
+	QueryHistory(ctx context.Context) (map[string][]byte, error)
+}
+
+// Backfill caches rates from the provider's history for each day since the given date, up to today, that has no cached rates, returning the number of days cached.
+// A day without published rates, e.g. a weekend, is cached with the previous business day's rates, which Convert records as carried forward. History is only queried if a day is missing.
+func (c *Converter) Backfill(ctx context.Context, since time.Time, history HistoryProvider) (int, error) {
+	if c.Currency == "USD" || since.IsZero() {
+		return 0, nil
+	}
+
+	var missing []time.Time
+	today := Now().UTC()
+	for date := since.UTC().Truncate(24 * time.Hour); !date.After(today); date = date.AddDate(0, 0, 1) {
+		data, err := c.Rates.ReadDate(ctx, date)
+		if err != nil {
+			return 0, fmt.Errorf("error reading exchange rates for %s: %v", date.Format(dateFormat), err)
+		}
+		if data == nil {
+			missing = append(missing, date)
+		}
+	}
+	if len(missing) == 0 {
+		return 0, nil
+	}
+
+	days, err := history.QueryHistory(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("error querying exchange rate history: %v", err)
+	}
+	published := make([]string, 0, len(days))
+	for day := range days {
+		published = append(published, day)
+	}
+	sort.Strings(published)
+
+	cached := 0
+	for _, date := range missing {
+		day := date.Format(dateFormat)
+		i := sort.SearchStrings(published, day)
+		if i == len(published) || published[i] != day {
+			// carry forward the previous business day's rates, unless the day is before the history starts
+			if i == 0 {
+				continue
+			}
+			i--
+		}
+		if err := c.Rates.WriteDate(ctx, date, days[published[i]]); err != nil {
+			return cached, fmt.Errorf("error caching exchange rates for %s: %v", day, err)
+		}
+		cached++
+	}
+	return cached, nil
+}
@@ -0,0 +1,187 @@
+package fx_test
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/benjohns1/invest-source/app"
+	"github.com/benjohns1/invest-source/cache/keyval"
+	"github.com/benjohns1/invest-source/cache/keyval/provider"
+	"github.com/benjohns1/invest-source/convert/fx"
+	"github.com/benjohns1/invest-source/provider/ecb"
+)
+
+func TestConverter_Convert(t *testing.T) {
+	keyval.Now = func() time.Time {
+		return time.Date(2021, time.June, 22, 12, 0, 0, 0, time.UTC)
+	}
+	fixture, err := ioutil.ReadFile("../../provider/ecb/testdata/eurofxref-daily.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := provider.NewMemory()
+	// rates fetched before the ECB published on the 22nd hold the 21st's rates, and the 20th has impossible rates from the future
+	for _, key := range []string{"2021-06-20.json", "2021-06-21.json", "2021-06-22.json"} {
+		if err := p.Upload(context.Background(), "rates", key, bytes.NewReader(fixture)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rates, err := keyval.NewDailyCache(p, "rates", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecbProvider, err := ecb.NewECBProvider()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	quote := func(date time.Time) app.Quote {
+		return app.Quote{Time: date, Symbol: "BTC", USD: decimal.NewFromInt(35000)}
+	}
+	day := time.Date(2021, time.June, 21, 23, 59, 0, 0, time.UTC)
+
+	tests := []struct {
+		name         string
+		currency     string
+		quote        app.Quote
+		wantPrice    decimal.Decimal
+		wantCurrency string
+		wantRateDate time.Time
+		wantErr      bool
+	}{
+		{
+			name:         "should convert to EUR with the same day's rate",
+			currency:     "EUR",
+			quote:        quote(day),
+			wantPrice:    decimal.NewFromInt(35000).Div(decimal.RequireFromString("1.1898")),
+			wantCurrency: "EUR",
+		},
+		{
+			name:         "should convert to GBP with the same day's rate",
+			currency:     "GBP",
+			quote:        quote(day),
+			wantPrice:    decimal.NewFromInt(35000).Div(decimal.RequireFromString("1.1898").Div(decimal.RequireFromString("0.85830"))),
+			wantCurrency: "GBP",
+		},
+		{
+			name:         "should carry forward the previous business day's rate and record its date",
+			currency:     "EUR",
+			quote:        quote(day.AddDate(0, 0, 1)),
+			wantPrice:    decimal.NewFromInt(35000).Div(decimal.RequireFromString("1.1898")),
+			wantCurrency: "EUR",
+			wantRateDate: time.Date(2021, time.June, 21, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "should fail if the cached rates were published after the quote's day",
+			currency: "EUR",
+			quote:    quote(day.AddDate(0, 0, -1)),
+			wantErr:  true,
+		},
+		{
+			name:         "should keep USD prices without reading rates",
+			currency:     "USD",
+			quote:        quote(day.AddDate(0, 0, -100)),
+			wantPrice:    decimal.NewFromInt(35000),
+			wantCurrency: "USD",
+		},
+		{
+			name:     "should fail if no rates were cached for the quote's day",
+			currency: "EUR",
+			quote:    quote(day.AddDate(0, 0, 2)),
+			wantErr:  true,
+		},
+		{
+			name:     "should fail if the currency is missing from the cached rates",
+			currency: "XYZ",
+			quote:    quote(day),
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := fx.NewConverter(tt.currency, rates, ecbProvider)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := c.Convert(context.Background(), tt.quote)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.quote.USD, got.USD)
+			price, currency := got.Denominated()
+			assert.Equal(t, tt.wantCurrency, currency)
+			assert.Equal(t, tt.wantRateDate, got.RateDate)
+			assert.True(t, tt.wantPrice.Sub(price).Abs().LessThan(decimal.New(1, -8)), "want %s, got %s", tt.wantPrice, price)
+		})
+	}
+}
+
+type stubHistory struct {
+	days    map[string][]byte
+	queries int
+}
+
+func (h *stubHistory) QueryHistory(context.Context) (map[string][]byte, error) {
+	h.queries++
+	return h.days, nil
+}
+
+func TestConverter_Backfill(t *testing.T) {
+	now := func() time.Time {
+		return time.Date(2021, time.June, 22, 12, 0, 0, 0, time.UTC)
+	}
+	keyval.Now, fx.Now = now, now
+	p := provider.NewMemory()
+	if err := p.Upload(context.Background(), "rates", "2021-06-22.json", bytes.NewReader([]byte("today"))); err != nil {
+		t.Fatal(err)
+	}
+	rates, err := keyval.NewDailyCache(p, "rates", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecbProvider, err := ecb.NewECBProvider()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := fx.NewConverter("EUR", rates, ecbProvider)
+	if err != nil {
+		t.Fatal(err)
+	}
+	history := &stubHistory{days: map[string][]byte{
+		"2021-06-17": []byte("thursday"),
+		"2021-06-18": []byte("friday"),
+		"2021-06-21": []byte("monday"),
+		"2021-06-22": []byte("tuesday"),
+	}}
+
+	got, err := c.Backfill(context.Background(), time.Date(2021, time.June, 16, 0, 0, 0, 0, time.UTC), history)
+	assert.NoError(t, err)
+	assert.Equal(t, 5, got, "should cache every missing day since the first published day")
+	want := map[string]string{
+		"2021-06-17": "thursday",
+		"2021-06-18": "friday",
+		"2021-06-19": "friday",
+		"2021-06-20": "friday",
+		"2021-06-21": "monday",
+		"2021-06-22": "today",
+	}
+	for day, data := range want {
+		date, _ := time.Parse("2006-01-02", day)
+		cached, err := rates.ReadDate(context.Background(), date)
+		assert.NoError(t, err)
+		assert.Equal(t, data, string(cached), day)
+	}
+
+	got, err = c.Backfill(context.Background(), time.Date(2021, time.June, 17, 0, 0, 0, 0, time.UTC), history)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, got)
+	assert.Equal(t, 1, history.queries, "should not query the history once every day is cached")
+}
@@ -44,6 +44,8 @@ provider "aws" {
     iam              = var.localstack_endpoint
     cloudwatch       = var.localstack_endpoint
     cloudwatchevents = var.localstack_endpoint
+    ssm              = var.localstack_endpoint
+    secretsmanager   = var.localstack_endpoint
   }
 }
 
@@ -55,6 +57,19 @@ locals {
     "Service" = "coinmarketcap-pull-aws-lambda"
   }
   cfg = merge(try(yamldecode(file("${path.root}/../../../config.yaml")), {}), try(yamldecode(file("${path.root}/../../../.secrets.yaml")), {}))
+  # pass the lambda a reference to the API key stored in SSM, rather than the key itself
+  api_key_parameter = "/invest-source/coinmarketcap-api-key"
+  api_key_env = contains(keys(local.cfg), "CoinMarketCapApiKey") ? {
+    CoinMarketCapApiKey = "ssm://${local.api_key_parameter}?endpoint=${var.localstack_endpoint_internal}&region=${var.region}"
+  } : {}
+}
+
+resource "aws_ssm_parameter" "coinmarketcap_api_key" {
+  count = contains(keys(local.cfg), "CoinMarketCapApiKey") ? 1 : 0
+  name  = local.api_key_parameter
+  type  = "SecureString"
+  value = local.cfg["CoinMarketCapApiKey"]
+  tags  = local.tags
 }
 
 resource "aws_s3_bucket" "coinmarketcap_cache" {
@@ -77,7 +92,7 @@ resource "aws_lambda_function" "pull_lambda" {
       CacheS3Bucket  = var.pull_cache_s3_bucket
       AWS_ACCESS_KEY = "omit"
       AWS_SECRET_KEY = "omit"
-    })
+    }, local.api_key_env)
   }
 
   memory_size                    = 128
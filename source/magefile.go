@@ -24,6 +24,9 @@ const (
 	coverDir         = "coverage"
 	packagePrefixLen = len("github.com/benjohns1/invest-source/")
 
+	syncBinary = "bin/cache-sync"
+	syncSrc    = "cmd/cache-sync/main.go"
+
 	pullLambdaSrc    = "cmd/coinmarketcap-pull-aws-lambda/main.go"
 	pullLambdaBinary = "build/artifacts/coinmarketcap-pull-aws-lambda"
 	pullLambdaZip    = "build/artifacts/coinmarketcap-pull-aws-lambda.zip"
@@ -48,6 +51,9 @@ func Build() error {
 	if err := cmd("go", "build", "-o", binary, src); err != nil {
 		return err
 	}
+	if err := cmd("go", "build", "-o", binaryForOS(syncBinary), syncSrc); err != nil {
+		return err
+	}
 	if err := envVars(map[string]string{"GOOS": "linux", "GOARCH": "amd64"}).cmd("go", "build", "-o", pullLambdaBinary, pullLambdaSrc); err != nil {
 		return err
 	}
@@ -182,6 +188,18 @@ func AWSLocal() error {
 	return nil
 }
 
+// Emulators spins up local MinIO, GCS and Azure Blob Storage emulators for testing key-value cache providers.
+//goland:noinspection GoUnusedExportedFunction
+func Emulators() error {
+	return cmd("docker-compose", "-f", "deploy/emulators/docker-compose.yml", "up", "-d")
+}
+
+// EmulatorsClean spins down the key-value cache provider emulators.
+//goland:noinspection GoUnusedExportedFunction
+func EmulatorsClean() error {
+	return cmd("docker-compose", "-f", "deploy/emulators/docker-compose.yml", "down")
+}
+
 func zip(zip, src string) error {
 	if runtime.GOOS == "windows" {
 		return cmd("build-lambda-zip.exe", "-o", zip, src)
@@ -238,6 +256,10 @@ func List() error {
 }
 
 func getBinaryForOS() string {
+	return binaryForOS(binary)
+}
+
+func binaryForOS(binary string) string {
 	if runtime.GOOS != "windows" {
 		return binary
 	}
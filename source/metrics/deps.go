@@ -0,0 +1,10 @@
+package metrics
+
+import (
+	"time"
+)
+
+var (
+	// Now function for timing runs and queries. Override this for unit tests.
+	Now = time.Now
+)
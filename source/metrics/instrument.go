@@ -0,0 +1,125 @@
+package metrics
+
+import (
+	"context"
+	"time"
+
+	"github.com/benjohns1/invest-source/app"
+)
+
+// Provider instruments the provider's queries and parsed quotes, labelled with its name.
+func (m *Metrics) Provider(name string, p app.Provider) app.Provider {
+	return provider{Provider: p, name: name, m: m}
+}
+
+type provider struct {
+	app.Provider
+	name string
+	m    *Metrics
+}
+
+func (p provider) QueryLatest(ctx context.Context) ([]byte, error) {
+	start := Now()
+	data, err := p.Provider.QueryLatest(ctx)
+	p.m.providerDuration.WithLabelValues(p.name, result(err)).Observe(Now().Sub(start).Seconds())
+	p.m.providerBytes.WithLabelValues(p.name).Add(float64(len(data)))
+	return data, err
+}
+
+func (p provider) ParseQuotes(ctx context.Context, data []byte, symbols ...string) ([]app.Quote, error) {
+	quotes, err := p.Provider.ParseQuotes(ctx, data, symbols...)
+	p.m.providerQuotes.WithLabelValues(p.name).Add(float64(len(quotes)))
+	return quotes, err
+}
+
+// Cache instruments the cache's lookups and bytes read and written, labelled with its name.
+func (m *Metrics) Cache(name string, c app.DatedCache) app.DatedCache {
+	return cache{DatedCache: c, name: name, m: m}
+}
+
+type cache struct {
+	app.DatedCache
+	name string
+	m    *Metrics
+}
+
+func (c cache) ReadCurrent(ctx context.Context) ([]byte, error) {
+	return c.lookup(c.DatedCache.ReadCurrent(ctx))
+}
+
+func (c cache) ReadDate(ctx context.Context, date time.Time) ([]byte, error) {
+	return c.lookup(c.DatedCache.ReadDate(ctx, date))
+}
+
+func (c cache) lookup(data []byte, err error) ([]byte, error) {
+	switch {
+	case err != nil:
+		c.m.cacheLookups.WithLabelValues(c.name, ResultError).Inc()
+	case data == nil:
+		c.m.cacheLookups.WithLabelValues(c.name, ResultMiss).Inc()
+	default:
+		c.m.cacheLookups.WithLabelValues(c.name, ResultHit).Inc()
+	}
+	c.m.cacheReadBytes.WithLabelValues(c.name).Add(float64(len(data)))
+	return data, err
+}
+
+// ReadSince only counts the bytes read, since days missing from the range can't be told apart.
+func (c cache) ReadSince(ctx context.Context, since time.Time) ([][]byte, error) {
+	set, err := c.DatedCache.ReadSince(ctx, since)
+	n := 0
+	for _, data := range set {
+		n += len(data)
+	}
+	c.m.cacheReadBytes.WithLabelValues(c.name).Add(float64(n))
+	return set, err
+}
+
+func (c cache) WriteCurrent(ctx context.Context, data []byte) error {
+	return c.write(data, c.DatedCache.WriteCurrent(ctx, data))
+}
+
+func (c cache) WriteDate(ctx context.Context, date time.Time, data []byte) error {
+	return c.write(data, c.DatedCache.WriteDate(ctx, date, data))
+}
+
+func (c cache) write(data []byte, err error) error {
+	if err == nil {
+		c.m.cacheWriteBytes.WithLabelValues(c.name).Add(float64(len(data)))
+	}
+	return err
+}
+
+// Output instruments the days written to the output, and the expected symbols missing from them.
+func (m *Metrics) Output(o app.Output) app.Output {
+	return output{Output: o, m: m}
+}
+
+type output struct {
+	app.Output
+	m *Metrics
+}
+
+func (o output) Begin(filename string, symbols ...string) (app.DayWriter, error) {
+	w, err := o.Output.Begin(filename, symbols...)
+	if err != nil {
+		return nil, err
+	}
+	return dayWriter{DayWriter: w, m: o.m}, nil
+}
+
+type dayWriter struct {
+	app.DayWriter
+	m *Metrics
+}
+
+func (w dayWriter) WriteDay(quotes []app.Quote) ([]string, error) {
+	missing, err := w.DayWriter.WriteDay(quotes)
+	if err == nil {
+		w.m.outputDays.Inc()
+	}
+	for _, symbol := range missing {
+		w.m.outputMissing.WithLabelValues(symbol).Inc()
+	}
+	return missing, err
+}
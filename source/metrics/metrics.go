@@ -0,0 +1,140 @@
+package metrics
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/client_golang/prometheus/push"
+)
+
+const namespace = "invest_source"
+
+// Result label values.
+const (
+	ResultSuccess = "success"
+	ResultError   = "error"
+	ResultHit     = "hit"
+	ResultMiss    = "miss"
+)
+
+// Metrics instruments the app use-cases, and the providers, caches and outputs they use, in its own registry.
+type Metrics struct {
+	Registry *prometheus.Registry
+
+	runDuration      *prometheus.HistogramVec
+	runLastSuccess   *prometheus.GaugeVec
+	providerDuration *prometheus.HistogramVec
+	providerBytes    *prometheus.CounterVec
+	providerQuotes   *prometheus.CounterVec
+	cacheLookups     *prometheus.CounterVec
+	cacheReadBytes   *prometheus.CounterVec
+	cacheWriteBytes  *prometheus.CounterVec
+	outputDays       prometheus.Counter
+	outputMissing    *prometheus.CounterVec
+}
+
+// New creates metrics registered in a new registry.
+func New() *Metrics {
+	m := &Metrics{
+		Registry: prometheus.NewRegistry(),
+		runDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "run_duration_seconds",
+			Help:      "Duration of app use-case runs.",
+			Buckets:   []float64{.1, .5, 1, 5, 10, 30, 60, 300},
+		}, []string{"usecase", "result"}),
+		runLastSuccess: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "run_last_success_timestamp_seconds",
+			Help:      "Unix time an app use-case last ran successfully.",
+		}, []string{"usecase"}),
+		providerDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "provider_query_duration_seconds",
+			Help:      "Duration of provider queries for the latest quotes.",
+			Buckets:   []float64{.1, .25, .5, 1, 2.5, 5, 10, 30},
+		}, []string{"provider", "result"}),
+		providerBytes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "provider_query_bytes_total",
+			Help:      "Bytes of data returned by provider queries.",
+		}, []string{"provider"}),
+		providerQuotes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "provider_parsed_quotes_total",
+			Help:      "Quotes parsed from provider data.",
+		}, []string{"provider"}),
+		cacheLookups: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "cache_lookups_total",
+			Help:      "Cache lookups of a single day's data, by whether it was found.",
+		}, []string{"cache", "result"}),
+		cacheReadBytes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "cache_read_bytes_total",
+			Help:      "Bytes of data read from caches.",
+		}, []string{"cache"}),
+		cacheWriteBytes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "cache_written_bytes_total",
+			Help:      "Bytes of data written to caches.",
+		}, []string{"cache"}),
+		outputDays: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "output_days_total",
+			Help:      "Days of quotes written to outputs.",
+		}),
+		outputMissing: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "output_missing_days_total",
+			Help:      "Days written to outputs without a quote for an expected symbol.",
+		}, []string{"symbol"}),
+	}
+	m.Registry.MustRegister(
+		m.runDuration, m.runLastSuccess,
+		m.providerDuration, m.providerBytes, m.providerQuotes,
+		m.cacheLookups, m.cacheReadBytes, m.cacheWriteBytes,
+		m.outputDays, m.outputMissing,
+	)
+	return m
+}
+
+// Run times a use-case run, recording whether it succeeded.
+func (m *Metrics) Run(usecase string, run func() error) error {
+	start := Now()
+	err := run()
+	m.runDuration.WithLabelValues(usecase, result(err)).Observe(Now().Sub(start).Seconds())
+	if err == nil {
+		m.runLastSuccess.WithLabelValues(usecase).Set(float64(Now().UnixNano()) / float64(time.Second))
+	}
+	return err
+}
+
+// Handler serves the metrics to Prometheus scrapes.
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.Registry, promhttp.HandlerOpts{Registry: m.Registry})
+}
+
+// Push replaces the job's metrics in the Pushgateway at the URL, for runs that exit before they could be scraped.
+// If the instance is set, the metrics are grouped under it, so that instances running at the same time, each with their own counters, don't replace each other's metrics.
+func (m *Metrics) Push(url, job, instance string) error {
+	p := push.New(url, job).Gatherer(m.Registry)
+	if instance != "" {
+		p = p.Grouping("instance", instance)
+	}
+	return p.Push()
+}
+
+// WriteTextfile atomically writes the metrics to a file in the text format, for node_exporter's textfile collector.
+func (m *Metrics) WriteTextfile(path string) error {
+	return prometheus.WriteToTextfile(path, m.Registry)
+}
+
+func result(err error) string {
+	if err != nil {
+		return ResultError
+	}
+	return ResultSuccess
+}
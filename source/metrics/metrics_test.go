@@ -0,0 +1,183 @@
+package metrics_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/benjohns1/invest-source/app"
+	"github.com/benjohns1/invest-source/metrics"
+)
+
+type stubProvider struct {
+	data []byte
+	err  error
+}
+
+func (p stubProvider) QueryLatest(context.Context) ([]byte, error) { return p.data, p.err }
+
+func (p stubProvider) ParseQuotes(_ context.Context, _ []byte, symbols ...string) ([]app.Quote, error) {
+	quotes := make([]app.Quote, len(symbols))
+	for i, symbol := range symbols {
+		quotes[i] = app.Quote{Symbol: symbol}
+	}
+	return quotes, nil
+}
+
+type stubCache map[string][]byte
+
+func (c stubCache) ReadSince(context.Context, time.Time) ([][]byte, error) {
+	return [][]byte{c["2021-01-02"], c["2021-01-01"]}, nil
+}
+func (c stubCache) ReadCurrent(context.Context) ([]byte, error) { return c["2021-01-02"], nil }
+func (c stubCache) WriteCurrent(_ context.Context, data []byte) error {
+	c["2021-01-02"] = data
+	return nil
+}
+func (c stubCache) ReadDate(_ context.Context, date time.Time) ([]byte, error) {
+	if date.IsZero() {
+		return nil, errors.New("read failed")
+	}
+	return c[date.Format("2006-01-02")], nil
+}
+func (c stubCache) WriteDate(_ context.Context, date time.Time, data []byte) error {
+	c[date.Format("2006-01-02")] = data
+	return nil
+}
+
+type stubOutput struct{}
+
+func (stubOutput) Begin(string, ...string) (app.DayWriter, error) { return stubWriter{}, nil }
+
+type stubWriter struct{ app.DayWriter }
+
+func (stubWriter) WriteDay(quotes []app.Quote) ([]string, error) {
+	if len(quotes) == 0 {
+		return []string{"BTC", "ETH"}, nil
+	}
+	return []string{"ETH"}, nil
+}
+
+// stepNow returns a time advancing by a second on each call.
+func stepNow() func() time.Time {
+	t := time.Unix(1600000000, 0)
+	return func() time.Time {
+		t = t.Add(time.Second)
+		return t
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	metrics.Now = stepNow()
+	defer func() { metrics.Now = time.Now }()
+	m := metrics.New()
+
+	p := m.Provider("coingecko", stubProvider{data: []byte("12345")})
+	data, err := p.QueryLatest(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "12345", string(data))
+	_, err = m.Provider("coinmarketcap", stubProvider{err: errors.New("down")}).QueryLatest(context.Background())
+	assert.Error(t, err)
+	_, err = p.ParseQuotes(context.Background(), data, "BTC", "ETH")
+	assert.NoError(t, err)
+
+	c := m.Cache("file", stubCache{"2021-01-01": []byte("day1")})
+	_, _ = c.ReadCurrent(context.Background())
+	assert.NoError(t, c.WriteCurrent(context.Background(), []byte("day02")))
+	_, _ = c.ReadCurrent(context.Background())
+	_, _ = c.ReadDate(context.Background(), time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC))
+	_, _ = c.ReadDate(context.Background(), time.Time{})
+	_, _ = c.ReadSince(context.Background(), time.Time{})
+
+	w, err := m.Output(stubOutput{}).Begin("out.csv", "BTC", "ETH")
+	assert.NoError(t, err)
+	_, _ = w.WriteDay(nil)
+	_, _ = w.WriteDay([]app.Quote{{Symbol: "BTC"}})
+
+	assert.NoError(t, m.Run("cache", func() error { return nil }))
+	assert.Error(t, m.Run("output", func() error { return errors.New("failed") }))
+
+	assert.NoError(t, testutil.GatherAndCompare(m.Registry, strings.NewReader(`
+# HELP invest_source_cache_lookups_total Cache lookups of a single day's data, by whether it was found.
+# TYPE invest_source_cache_lookups_total counter
+invest_source_cache_lookups_total{cache="file",result="error"} 1
+invest_source_cache_lookups_total{cache="file",result="hit"} 2
+invest_source_cache_lookups_total{cache="file",result="miss"} 1
+# HELP invest_source_cache_read_bytes_total Bytes of data read from caches.
+# TYPE invest_source_cache_read_bytes_total counter
+invest_source_cache_read_bytes_total{cache="file"} 18
+# HELP invest_source_cache_written_bytes_total Bytes of data written to caches.
+# TYPE invest_source_cache_written_bytes_total counter
+invest_source_cache_written_bytes_total{cache="file"} 5
+# HELP invest_source_output_days_total Days of quotes written to outputs.
+# TYPE invest_source_output_days_total counter
+invest_source_output_days_total 2
+# HELP invest_source_output_missing_days_total Days written to outputs without a quote for an expected symbol.
+# TYPE invest_source_output_missing_days_total counter
+invest_source_output_missing_days_total{symbol="BTC"} 1
+invest_source_output_missing_days_total{symbol="ETH"} 2
+# HELP invest_source_provider_parsed_quotes_total Quotes parsed from provider data.
+# TYPE invest_source_provider_parsed_quotes_total counter
+invest_source_provider_parsed_quotes_total{provider="coingecko"} 2
+# HELP invest_source_provider_query_bytes_total Bytes of data returned by provider queries.
+# TYPE invest_source_provider_query_bytes_total counter
+invest_source_provider_query_bytes_total{provider="coingecko"} 5
+invest_source_provider_query_bytes_total{provider="coinmarketcap"} 0
+# HELP invest_source_run_last_success_timestamp_seconds Unix time an app use-case last ran successfully.
+# TYPE invest_source_run_last_success_timestamp_seconds gauge
+invest_source_run_last_success_timestamp_seconds{usecase="cache"} 1.600000007e+09
+`),
+		"invest_source_cache_lookups_total", "invest_source_cache_read_bytes_total", "invest_source_cache_written_bytes_total",
+		"invest_source_output_days_total", "invest_source_output_missing_days_total",
+		"invest_source_provider_parsed_quotes_total", "invest_source_provider_query_bytes_total",
+		"invest_source_run_last_success_timestamp_seconds",
+	))
+	assert.Equal(t, 2, testutil.CollectAndCount(m.Registry, "invest_source_provider_query_duration_seconds"), "should record a duration per provider and result")
+	assert.Equal(t, 2, testutil.CollectAndCount(m.Registry, "invest_source_run_duration_seconds"))
+
+	path := filepath.Join(t.TempDir(), "invest-source.prom")
+	assert.NoError(t, m.WriteTextfile(path))
+	text, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Contains(t, string(text), `invest_source_run_duration_seconds_count{result="error",usecase="output"} 1`)
+
+	rec := httptest.NewRecorder()
+	m.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	assert.Equal(t, 200, rec.Code)
+	assert.Contains(t, rec.Body.String(), `invest_source_provider_query_duration_seconds_count{provider="coinmarketcap",result="error"} 1`)
+}
+
+func TestMetrics_Push(t *testing.T) {
+	tests := []struct {
+		name     string
+		instance string
+		wantPath string
+	}{
+		{name: "should push under the job", wantPath: "/metrics/job/invest-source"},
+		{name: "should group pushes by instance", instance: "2021/06/23/[$LATEST]abc123", wantPath: "/metrics/job/invest-source/instance@base64/MjAyMS8wNi8yMy9bJExBVEVTVF1hYmMxMjM"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var path string
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				path = r.URL.EscapedPath()
+				w.WriteHeader(http.StatusOK)
+			}))
+			defer srv.Close()
+
+			m := metrics.New()
+			assert.NoError(t, m.Run("cache", func() error { return nil }))
+			assert.NoError(t, m.Push(srv.URL, "invest-source", tt.instance))
+			assert.Equal(t, tt.wantPath, path)
+		})
+	}
+}
@@ -2,63 +2,161 @@ package csv
 
 import (
 	"fmt"
+	"strings"
 
 	"github.com/benjohns1/invest-source/app"
+	"github.com/benjohns1/invest-source/output/destination"
+	"github.com/benjohns1/invest-source/output/gnucash"
 )
 
 // CSV output implementation.
 type Output struct {
-	Dir       string
-	HeaderRow []string
-	Filter    func(app.Quote) bool
-	MapRow    func(app.Quote) ([]string, error)
+	Destination destination.Destination
+	HeaderRow   []string
+	Filter      func(app.Quote) bool
+	MapRow      func(app.Quote) ([]string, error)
 }
 
 var DateFormat = "2006-01-02"
 
+// GnuCash price import columns.
+const (
+	ColumnNamespace = "Namespace"
+	ColumnSymbol    = "Symbol"
+	ColumnDate      = "Date"
+	ColumnPrice     = "Price"
+	ColumnCurrency  = "Currency"
+)
+
+// GnuCashColumns is the default column order of a GnuCash price import.
+var GnuCashColumns = []string{ColumnNamespace, ColumnSymbol, ColumnDate, ColumnPrice, ColumnCurrency}
+
+// GnuCashConfig configures the commodity each symbol is imported as, and the CSV layout.
+type GnuCashConfig struct {
+	Commodities gnucash.Commodities
+	// Columns in output order, case-insensitive (default GnuCashColumns).
+	Columns []string
+	// DateFormat is a Go time layout (default DateFormat).
+	DateFormat string
+}
+
 // NewGnuCashCSV outputs a CSV formatted for a GnuCash price import.
 func NewGnuCashCSV(dir string) (Output, error) {
+	return NewGnuCashCSVWithConfig(dir, GnuCashConfig{})
+}
+
+// NewGnuCashCSVWithConfig outputs a CSV formatted for a GnuCash price import, with configured commodities, columns and date format.
+func NewGnuCashCSVWithConfig(dir string, cfg GnuCashConfig) (Output, error) {
 	if err := Mkdir(dir); err != nil {
 		return Output{}, err
 	}
+	return NewGnuCashCSVWithDestination(destination.Dir{Path: dir}, cfg)
+}
+
+// NewGnuCashCSVWithDestination outputs a CSV formatted for a GnuCash price import to any destination, such as standard output or S3.
+func NewGnuCashCSVWithDestination(dest destination.Destination, cfg GnuCashConfig) (Output, error) {
+	if dest == nil {
+		return Output{}, fmt.Errorf("output Destination must be set")
+	}
+	columns, err := gnuCashColumns(cfg.Columns)
+	if err != nil {
+		return Output{}, err
+	}
+	dateFormat := cfg.DateFormat
+	if dateFormat == "" {
+		dateFormat = DateFormat
+	}
 	return Output{
-		Dir:       dir,
-		HeaderRow: []string{"Namespace", "Symbol", "Date", "Price", "Currency"},
+		Destination: dest,
+		HeaderRow:   columns,
 		MapRow: func(q app.Quote) ([]string, error) {
-			return []string{"AMEX", q.Symbol, q.Time.Format(DateFormat), q.USD.String(), "USD"}, nil
+			commodity := cfg.Commodities.Lookup(q.Symbol)
+			price, currency := q.Denominated()
+			row := make([]string, 0, len(columns))
+			for _, column := range columns {
+				switch column {
+				case ColumnNamespace:
+					row = append(row, commodity.Namespace)
+				case ColumnSymbol:
+					row = append(row, commodity.Symbol)
+				case ColumnDate:
+					row = append(row, q.Time.Format(dateFormat))
+				case ColumnPrice:
+					row = append(row, price.String())
+				case ColumnCurrency:
+					row = append(row, currency)
+				}
+			}
+			return row, nil
 		},
 	}, nil
 }
 
-// WriteSet outputs a set of quotes in CSV format.
-func (o Output) WriteSet(filename string, set [][]app.Quote, symbols ...string) (map[int][]string, error) {
-	w, closeWriter, err := o.openWriter(filename)
-	if err != nil {
-		return nil, err
+// gnuCashColumns returns the configured columns in their canonical case, or the default columns.
+func gnuCashColumns(configured []string) ([]string, error) {
+	if len(configured) == 0 {
+		return GnuCashColumns, nil
 	}
-	defer closeWriter()
-
-	rows := o.prepare()
-	missing := make(map[int][]string)
-	for i, quotes := range set {
-		var (
-			m   []string
-			err error
-		)
-		rows, m, err = o.bufferRows(quotes, rows, symbols)
-		if err != nil {
-			return missing, err
+	columns := make([]string, 0, len(configured))
+	seen := make(map[string]struct{}, len(configured))
+	for _, c := range configured {
+		column := ""
+		for _, known := range GnuCashColumns {
+			if strings.EqualFold(strings.TrimSpace(c), known) {
+				column = known
+			}
 		}
-		if len(m) > 0 {
-			missing[i] = m
+		if column == "" {
+			return nil, fmt.Errorf("unknown GnuCash column '%s', must be one of %s", c, strings.Join(GnuCashColumns, ", "))
 		}
-		if err := o.writeBuffer(w, rows); err != nil {
-			return missing, err
+		if _, ok := seen[column]; ok {
+			return nil, fmt.Errorf("duplicate GnuCash column '%s'", column)
 		}
-		rows = make([][]string, 0)
+		seen[column] = struct{}{}
+		columns = append(columns, column)
 	}
+	return columns, nil
+}
 
-	return missing, nil
+// Begin starts writing quotes to a CSV file at the output destination, starting with the header row.
+func (o Output) Begin(filename string, symbols ...string) (app.DayWriter, error) {
+	f, err := o.Destination.Create(filename)
+	if err != nil {
+		return nil, err
+	}
+	w := NewWriter(f)
+	if err := writeRows(w, o.prepare()); err != nil {
+		_ = f.Abort()
+		return nil, err
+	}
+	return &dayWriter{o: o, w: w, f: f, symbols: symbols}, nil
+}
+
+// dayWriter writes each day's quotes to an open CSV file.
+type dayWriter struct {
+	o       Output
+	w       Writer
+	f       destination.File
+	symbols []string
+}
+
+// WriteDay writes a day's quotes as CSV rows.
+func (d *dayWriter) WriteDay(quotes []app.Quote) ([]string, error) {
+	rows, missing, err := d.o.bufferRows(quotes, make([][]string, 0, len(quotes)), d.symbols)
+	if err != nil {
+		return missing, err
+	}
+	return missing, writeRows(d.w, rows)
+}
+
+// Close closes the CSV file.
+func (d *dayWriter) Close() error {
+	return d.f.Close()
+}
+
+// Abort discards the CSV file if the destination allows, otherwise leaves the rows written so far.
+func (d *dayWriter) Abort() error {
+	return d.f.Abort()
 }
 
 func (o Output) prepare() [][]string {
@@ -73,38 +171,18 @@ func (o Output) prepare() [][]string {
 }
 
 func (o Output) bufferRows(quotes []app.Quote, rows [][]string, symbols []string) (outRows [][]string, missing []string, err error) {
-	found := make(map[string]struct{})
+	quotes, missing = app.FilterDay(quotes, symbols, o.Filter)
 	for qNum, q := range quotes {
-		if o.Filter != nil && !o.Filter(q) {
-			continue
-		}
-		found[q.Symbol] = struct{}{}
 		row, err := o.MapRow(q)
 		if err != nil {
 			return rows, nil, fmt.Errorf("quote number %d error: %v", qNum, err)
 		}
 		rows = append(rows, row)
 	}
-
-	if symbols != nil {
-		for _, symbol := range symbols {
-			if _, ok := found[symbol]; !ok {
-				missing = append(missing, symbol)
-			}
-		}
-	}
 	return rows, missing, nil
 }
 
-func (o Output) openWriter(filename string) (Writer, func(), error) {
-	f, err := CreateFile(fmt.Sprintf("%s/%s", o.Dir, filename))
-	if err != nil {
-		return nil, nil, err
-	}
-	return NewWriter(f), func() { _ = f.Close }, nil
-}
-
-func (o Output) writeBuffer(w Writer, rows [][]string) error {
+func writeRows(w Writer, rows [][]string) error {
 	for _, row := range rows {
 		if len(row) == 0 {
 			continue
@@ -114,6 +192,9 @@ func (o Output) writeBuffer(w Writer, rows [][]string) error {
 		}
 	}
 	w.Flush()
+	if err := w.Error(); err != nil {
+		return fmt.Errorf("error flushing CSV rows: %v", err)
+	}
 
 	return nil
 }
@@ -0,0 +1,134 @@
+package csv_test
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/benjohns1/invest-source/app"
+	"github.com/benjohns1/invest-source/output/csv"
+	"github.com/benjohns1/invest-source/output/gnucash"
+)
+
+func TestNewGnuCashCSVWithConfig(t *testing.T) {
+	quote := app.Quote{Time: time.Date(2021, time.June, 21, 23, 59, 0, 0, time.UTC), Symbol: "BTC", USD: decimal.NewFromInt(35000)}
+	tests := []struct {
+		name       string
+		cfg        csv.GnuCashConfig
+		quote      app.Quote
+		wantHeader []string
+		wantRow    []string
+		wantErr    bool
+	}{
+		{
+			name:       "should default to the AMEX namespace, ISO dates and USD",
+			quote:      quote,
+			wantHeader: []string{"Namespace", "Symbol", "Date", "Price", "Currency"},
+			wantRow:    []string{"AMEX", "BTC", "2021-06-21", "35000", "USD"},
+		},
+		{
+			name: "should map commodities, and order and format columns",
+			cfg: csv.GnuCashConfig{
+				Commodities: gnucash.Commodities{Namespace: "CRYPTO", Symbols: map[string]gnucash.Commodity{"btc": {Symbol: "XBT"}}},
+				Columns:     []string{"date", "namespace", "symbol", "price"},
+				DateFormat:  "01/02/2006",
+			},
+			quote:      quote,
+			wantHeader: []string{"Date", "Namespace", "Symbol", "Price"},
+			wantRow:    []string{"06/21/2021", "CRYPTO", "XBT", "35000"},
+		},
+		{
+			name:       "should write converted prices in their currency",
+			quote:      app.Quote{Time: quote.Time, Symbol: "BTC", USD: quote.USD, Currency: "EUR", Price: decimal.NewFromInt(29000)},
+			wantHeader: []string{"Namespace", "Symbol", "Date", "Price", "Currency"},
+			wantRow:    []string{"AMEX", "BTC", "2021-06-21", "29000", "EUR"},
+		},
+		{
+			name:    "should fail with an unknown column",
+			cfg:     csv.GnuCashConfig{Columns: []string{"Date", "Volume"}},
+			wantErr: true,
+		},
+		{
+			name:    "should fail with a duplicate column",
+			cfg:     csv.GnuCashConfig{Columns: []string{"Date", "date"}},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o, err := csv.NewGnuCashCSVWithConfig(t.TempDir(), tt.cfg)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wantHeader, o.HeaderRow)
+			row, err := o.MapRow(tt.quote)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wantRow, row)
+		})
+	}
+}
+
+func TestOutput_Begin(t *testing.T) {
+	dir := t.TempDir()
+	o, err := csv.NewGnuCashCSV(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	day1 := time.Date(2021, time.June, 21, 23, 59, 0, 0, time.UTC)
+	day2 := time.Date(2021, time.June, 22, 23, 59, 0, 0, time.UTC)
+
+	w, err := o.Begin("out.csv", "BTC", "ETH")
+	if err != nil {
+		t.Fatal(err)
+	}
+	missing, err := w.WriteDay([]app.Quote{{Time: day1, Symbol: "BTC", USD: decimal.NewFromInt(35000)}, {Time: day1, Symbol: "ETH", USD: decimal.NewFromInt(2250)}})
+	assert.NoError(t, err)
+	assert.Empty(t, missing)
+	missing, err = w.WriteDay([]app.Quote{{Time: day2, Symbol: "BTC", USD: decimal.NewFromInt(35100)}})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"ETH"}, missing)
+	assert.NoError(t, w.Close())
+
+	got, err := ioutil.ReadFile(filepath.Join(dir, "out.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "Namespace,Symbol,Date,Price,Currency\nAMEX,BTC,2021-06-21,35000,USD\nAMEX,ETH,2021-06-21,2250,USD\nAMEX,BTC,2021-06-22,35100,USD\n", string(got))
+}
+
+func TestOutput_Begin_Abort(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "out.csv")
+	if err := ioutil.WriteFile(path, []byte("previous export\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	o, err := csv.NewGnuCashCSV(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w, err := o.Begin("out.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = w.WriteDay([]app.Quote{{Time: time.Date(2021, time.June, 21, 23, 59, 0, 0, time.UTC), Symbol: "BTC", USD: decimal.NewFromInt(35000)}})
+	assert.NoError(t, err)
+	assert.NoError(t, w.Abort())
+
+	got, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "previous export\n", string(got), "an aborted export should leave the previous file")
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Len(t, entries, 1, "an aborted export should remove its temporary file")
+}
@@ -3,18 +3,14 @@ package csv
 import (
 	"encoding/csv"
 	"io"
-	"os"
 	"time"
 
 	"github.com/benjohns1/invest-source/utils/filesystem"
 )
 
 var (
-	// CreateFile for creating a local file.
-	CreateFile = func(name string) (io.WriteCloser, error) { return os.Create(name) }
-
 	// NewWriter creates a new CSV writer.
-	NewWriter = func(w io.WriteCloser) Writer { return csv.NewWriter(w) }
+	NewWriter = func(w io.Writer) Writer { return csv.NewWriter(w) }
 
 	// Now implementation.
 	Now = time.Now
@@ -27,4 +23,5 @@ var (
 type Writer interface {
 	Write([]string) error
 	Flush()
+	Error() error
 }
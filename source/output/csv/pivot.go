@@ -0,0 +1,181 @@
+package csv
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/benjohns1/invest-source/app"
+	"github.com/benjohns1/invest-source/output/destination"
+)
+
+// Fill modes for a pivot CSV cell whose symbol is missing from that day's quotes.
+const (
+	// FillEmpty leaves the cell empty.
+	FillEmpty = "empty"
+	// FillPrevious carries the symbol's most recent earlier price forward, leaving the cell empty before its first price.
+	FillPrevious = "previous"
+	// FillValue writes PivotConfig.FillValue, e.g. NA.
+	FillValue = "value"
+)
+
+// PivotConfig configures a pivot CSV's layout.
+type PivotConfig struct {
+	// DateFormat is a Go time layout (default DateFormat).
+	DateFormat string
+	// Fill mode for missing symbols, case-insensitive (default FillEmpty).
+	Fill string
+	// FillValue written for missing symbols with the FillValue mode.
+	FillValue string
+}
+
+// PivotOutput writes a wide CSV with a row per calendar date from the first to the last quoted date, in date order, and a price column per symbol.
+type PivotOutput struct {
+	Destination destination.Destination
+	Config      PivotConfig
+}
+
+// NewPivotCSV creates a pivot CSV output writing to the destination.
+func NewPivotCSV(dest destination.Destination, cfg PivotConfig) (PivotOutput, error) {
+	if cfg.DateFormat == "" {
+		cfg.DateFormat = DateFormat
+	}
+	cfg.Fill = strings.ToLower(strings.TrimSpace(cfg.Fill))
+	if cfg.Fill == "" {
+		cfg.Fill = FillEmpty
+	}
+	o := PivotOutput{Destination: dest, Config: cfg}
+	if err := o.Validate(); err != nil {
+		return PivotOutput{}, err
+	}
+	return o, nil
+}
+
+// Validate returns an error if the output was not correctly instantiated.
+func (o PivotOutput) Validate() error {
+	if o.Destination == nil {
+		return fmt.Errorf("output Destination must be set")
+	}
+	switch o.Config.Fill {
+	case FillEmpty, FillPrevious, FillValue:
+	default:
+		return fmt.Errorf("unknown pivot Fill '%s', must be one of %s, %s or %s", o.Config.Fill, FillEmpty, FillPrevious, FillValue)
+	}
+
+	return nil
+}
+
+// Begin starts collecting a row per date, with a column for each of the symbols, which must be given.
+func (o PivotOutput) Begin(filename string, symbols ...string) (app.DayWriter, error) {
+	if len(symbols) == 0 {
+		return nil, fmt.Errorf("pivot CSV needs the symbols to output as columns")
+	}
+	columns := make(map[string]int, len(symbols))
+	for i, symbol := range symbols {
+		columns[symbol] = i
+	}
+	return &pivotWriter{
+		o:        o,
+		filename: filename,
+		symbols:  symbols,
+		columns:  columns,
+		rows:     make(map[time.Time][]*string),
+	}, nil
+}
+
+// pivotWriter collects a row per date, since quotes may arrive most recent day first and filling needs them in date order, and writes the CSV when closed.
+type pivotWriter struct {
+	o        PivotOutput
+	filename string
+	symbols  []string
+	columns  map[string]int
+	rows     map[time.Time][]*string
+}
+
+// WriteDay collects a day's prices into the row for its date, returning the symbols missing from them.
+func (w *pivotWriter) WriteDay(quotes []app.Quote) ([]string, error) {
+	quotes, missing := app.FilterDay(quotes, w.symbols, nil)
+	for _, q := range quotes {
+		// key rows by the quote's calendar date, in UTC so that dates can be stepped through when closing
+		y, m, d := q.Time.UTC().Date()
+		date := time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+		row, ok := w.rows[date]
+		if !ok {
+			row = make([]*string, len(w.symbols))
+			w.rows[date] = row
+		}
+		col, ok := w.columns[q.Symbol]
+		if !ok {
+			continue
+		}
+		price, _ := q.Denominated()
+		cell := price.String()
+		row[col] = &cell
+	}
+	return missing, nil
+}
+
+// Close writes a row for every date from the first to the last collected, in date order, filling missing cells, including every cell of dates without any quotes.
+func (w *pivotWriter) Close() error {
+	collected := make([]time.Time, 0, len(w.rows))
+	for date := range w.rows {
+		collected = append(collected, date)
+	}
+	sort.Slice(collected, func(i, j int) bool { return collected[i].Before(collected[j]) })
+	var dates []time.Time
+	if len(collected) > 0 {
+		for date := collected[0]; !date.After(collected[len(collected)-1]); date = date.AddDate(0, 0, 1) {
+			dates = append(dates, date)
+		}
+	}
+
+	f, err := w.o.Destination.Create(w.filename)
+	if err != nil {
+		return err
+	}
+	cw := NewWriter(f)
+	rows := make([][]string, 0, len(dates)+1)
+	rows = append(rows, append([]string{ColumnDate}, w.symbols...))
+	previous := make([]string, len(w.symbols))
+	for _, date := range dates {
+		row := make([]string, 0, len(w.symbols)+1)
+		row = append(row, date.Format(w.o.Config.DateFormat))
+		cells := w.rows[date]
+		if cells == nil {
+			cells = make([]*string, len(w.symbols))
+		}
+		for i, cell := range cells {
+			row = append(row, w.fill(cell, previous[i]))
+			if cell != nil {
+				previous[i] = *cell
+			}
+		}
+		rows = append(rows, row)
+	}
+	if err := writeRows(cw, rows); err != nil {
+		_ = f.Abort()
+		return err
+	}
+	return f.Close()
+}
+
+func (w *pivotWriter) fill(cell *string, previous string) string {
+	if cell != nil {
+		return *cell
+	}
+	switch w.o.Config.Fill {
+	case FillPrevious:
+		return previous
+	case FillValue:
+		return w.o.Config.FillValue
+	default:
+		return ""
+	}
+}
+
+// Abort discards the collected rows.
+func (w *pivotWriter) Abort() error {
+	w.rows = nil
+	return nil
+}
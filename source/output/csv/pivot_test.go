@@ -0,0 +1,147 @@
+package csv_test
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/benjohns1/invest-source/app"
+	"github.com/benjohns1/invest-source/output/csv"
+	"github.com/benjohns1/invest-source/output/destination"
+)
+
+func TestPivotOutput(t *testing.T) {
+	quote := func(day int, symbol string, usd int64) app.Quote {
+		return app.Quote{Time: time.Date(2021, time.June, day, 23, 59, 0, 0, time.UTC), Symbol: symbol, USD: decimal.NewFromInt(usd)}
+	}
+	// days arrive most recent first, as read from the cache
+	set := [][]app.Quote{
+		{quote(23, "BTC", 34000), quote(23, "ETH", 2300), quote(23, "DOGE", 1)},
+		{quote(22, "BTC", 33000)},
+		{quote(21, "ETH", 2250)},
+	}
+	tests := []struct {
+		name    string
+		cfg     csv.PivotConfig
+		symbols []string
+		want    string
+		wantErr bool
+	}{
+		{
+			name:    "should leave missing symbols empty by default",
+			symbols: []string{"BTC", "ETH"},
+			want:    "Date,BTC,ETH\n2021-06-21,,2250\n2021-06-22,33000,\n2021-06-23,34000,2300\n",
+		},
+		{
+			name:    "should fill missing symbols with their previous price",
+			cfg:     csv.PivotConfig{Fill: "Previous", DateFormat: "01/02/2006"},
+			symbols: []string{"ETH", "BTC"},
+			want:    "Date,ETH,BTC\n06/21/2021,2250,\n06/22/2021,2250,33000\n06/23/2021,2300,34000\n",
+		},
+		{
+			name:    "should fill missing symbols with a value, including on days without any of the symbols",
+			cfg:     csv.PivotConfig{Fill: csv.FillValue, FillValue: "NA"},
+			symbols: []string{"BTC", "USDC"},
+			want:    "Date,BTC,USDC\n2021-06-21,NA,NA\n2021-06-22,33000,NA\n2021-06-23,34000,NA\n",
+		},
+		{
+			name:    "should fail with an unknown fill mode",
+			cfg:     csv.PivotConfig{Fill: "interpolate"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			o, err := csv.NewPivotCSV(destination.Writer{W: &buf}, tt.cfg)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			w, err := o.Begin("out.csv", tt.symbols...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, day := range set {
+				_, err := w.WriteDay(day)
+				assert.NoError(t, err)
+			}
+			assert.NoError(t, w.Close())
+			assert.Equal(t, tt.want, buf.String())
+		})
+	}
+}
+
+func TestPivotOutput_calendarGaps(t *testing.T) {
+	quote := func(day int, symbol string, usd int64) app.Quote {
+		return app.Quote{Time: time.Date(2021, time.June, day, 23, 59, 0, 0, time.UTC), Symbol: symbol, USD: decimal.NewFromInt(usd)}
+	}
+	// the 22nd has no quotes at all, and the 21st isn't read
+	set := [][]app.Quote{
+		{quote(23, "BTC", 34000)},
+		{},
+		{quote(20, "BTC", 31000)},
+	}
+	tests := []struct {
+		name string
+		cfg  csv.PivotConfig
+		set  [][]app.Quote
+		want string
+	}{
+		{
+			name: "should write empty rows for days without quotes",
+			want: "Date,BTC\n2021-06-20,31000\n2021-06-21,\n2021-06-22,\n2021-06-23,34000\n",
+		},
+		{
+			name: "should fill days without quotes with the previous price",
+			cfg:  csv.PivotConfig{Fill: "Previous"},
+			want: "Date,BTC\n2021-06-20,31000\n2021-06-21,31000\n2021-06-22,31000\n2021-06-23,34000\n",
+		},
+		{
+			name: "should date rows by the quotes' UTC day",
+			set: [][]app.Quote{
+				{{Time: time.Date(2021, time.June, 22, 1, 30, 0, 0, time.FixedZone("CEST", 2*60*60)), Symbol: "BTC", USD: decimal.NewFromInt(33000)}},
+				{quote(20, "BTC", 31000)},
+			},
+			want: "Date,BTC\n2021-06-20,31000\n2021-06-21,33000\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			o, err := csv.NewPivotCSV(destination.Writer{W: &buf}, tt.cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			w, err := o.Begin("out.csv", "BTC")
+			if err != nil {
+				t.Fatal(err)
+			}
+			days := tt.set
+			if days == nil {
+				days = set
+			}
+			for _, day := range days {
+				_, err := w.WriteDay(day)
+				assert.NoError(t, err)
+			}
+			assert.NoError(t, w.Close())
+			assert.Equal(t, tt.want, buf.String())
+		})
+	}
+}
+
+func TestPivotOutput_Begin(t *testing.T) {
+	o, err := csv.NewPivotCSV(destination.Writer{W: &bytes.Buffer{}}, csv.PivotConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = o.Begin("out.csv")
+	assert.Error(t, err, "should need symbols for the columns")
+}
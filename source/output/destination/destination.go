@@ -0,0 +1,187 @@
+package destination
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/benjohns1/invest-source/cache/keyval"
+	keyvalProvider "github.com/benjohns1/invest-source/cache/keyval/provider"
+	"github.com/benjohns1/invest-source/utils/filesystem"
+)
+
+// Stdout is the destination URL for writing output to standard output.
+const Stdout = "-"
+
+// Destination creates the files an output is written to.
+type Destination interface {
+	Create(filename string) (File, error)
+}
+
+// File is an output file being written.
+type File interface {
+	io.Writer
+	// Close finishes the file, publishing it if the destination is remote.
+	Close() error
+	// Abort discards what it can of the file, instead of closing it after a failure.
+	Abort() error
+}
+
+// Open creates a destination selected by the URL:
+//
+//	"-" for standard output
+//	file:///path/to/dir or a plain path for a local directory
+//	s3://bucket/prefix, gs://bucket/prefix or azblob://container/prefix for remote storage, configured like cache URLs except that S3 overwrites by default
+//
+// A name query param, e.g. s3://bucket/prefix?name=prices.csv, writes every output to that fixed name, such as to publish a rolling CSV.
+func Open(ctx context.Context, rawURL string) (Destination, error) {
+	if rawURL == Stdout {
+		return Writer{W: os.Stdout}, nil
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid output destination URL '%s': %v", rawURL, err)
+	}
+	name := u.Query().Get("name")
+
+	var d Destination
+	switch u.Scheme {
+	case "":
+		d, err = NewDir(rawURL)
+	case "file":
+		d, err = NewDir(filepath.FromSlash(u.Host + u.Path))
+	default:
+		q := u.Query()
+		q.Del("name")
+		u.RawQuery = q.Encode()
+		var p keyval.Provider
+		var loc keyvalProvider.Location
+		// outputs replace files of the same name, such as a rolling CSV, unlike cache writes
+		if p, loc, err = keyvalProvider.OpenOverwriting(ctx, u.String()); err == nil {
+			d = Remote{Provider: p, Bucket: loc.Bucket, Prefix: loc.Prefix}
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if name != "" {
+		d = Named{Destination: d, Name: name}
+	}
+	return d, nil
+}
+
+// Writer destination writes every file to a single writer, such as standard output, without closing it.
+type Writer struct {
+	W io.Writer
+}
+
+// Create returns a file writing to the writer.
+func (d Writer) Create(_ string) (File, error) {
+	return writerFile{d.W}, nil
+}
+
+type writerFile struct {
+	io.Writer
+}
+
+func (writerFile) Close() error { return nil }
+func (writerFile) Abort() error { return nil }
+
+// Dir destination writes files to a local directory.
+type Dir struct {
+	Path string
+}
+
+// NewDir creates a directory destination, making the directory if it doesn't exist.
+func NewDir(dir string) (Dir, error) {
+	if dir == "" {
+		return Dir{}, fmt.Errorf("output directory must be set")
+	}
+	if err := filesystem.Mkdir(dir); err != nil {
+		return Dir{}, err
+	}
+	return Dir{Path: dir}, nil
+}
+
+// Create creates a file in the directory, which only replaces any existing file of the same name once closed.
+func (d Dir) Create(filename string) (File, error) {
+	f, err := filesystem.CreateAtomic(filepath.Join(d.Path, filename))
+	if err != nil {
+		return nil, fmt.Errorf("error creating output file: %v", err)
+	}
+	return dirFile{f}, nil
+}
+
+type dirFile struct {
+	*filesystem.AtomicFile
+}
+
+func (f dirFile) Close() error { return f.AtomicFile.Commit() }
+
+// Remote destination uploads files to a key-value provider, such as S3, under the key prefix.
+type Remote struct {
+	Provider keyval.Provider
+	Bucket   string
+	Prefix   string
+}
+
+// Create starts streaming a file to the provider, which is only complete once the file is closed.
+func (d Remote) Create(filename string) (File, error) {
+	key := filename
+	if d.Prefix != "" {
+		key = path.Join(strings.TrimSuffix(d.Prefix, "/"), filename)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	pr, pw := io.Pipe()
+	f := &remoteFile{pw: pw, cancel: cancel, done: make(chan error, 1)}
+	go func() {
+		defer cancel()
+		err := d.Provider.Upload(ctx, d.Bucket, key, pr)
+		// unblock writes if the upload stops reading early
+		_ = pr.CloseWithError(err)
+		f.done <- err
+	}()
+	return f, nil
+}
+
+type remoteFile struct {
+	pw     *io.PipeWriter
+	cancel context.CancelFunc
+	done   chan error
+}
+
+func (f *remoteFile) Write(p []byte) (int, error) {
+	return f.pw.Write(p)
+}
+
+func (f *remoteFile) Close() error {
+	_ = f.pw.Close()
+	if err := <-f.done; err != nil {
+		return fmt.Errorf("error uploading output file: %v", err)
+	}
+	return nil
+}
+
+func (f *remoteFile) Abort() error {
+	// cancel the upload too, so a provider doesn't commit what it has read so far
+	f.cancel()
+	_ = f.pw.CloseWithError(fmt.Errorf("output aborted"))
+	<-f.done
+	return nil
+}
+
+// Named destination writes every file to the same name, ignoring the name it's created with.
+type Named struct {
+	Destination Destination
+	Name        string
+}
+
+// Create creates the named file.
+func (d Named) Create(_ string) (File, error) {
+	return d.Destination.Create(d.Name)
+}
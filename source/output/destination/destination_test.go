@@ -0,0 +1,169 @@
+package destination_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/benjohns1/invest-source/cache/keyval/keyvaltest"
+	keyvalProvider "github.com/benjohns1/invest-source/cache/keyval/provider"
+	"github.com/benjohns1/invest-source/output/destination"
+)
+
+func write(t *testing.T, d destination.Destination, filename string, data string) {
+	f, err := d.Create(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = io.WriteString(f, data)
+	assert.NoError(t, err)
+	assert.NoError(t, f.Close())
+}
+
+func TestOpen(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name     string
+		url      string
+		wantPath string
+		wantErr  bool
+	}{
+		{
+			name:     "should write to a plain directory path",
+			url:      filepath.Join(dir, "plain"),
+			wantPath: filepath.Join(dir, "plain", "out.csv"),
+		},
+		{
+			name:     "should write to a file URL directory",
+			url:      "file://" + filepath.ToSlash(filepath.Join(dir, "url")),
+			wantPath: filepath.Join(dir, "url", "out.csv"),
+		},
+		{
+			name:     "should write every file to a fixed name",
+			url:      "file://" + filepath.ToSlash(filepath.Join(dir, "named")) + "?name=latest.csv",
+			wantPath: filepath.Join(dir, "named", "latest.csv"),
+		},
+		{
+			name:    "should fail with an unsupported scheme",
+			url:     "ftp://host/dir",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, err := destination.Open(context.Background(), tt.url)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			write(t, d, "out.csv", "a,b\n")
+			got, err := ioutil.ReadFile(tt.wantPath)
+			assert.NoError(t, err)
+			assert.Equal(t, "a,b\n", string(got))
+		})
+	}
+}
+
+func TestOpen_s3SameName(t *testing.T) {
+	srv := keyvaltest.NewS3Server(t)
+
+	tests := []struct {
+		name    string
+		query   string
+		wantErr bool
+	}{
+		{name: "should overwrite the same name by default", query: "name=prices.csv"},
+		{name: "should not overwrite the same name with no_overwrite=true", query: "name=prices.csv&no_overwrite=true", wantErr: true},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, err := destination.Open(context.Background(), fmt.Sprintf("s3://bucket%d/exports?endpoint=%s&%s", i, srv.URL, tt.query))
+			if err != nil {
+				t.Fatal(err)
+			}
+			write(t, d, "2021-06-01.csv", "first\n")
+
+			f, err := d.Create("2021-06-02.csv")
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, _ = io.WriteString(f, "second\n")
+			err = f.Close()
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, map[string]string{
+				"bucket0/exports/prices.csv": "second\n",
+			}, srv.Objects())
+		})
+	}
+}
+
+func TestOpen_Stdout(t *testing.T) {
+	d, err := destination.Open(context.Background(), destination.Stdout)
+	assert.NoError(t, err)
+	assert.IsType(t, destination.Writer{}, d)
+}
+
+func TestWriter(t *testing.T) {
+	var buf bytes.Buffer
+	d := destination.Writer{W: &buf}
+	write(t, d, "one.csv", "1\n")
+	write(t, d, "two.csv", "2\n")
+	assert.Equal(t, "1\n2\n", buf.String())
+}
+
+func TestRemote(t *testing.T) {
+	p := keyvalProvider.NewMemory()
+	d := destination.Remote{Provider: p, Bucket: "bucket", Prefix: "exports/"}
+	write(t, d, "out.csv", "a,b\n")
+
+	var got bytes.Buffer
+	ok, err := p.Download(context.Background(), "bucket", "exports/out.csv", &got)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "a,b\n", got.String())
+
+	f, err := d.Create("aborted.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = io.WriteString(f, "partial")
+	assert.NoError(t, f.Abort())
+	ok, err = p.Download(context.Background(), "bucket", "exports/aborted.csv", &got)
+	assert.NoError(t, err)
+	assert.False(t, ok, "aborted file should not be uploaded")
+}
+
+type failingProvider struct{}
+
+func (failingProvider) Upload(_ context.Context, _, _ string, _ io.Reader) error {
+	return fmt.Errorf("access denied")
+}
+
+func (failingProvider) Download(_ context.Context, _, _ string, _ io.Writer) (bool, error) {
+	return false, nil
+}
+
+func TestRemote_UploadError(t *testing.T) {
+	d := destination.Remote{Provider: failingProvider{}, Bucket: "bucket"}
+	f, err := d.Create("out.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// writes shouldn't block once the upload has failed
+	_, err = f.Write([]byte("a,b\n"))
+	assert.Error(t, err)
+	assert.Error(t, f.Close())
+}
@@ -0,0 +1,126 @@
+package gnucash
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/shopspring/decimal"
+
+	"github.com/benjohns1/invest-source/app"
+)
+
+const (
+	// PriceSource recorded for each price written to a book.
+	PriceSource = "user:price"
+	// PriceType recorded for each price written to a book.
+	PriceType = "last"
+	// priceScale is the number of decimal places kept in price fractions.
+	priceScale = 9
+)
+
+// ErrBookLocked is returned by Begin while the book is open in GnuCash, which would overwrite or conflict with the prices written.
+var ErrBookLocked = errors.New("GnuCash book is locked, close it in GnuCash first")
+
+// currencyNamespaces are the namespaces GnuCash has used for currency commodities.
+var currencyNamespaces = []string{"CURRENCY", "ISO4217"}
+
+// OpenBook returns an output writing prices into the GnuCash book at path, detecting whether it's a SQLite or an XML book.
+func OpenBook(path string, commodities Commodities) (app.Output, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("error opening GnuCash book: %v", err)
+	}
+	defer func() { _ = f.Close() }()
+	header := make([]byte, 16)
+	n, err := io.ReadFull(f, header)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return nil, fmt.Errorf("error reading GnuCash book: %v", err)
+	}
+	if bytes.Equal(header[:n], []byte("SQLite format 3\x00")) {
+		return NewSQLiteBook(path, commodities)
+	}
+	return NewXMLBook(path, commodities)
+}
+
+// price is a quote resolved to the commodity and currency it's written as.
+type price struct {
+	commodity Commodity
+	currency  string
+	time      time.Time
+	num       int64
+	denom     int64
+}
+
+// day identifies the commodity, currency and day of a price, as books keep at most one price per day.
+func (p price) day() string {
+	return fmt.Sprintf("%s:%s:%s:%s", p.commodity.Namespace, p.commodity.Symbol, p.currency, p.time.UTC().Format("2006-01-02"))
+}
+
+// resolveDay resolves a day's quotes to prices, reporting symbols that are missing from the quotes or whose commodity isn't in the book.
+func resolveDay(commodities Commodities, quotes []app.Quote, symbols []string, inBook func(Commodity) bool) ([]price, []string, error) {
+	notInBook := make([]string, 0)
+	quotes, missing := app.FilterDay(quotes, symbols, func(q app.Quote) bool {
+		if inBook(commodities.Lookup(q.Symbol)) {
+			return true
+		}
+		notInBook = append(notInBook, q.Symbol)
+		return false
+	})
+	prices := make([]price, 0, len(quotes))
+	for _, q := range quotes {
+		commodity := commodities.Lookup(q.Symbol)
+		value, currency := q.Denominated()
+		num, denom, err := fraction(value)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error converting %s price %s: %v", q.Symbol, value, err)
+		}
+		prices = append(prices, price{commodity: commodity, currency: currency, time: q.Time.UTC(), num: num, denom: denom})
+	}
+	reported := notInBook
+	for _, symbol := range missing {
+		if !contains(notInBook, symbol) {
+			reported = append(reported, symbol)
+		}
+	}
+	return prices, reported, nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// fraction converts a price into the numerator and denominator GnuCash stores, rounded to priceScale decimal places.
+func fraction(d decimal.Decimal) (int64, int64, error) {
+	d = d.Round(priceScale)
+	exp := 0
+	if s := d.String(); strings.Contains(s, ".") {
+		exp = len(s) - strings.Index(s, ".") - 1
+	}
+	denom := decimal.New(1, int32(exp))
+	num := d.Mul(denom)
+	if !num.Equal(num.Truncate(0)) || num.Abs().GreaterThan(decimal.NewFromInt(1<<62)) {
+		return 0, 0, fmt.Errorf("price out of range")
+	}
+	return num.IntPart(), denom.IntPart(), nil
+}
+
+// newGUID returns a random GnuCash GUID.
+func newGUID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,43 @@
+package gnucash
+
+import (
+	"strings"
+)
+
+// DefaultNamespace is the namespace used for symbols without a mapping, unless Commodities.Namespace is set.
+const DefaultNamespace = "AMEX"
+
+// Commodity identifies a GnuCash commodity by its namespace and symbol, which GnuCash calls the mnemonic.
+type Commodity struct {
+	Namespace string
+	Symbol    string
+}
+
+// Commodities maps quote symbols to the commodities in a GnuCash book.
+type Commodities struct {
+	// Namespace for symbols without a mapping, or with a mapping that leaves it empty.
+	Namespace string
+	// Symbols maps quote symbols, case-insensitively, to commodities. An empty commodity Symbol keeps the quote symbol.
+	Symbols map[string]Commodity
+}
+
+// Lookup returns the commodity a quote symbol maps to.
+func (c Commodities) Lookup(symbol string) Commodity {
+	commodity := Commodity{}
+	for s, mapped := range c.Symbols {
+		if strings.EqualFold(s, symbol) {
+			commodity = mapped
+			break
+		}
+	}
+	if commodity.Namespace == "" {
+		commodity.Namespace = c.Namespace
+	}
+	if commodity.Namespace == "" {
+		commodity.Namespace = DefaultNamespace
+	}
+	if commodity.Symbol == "" {
+		commodity.Symbol = symbol
+	}
+	return commodity
+}
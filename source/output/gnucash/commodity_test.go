@@ -0,0 +1,62 @@
+package gnucash_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/benjohns1/invest-source/output/gnucash"
+)
+
+func TestCommodities_Lookup(t *testing.T) {
+	commodities := gnucash.Commodities{
+		Namespace: "CRYPTO",
+		Symbols: map[string]gnucash.Commodity{
+			"btc":   {Symbol: "XBT"},
+			"QQQ":   {Namespace: "NASDAQ"},
+			"VTSAX": {Namespace: "FUND", Symbol: "VTSAX-ADM"},
+		},
+	}
+	tests := []struct {
+		name        string
+		commodities gnucash.Commodities
+		symbol      string
+		want        gnucash.Commodity
+	}{
+		{
+			name:        "should default to the AMEX namespace without any config",
+			commodities: gnucash.Commodities{},
+			symbol:      "BTC",
+			want:        gnucash.Commodity{Namespace: "AMEX", Symbol: "BTC"},
+		},
+		{
+			name:        "should use the configured namespace for unmapped symbols",
+			commodities: commodities,
+			symbol:      "ETH",
+			want:        gnucash.Commodity{Namespace: "CRYPTO", Symbol: "ETH"},
+		},
+		{
+			name:        "should override the symbol case-insensitively, keeping the configured namespace",
+			commodities: commodities,
+			symbol:      "BTC",
+			want:        gnucash.Commodity{Namespace: "CRYPTO", Symbol: "XBT"},
+		},
+		{
+			name:        "should override the namespace, keeping the symbol",
+			commodities: commodities,
+			symbol:      "QQQ",
+			want:        gnucash.Commodity{Namespace: "NASDAQ", Symbol: "QQQ"},
+		},
+		{
+			name:        "should override both",
+			commodities: commodities,
+			symbol:      "VTSAX",
+			want:        gnucash.Commodity{Namespace: "FUND", Symbol: "VTSAX-ADM"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, tt.commodities.Lookup(tt.symbol))
+		})
+	}
+}
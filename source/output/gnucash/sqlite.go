@@ -0,0 +1,185 @@
+package gnucash
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+
+	// SQLite driver for GnuCash SQLite books.
+	_ "modernc.org/sqlite"
+
+	"github.com/benjohns1/invest-source/app"
+)
+
+// sqliteDateFormat is how GnuCash stores price dates, in UTC.
+const sqliteDateFormat = "2006-01-02 15:04:05"
+
+// SQLiteBook output writes prices into the prices table of a GnuCash SQLite book, in a single transaction.
+type SQLiteBook struct {
+	Path        string
+	Commodities Commodities
+}
+
+// NewSQLiteBook creates an output writing prices into the GnuCash SQLite book at path.
+func NewSQLiteBook(path string, commodities Commodities) (SQLiteBook, error) {
+	b := SQLiteBook{
+		Path:        path,
+		Commodities: commodities,
+	}
+	if err := b.Validate(); err != nil {
+		return SQLiteBook{}, err
+	}
+	return b, nil
+}
+
+// Validate returns an error if the output was not correctly instantiated.
+func (b SQLiteBook) Validate() error {
+	if b.Path == "" {
+		return fmt.Errorf("book Path must be set")
+	}
+	if _, err := os.Stat(b.Path); err != nil {
+		return fmt.Errorf("book '%s' must exist: %v", b.Path, err)
+	}
+
+	return nil
+}
+
+// Begin opens the book and starts the single transaction all prices are inserted in, returning ErrBookLocked if GnuCash has the book open. The filename is ignored.
+func (b SQLiteBook) Begin(_ string, symbols ...string) (app.DayWriter, error) {
+	db, err := sql.Open("sqlite", b.Path)
+	if err != nil {
+		return nil, fmt.Errorf("error opening book: %v", err)
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("error starting book transaction: %v", err)
+	}
+	if err := checkSQLiteLock(tx); err != nil {
+		_ = tx.Rollback()
+		_ = db.Close()
+		return nil, err
+	}
+	guids, err := commodityGUIDs(tx)
+	if err != nil {
+		_ = tx.Rollback()
+		_ = db.Close()
+		return nil, err
+	}
+	return &sqliteWriter{book: b, db: db, tx: tx, guids: guids, symbols: symbols}, nil
+}
+
+// checkSQLiteLock returns ErrBookLocked if the book's gnclock table has a row, which GnuCash inserts while it has the book open.
+func checkSQLiteLock(tx *sql.Tx) error {
+	var tables int
+	if err := tx.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'gnclock'").Scan(&tables); err != nil {
+		return fmt.Errorf("error reading book lock: %v", err)
+	}
+	if tables == 0 {
+		return nil
+	}
+	var hostname string
+	var pid int
+	err := tx.QueryRow("SELECT hostname, pid FROM gnclock LIMIT 1").Scan(&hostname, &pid)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error reading book lock: %v", err)
+	}
+	return fmt.Errorf("%w: opened on host '%s' by process %d", ErrBookLocked, hostname, pid)
+}
+
+// sqliteWriter inserts each day's prices into an open book transaction.
+type sqliteWriter struct {
+	book    SQLiteBook
+	db      *sql.DB
+	tx      *sql.Tx
+	guids   map[Commodity]string
+	symbols []string
+}
+
+// WriteDay inserts a price for each quote whose commodity is in the book, skipping commodities that already have a price for the day.
+// Symbols whose commodity isn't in the book are reported as missing.
+func (w *sqliteWriter) WriteDay(quotes []app.Quote) ([]string, error) {
+	prices, missing, err := resolveDay(w.book.Commodities, quotes, w.symbols, func(c Commodity) bool {
+		_, ok := w.guids[c]
+		return ok
+	})
+	if err != nil {
+		return missing, err
+	}
+
+	for _, p := range prices {
+		commodityGUID := w.guids[p.commodity]
+		currencyGUID, err := currencyGUID(w.guids, p.currency)
+		if err != nil {
+			return missing, err
+		}
+		var existing int
+		if err := w.tx.QueryRow(
+			"SELECT COUNT(*) FROM prices WHERE commodity_guid = ? AND currency_guid = ? AND substr(date, 1, 10) = ?",
+			commodityGUID, currencyGUID, p.time.Format("2006-01-02"),
+		).Scan(&existing); err != nil {
+			return missing, fmt.Errorf("error reading existing prices: %v", err)
+		}
+		if existing > 0 {
+			continue
+		}
+		guid, err := newGUID()
+		if err != nil {
+			return missing, err
+		}
+		if _, err := w.tx.Exec(
+			"INSERT INTO prices (guid, commodity_guid, currency_guid, date, source, type, value_num, value_denom) VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+			guid, commodityGUID, currencyGUID, p.time.Format(sqliteDateFormat), PriceSource, PriceType, p.num, p.denom,
+		); err != nil {
+			return missing, fmt.Errorf("error inserting %s price: %v", p.commodity.Symbol, err)
+		}
+	}
+	return missing, nil
+}
+
+// Close commits the prices and closes the book.
+func (w *sqliteWriter) Close() error {
+	defer func() { _ = w.db.Close() }()
+	if err := w.tx.Commit(); err != nil {
+		return fmt.Errorf("error committing book transaction: %v", err)
+	}
+	return nil
+}
+
+// Abort rolls back the prices, leaving the book unchanged.
+func (w *sqliteWriter) Abort() error {
+	defer func() { _ = w.db.Close() }()
+	return w.tx.Rollback()
+}
+
+func commodityGUIDs(tx *sql.Tx) (map[Commodity]string, error) {
+	rows, err := tx.Query("SELECT guid, namespace, mnemonic FROM commodities")
+	if err != nil {
+		return nil, fmt.Errorf("error reading book commodities: %v", err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	guids := make(map[Commodity]string)
+	for rows.Next() {
+		var guid string
+		var c Commodity
+		if err := rows.Scan(&guid, &c.Namespace, &c.Symbol); err != nil {
+			return nil, fmt.Errorf("error reading book commodity: %v", err)
+		}
+		guids[c] = guid
+	}
+	return guids, rows.Err()
+}
+
+func currencyGUID(guids map[Commodity]string, currency string) (string, error) {
+	for _, namespace := range currencyNamespaces {
+		if guid, ok := guids[Commodity{Namespace: namespace, Symbol: currency}]; ok {
+			return guid, nil
+		}
+	}
+	return "", fmt.Errorf("currency %s isn't in the book", currency)
+}
@@ -0,0 +1,184 @@
+package gnucash_test
+
+import (
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/benjohns1/invest-source/app"
+	"github.com/benjohns1/invest-source/output/gnucash"
+)
+
+var (
+	day1 = time.Date(2021, time.June, 21, 23, 59, 0, 0, time.UTC)
+	day2 = time.Date(2021, time.June, 22, 23, 59, 0, 0, time.UTC)
+)
+
+func quote(t time.Time, symbol string, usd string) app.Quote {
+	return app.Quote{Time: t, Symbol: symbol, USD: decimal.RequireFromString(usd)}
+}
+
+// writeSet writes each day of quotes to the output, returning the symbols missing from each day.
+func writeSet(out app.Output, set [][]app.Quote, symbols ...string) (map[int][]string, error) {
+	w, err := out.Begin("ignored", symbols...)
+	if err != nil {
+		return nil, err
+	}
+	missing := make(map[int][]string)
+	for i, quotes := range set {
+		m, err := w.WriteDay(quotes)
+		if len(m) > 0 {
+			missing[i] = m
+		}
+		if err != nil {
+			_ = w.Abort()
+			return missing, err
+		}
+	}
+	return missing, w.Close()
+}
+
+func newSQLiteBook(t *testing.T) string {
+	path := filepath.Join(t.TempDir(), "book.gnucash")
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = db.Close() }()
+	for _, stmt := range []string{
+		"CREATE TABLE commodities (guid text(32) PRIMARY KEY NOT NULL, namespace text(2048) NOT NULL, mnemonic text(2048) NOT NULL)",
+		"CREATE TABLE prices (guid text(32) PRIMARY KEY NOT NULL, commodity_guid text(32) NOT NULL, currency_guid text(32) NOT NULL, date text(19) NOT NULL, source text(2048), type text(2048), value_num bigint NOT NULL, value_denom bigint NOT NULL)",
+		"INSERT INTO commodities VALUES ('usd', 'CURRENCY', 'USD'), ('btc', 'CRYPTO', 'BTC'), ('eth', 'CRYPTO', 'ETH')",
+		"INSERT INTO prices VALUES ('existing', 'eth', 'usd', '2021-06-21 12:00:00', 'user:price', 'last', 2000, 1)",
+	} {
+		if _, err := db.Exec(stmt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return path
+}
+
+func TestSQLiteBook_WriteDay(t *testing.T) {
+	path := newSQLiteBook(t)
+	out, err := gnucash.OpenBook(path, gnucash.Commodities{Namespace: "CRYPTO"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.IsType(t, gnucash.SQLiteBook{}, out)
+
+	set := [][]app.Quote{
+		{quote(day1, "BTC", "35000.12"), quote(day1, "ETH", "2250"), quote(day1, "DOGE", "0.3")},
+		{quote(day2, "BTC", "0.000000001")},
+	}
+	missing, err := writeSet(out, set, "BTC", "ETH", "USDC")
+	assert.NoError(t, err)
+	assert.Equal(t, map[int][]string{0: {"DOGE", "USDC"}, 1: {"ETH", "USDC"}}, missing)
+
+	// writing again should skip the days that now have prices
+	_, err = writeSet(out, set)
+	assert.NoError(t, err)
+
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = db.Close() }()
+	rows, err := db.Query("SELECT commodity_guid, currency_guid, date, source, type, value_num, value_denom FROM prices ORDER BY date, commodity_guid")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = rows.Close() }()
+	got := make([][]interface{}, 0)
+	for rows.Next() {
+		var commodity, currency, date, source, typ string
+		var num, denom int64
+		if err := rows.Scan(&commodity, &currency, &date, &source, &typ, &num, &denom); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, []interface{}{commodity, currency, date, source, typ, num, denom})
+	}
+	assert.Equal(t, [][]interface{}{
+		{"eth", "usd", "2021-06-21 12:00:00", "user:price", "last", int64(2000), int64(1)},
+		{"btc", "usd", "2021-06-21 23:59:00", "user:price", "last", int64(3500012), int64(100)},
+		{"btc", "usd", "2021-06-22 23:59:00", "user:price", "last", int64(1), int64(1000000000)},
+	}, got)
+}
+
+func TestSQLiteBook_WriteDay_MissingCurrency(t *testing.T) {
+	path := newSQLiteBook(t)
+	out, err := gnucash.NewSQLiteBook(path, gnucash.Commodities{Namespace: "CRYPTO"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := quote(day2, "BTC", "35000")
+	q.Currency, q.Price = "EUR", decimal.RequireFromString("30000")
+	_, err = writeSet(out, [][]app.Quote{{quote(day1, "BTC", "35000")}, {q}})
+	assert.Error(t, err)
+
+	// the first day's price should have been rolled back
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = db.Close() }()
+	var count int
+	if err := db.QueryRow("SELECT COUNT(*) FROM prices").Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 1, count)
+}
+
+func TestSQLiteBook_Begin_Locked(t *testing.T) {
+	tests := []struct {
+		name    string
+		stmts   []string
+		wantErr bool
+	}{
+		{
+			name:  "should begin with an empty lock table, once GnuCash has closed the book",
+			stmts: []string{"CREATE TABLE gnclock (Hostname varchar(255), PID int)"},
+		},
+		{
+			name:    "should refuse to begin while GnuCash has the book open",
+			stmts:   []string{"CREATE TABLE gnclock (Hostname varchar(255), PID int)", "INSERT INTO gnclock VALUES ('desktop', 4242)"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := newSQLiteBook(t)
+			db, err := sql.Open("sqlite", path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, stmt := range tt.stmts {
+				if _, err := db.Exec(stmt); err != nil {
+					t.Fatal(err)
+				}
+			}
+			_ = db.Close()
+			out, err := gnucash.NewSQLiteBook(path, gnucash.Commodities{Namespace: "CRYPTO"})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, err = writeSet(out, [][]app.Quote{{quote(day1, "BTC", "35000")}})
+			if tt.wantErr {
+				assert.True(t, errors.Is(err, gnucash.ErrBookLocked), "expected gnucash.ErrBookLocked, got %v", err)
+				assert.Contains(t, err.Error(), "host 'desktop' by process 4242")
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestNewSQLiteBook(t *testing.T) {
+	_, err := gnucash.NewSQLiteBook(filepath.Join(t.TempDir(), "missing.gnucash"), gnucash.Commodities{})
+	assert.Error(t, err)
+}
@@ -0,0 +1,291 @@
+package gnucash
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/benjohns1/invest-source/app"
+	"github.com/benjohns1/invest-source/utils/filesystem"
+)
+
+// xmlDateFormat is how GnuCash XML books store price times.
+const xmlDateFormat = "2006-01-02 15:04:05 -0700"
+
+// XMLBook output writes prices into the price database of a GnuCash XML book, gzipped or not, replacing the book once all prices are added.
+type XMLBook struct {
+	Path        string
+	Commodities Commodities
+}
+
+// NewXMLBook creates an output writing prices into the GnuCash XML book at path.
+func NewXMLBook(path string, commodities Commodities) (XMLBook, error) {
+	b := XMLBook{
+		Path:        path,
+		Commodities: commodities,
+	}
+	if err := b.Validate(); err != nil {
+		return XMLBook{}, err
+	}
+	return b, nil
+}
+
+// Validate returns an error if the output was not correctly instantiated.
+func (b XMLBook) Validate() error {
+	if b.Path == "" {
+		return fmt.Errorf("book Path must be set")
+	}
+	if _, err := os.Stat(b.Path); err != nil {
+		return fmt.Errorf("book '%s' must exist: %v", b.Path, err)
+	}
+
+	return nil
+}
+
+// Begin reads the book's commodities and existing prices, returning ErrBookLocked if GnuCash has the book open. The filename is ignored.
+func (b XMLBook) Begin(_ string, symbols ...string) (app.DayWriter, error) {
+	lock := b.Path + ".LCK"
+	if _, err := os.Stat(lock); err == nil {
+		return nil, fmt.Errorf("%w: lock file '%s' exists", ErrBookLocked, lock)
+	} else if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("error reading book lock: %v", err)
+	}
+	raw, err := ioutil.ReadFile(b.Path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading book: %v", err)
+	}
+	gzipped := bytes.HasPrefix(raw, []byte{0x1f, 0x8b})
+	doc := raw
+	if gzipped {
+		zr, err := gzip.NewReader(bytes.NewReader(raw))
+		if err != nil {
+			return nil, fmt.Errorf("error decompressing book: %v", err)
+		}
+		if doc, err = ioutil.ReadAll(zr); err != nil {
+			return nil, fmt.Errorf("error decompressing book: %v", err)
+		}
+	}
+
+	contents, err := scanXMLBook(doc)
+	if err != nil {
+		return nil, err
+	}
+	return &xmlWriter{book: b, doc: doc, gzipped: gzipped, contents: contents, symbols: symbols}, nil
+}
+
+// xmlWriter collects each day's price elements, to add them to the book when closed.
+type xmlWriter struct {
+	book     XMLBook
+	doc      []byte
+	gzipped  bool
+	contents xmlBookContents
+	symbols  []string
+	added    bytes.Buffer
+}
+
+// WriteDay adds a price for each quote whose commodity is in the book, skipping commodities that already have a price for the day.
+// Symbols whose commodity isn't in the book are reported as missing.
+func (w *xmlWriter) WriteDay(quotes []app.Quote) ([]string, error) {
+	prices, missing, err := resolveDay(w.book.Commodities, quotes, w.symbols, func(c Commodity) bool {
+		_, ok := w.contents.commodities[c]
+		return ok
+	})
+	if err != nil {
+		return missing, err
+	}
+
+	for _, p := range prices {
+		if _, ok := w.contents.days[p.day()]; ok {
+			continue
+		}
+		currency, err := w.contents.currency(p.currency)
+		if err != nil {
+			return missing, err
+		}
+		guid, err := newGUID()
+		if err != nil {
+			return missing, err
+		}
+		writeXMLPrice(&w.added, guid, p, currency)
+		w.contents.days[p.day()] = struct{}{}
+	}
+	return missing, nil
+}
+
+// Close replaces the book with one including the added prices, in one rename.
+func (w *xmlWriter) Close() error {
+	if w.added.Len() == 0 {
+		return nil
+	}
+
+	updated, err := insertXMLPrices(w.doc, w.added.Bytes())
+	if err != nil {
+		return err
+	}
+	if w.gzipped {
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		if _, err := zw.Write(updated); err != nil {
+			return fmt.Errorf("error compressing book: %v", err)
+		}
+		if err := zw.Close(); err != nil {
+			return fmt.Errorf("error compressing book: %v", err)
+		}
+		updated = buf.Bytes()
+	}
+	if err := filesystem.WriteFileAtomic(w.book.Path, updated); err != nil {
+		return fmt.Errorf("error writing book: %v", err)
+	}
+	return nil
+}
+
+// Abort discards the added prices, leaving the book unchanged.
+func (w *xmlWriter) Abort() error {
+	w.added.Reset()
+	return nil
+}
+
+// xmlBookContents are the commodities and existing price days found in an XML book.
+type xmlBookContents struct {
+	commodities map[Commodity]struct{}
+	days        map[string]struct{}
+}
+
+func (c xmlBookContents) currency(currency string) (Commodity, error) {
+	for _, namespace := range currencyNamespaces {
+		commodity := Commodity{Namespace: namespace, Symbol: currency}
+		if _, ok := c.commodities[commodity]; ok {
+			return commodity, nil
+		}
+	}
+	return Commodity{}, fmt.Errorf("currency %s isn't in the book", currency)
+}
+
+// scanXMLBook streams through the book collecting its commodities, and the days its prices are for.
+func scanXMLBook(doc []byte) (xmlBookContents, error) {
+	contents := xmlBookContents{
+		commodities: make(map[Commodity]struct{}),
+		days:        make(map[string]struct{}),
+	}
+	d := xml.NewDecoder(bytes.NewReader(doc))
+	var path []string
+	var commodity Commodity
+	var p price
+	var priceTime string
+	for {
+		tok, err := d.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return contents, fmt.Errorf("error parsing book XML: %v", err)
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			path = append(path, t.Name.Local)
+			switch {
+			case hasSuffix(path, "book", "commodity"):
+				commodity = Commodity{}
+			case hasSuffix(path, "pricedb", "price"):
+				p, priceTime = price{}, ""
+			}
+		case xml.CharData:
+			text := strings.TrimSpace(string(t))
+			switch {
+			case hasSuffix(path, "book", "commodity", "space"):
+				commodity.Namespace = text
+			case hasSuffix(path, "book", "commodity", "id"):
+				commodity.Symbol = text
+			case hasSuffix(path, "price", "commodity", "space"):
+				p.commodity.Namespace = text
+			case hasSuffix(path, "price", "commodity", "id"):
+				p.commodity.Symbol = text
+			case hasSuffix(path, "price", "currency", "id"):
+				p.currency = text
+			case hasSuffix(path, "price", "time", "date"):
+				priceTime = text
+			}
+		case xml.EndElement:
+			switch {
+			case hasSuffix(path, "book", "commodity"):
+				contents.commodities[commodity] = struct{}{}
+			case hasSuffix(path, "pricedb", "price"):
+				if p.time, err = time.Parse(xmlDateFormat, priceTime); err != nil {
+					return contents, fmt.Errorf("error parsing book price time '%s': %v", priceTime, err)
+				}
+				contents.days[p.day()] = struct{}{}
+			}
+			path = path[:len(path)-1]
+		}
+	}
+	return contents, nil
+}
+
+func hasSuffix(path []string, suffix ...string) bool {
+	if len(path) < len(suffix) {
+		return false
+	}
+	for i, s := range suffix {
+		if path[len(path)-len(suffix)+i] != s {
+			return false
+		}
+	}
+	return true
+}
+
+func writeXMLPrice(w *bytes.Buffer, guid string, p price, currency Commodity) {
+	text := func(s string) string {
+		var buf bytes.Buffer
+		_ = xml.EscapeText(&buf, []byte(s))
+		return buf.String()
+	}
+	fmt.Fprintf(w, `  <price>
+    <price:id type="guid">%s</price:id>
+    <price:commodity>
+      <cmdty:space>%s</cmdty:space>
+      <cmdty:id>%s</cmdty:id>
+    </price:commodity>
+    <price:currency>
+      <cmdty:space>%s</cmdty:space>
+      <cmdty:id>%s</cmdty:id>
+    </price:currency>
+    <price:time>
+      <ts:date>%s</ts:date>
+    </price:time>
+    <price:source>%s</price:source>
+    <price:type>%s</price:type>
+    <price:value>%d/%d</price:value>
+  </price>
+`, guid, text(p.commodity.Namespace), text(p.commodity.Symbol), text(currency.Namespace), text(currency.Symbol), p.time.Format(xmlDateFormat), PriceSource, PriceType, p.num, p.denom)
+}
+
+// insertXMLPrices inserts the price elements at the end of the book's price database, creating it after the book's commodities if needed.
+func insertXMLPrices(doc []byte, prices []byte) ([]byte, error) {
+	if i := bytes.LastIndex(doc, []byte("</gnc:pricedb>")); i >= 0 {
+		return splice(doc, i, prices), nil
+	}
+	end := []byte("</gnc:commodity>")
+	i := bytes.LastIndex(doc, end)
+	if i < 0 {
+		return nil, fmt.Errorf("book has no commodities to add a price database after")
+	}
+	i += len(end)
+	if nl := bytes.IndexByte(doc[i:], '\n'); nl >= 0 && len(bytes.TrimSpace(doc[i:i+nl])) == 0 {
+		i += nl + 1
+	}
+	pricedb := append(append([]byte("<gnc:pricedb version=\"1\">\n"), prices...), []byte("</gnc:pricedb>\n")...)
+	return splice(doc, i, pricedb), nil
+}
+
+func splice(doc []byte, i int, insert []byte) []byte {
+	out := make([]byte, 0, len(doc)+len(insert))
+	out = append(out, doc[:i]...)
+	out = append(out, insert...)
+	return append(out, doc[i:]...)
+}
@@ -0,0 +1,168 @@
+package gnucash_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/benjohns1/invest-source/app"
+	"github.com/benjohns1/invest-source/output/gnucash"
+)
+
+const xmlBook = `<?xml version="1.0" encoding="utf-8" ?>
+<gnc-v2
+     xmlns:gnc="http://www.gnucash.org/XML/gnc"
+     xmlns:book="http://www.gnucash.org/XML/book"
+     xmlns:cmdty="http://www.gnucash.org/XML/cmdty"
+     xmlns:price="http://www.gnucash.org/XML/price"
+     xmlns:ts="http://www.gnucash.org/XML/ts">
+<gnc:count-data cd:type="book">1</gnc:count-data>
+<gnc:book version="2.0.0">
+<book:id type="guid">0123456789abcdef0123456789abcdef</book:id>
+<gnc:commodity version="2.0.0">
+  <cmdty:space>CURRENCY</cmdty:space>
+  <cmdty:id>USD</cmdty:id>
+</gnc:commodity>
+<gnc:commodity version="2.0.0">
+  <cmdty:space>CRYPTO</cmdty:space>
+  <cmdty:id>BTC</cmdty:id>
+</gnc:commodity>
+<gnc:commodity version="2.0.0">
+  <cmdty:space>CRYPTO</cmdty:space>
+  <cmdty:id>ETH</cmdty:id>
+</gnc:commodity>
+%PRICEDB%</gnc:book>
+</gnc-v2>
+`
+
+const xmlPriceDB = `<gnc:pricedb version="1">
+  <price>
+    <price:id type="guid">fedcba9876543210fedcba9876543210</price:id>
+    <price:commodity>
+      <cmdty:space>CRYPTO</cmdty:space>
+      <cmdty:id>ETH</cmdty:id>
+    </price:commodity>
+    <price:currency>
+      <cmdty:space>CURRENCY</cmdty:space>
+      <cmdty:id>USD</cmdty:id>
+    </price:currency>
+    <price:time>
+      <ts:date>2021-06-21 08:00:00 -0400</ts:date>
+    </price:time>
+    <price:source>user:price</price:source>
+    <price:type>last</price:type>
+    <price:value>2000/1</price:value>
+  </price>
+</gnc:pricedb>
+`
+
+func TestXMLBook_WriteDay(t *testing.T) {
+	set := [][]app.Quote{
+		{quote(day1, "BTC", "35000.12"), quote(day1, "ETH", "2250"), quote(day1, "DOGE", "0.3")},
+		{quote(day2, "BTC", "35100")},
+	}
+	tests := []struct {
+		name       string
+		pricedb    string
+		gzipped    bool
+		wantPrices []string
+	}{
+		{
+			name:       "should add prices to a gzipped book's price database, skipping days with prices",
+			pricedb:    xmlPriceDB,
+			gzipped:    true,
+			wantPrices: []string{"2000/1", "3500012/100", "35100/1"},
+		},
+		{
+			name:       "should create the price database in an uncompressed book",
+			wantPrices: []string{"3500012/100", "2250/1", "35100/1"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "book.gnucash")
+			doc := []byte(strings.Replace(xmlBook, "%PRICEDB%", tt.pricedb, 1))
+			if tt.gzipped {
+				var buf bytes.Buffer
+				zw := gzip.NewWriter(&buf)
+				_, _ = zw.Write(doc)
+				_ = zw.Close()
+				doc = buf.Bytes()
+			}
+			if err := ioutil.WriteFile(path, doc, 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			out, err := gnucash.OpenBook(path, gnucash.Commodities{Namespace: "CRYPTO"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.IsType(t, gnucash.XMLBook{}, out)
+			missing, err := writeSet(out, set, "BTC", "ETH")
+			assert.NoError(t, err)
+			assert.Equal(t, map[int][]string{0: {"DOGE"}, 1: {"ETH"}}, missing)
+			// writing again should add nothing
+			_, err = writeSet(out, set)
+			assert.NoError(t, err)
+
+			got, err := ioutil.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.gzipped {
+				zr, err := gzip.NewReader(bytes.NewReader(got))
+				if err != nil {
+					t.Fatalf("book should still be gzipped: %v", err)
+				}
+				if got, err = ioutil.ReadAll(zr); err != nil {
+					t.Fatal(err)
+				}
+			}
+			info, err := os.Stat(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+			book := string(got)
+			assert.Equal(t, 1, strings.Count(book, "<gnc:pricedb"))
+			prices := make([]string, 0)
+			for _, part := range strings.Split(book, "<price:value>")[1:] {
+				prices = append(prices, part[:strings.Index(part, "<")])
+			}
+			assert.Equal(t, tt.wantPrices, prices)
+			assert.Contains(t, book, "<ts:date>2021-06-22 23:59:00 +0000</ts:date>")
+			assert.Contains(t, book, "<cmdty:space>CURRENCY</cmdty:space>\n      <cmdty:id>USD</cmdty:id>\n    </price:currency>")
+		})
+	}
+}
+
+func TestXMLBook_Begin_Locked(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "book.gnucash")
+	if err := ioutil.WriteFile(path, []byte(strings.Replace(xmlBook, "%PRICEDB%", "", 1)), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path+".LCK", nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	out, err := gnucash.NewXMLBook(path, gnucash.Commodities{Namespace: "CRYPTO"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = out.Begin("ignored", "BTC")
+	assert.True(t, errors.Is(err, gnucash.ErrBookLocked), "expected gnucash.ErrBookLocked, got %v", err)
+
+	if err := os.Remove(path + ".LCK"); err != nil {
+		t.Fatal(err)
+	}
+	_, err = writeSet(out, [][]app.Quote{{quote(day1, "BTC", "35000")}}, "BTC")
+	assert.NoError(t, err, "should begin once GnuCash has closed the book")
+}
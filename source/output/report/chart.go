@@ -0,0 +1,131 @@
+package report
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// Chart dimensions in SVG user units, the plot is inset by the margins to leave room for the axis labels.
+const (
+	chartWidth  = 720
+	chartHeight = 240
+	marginLeft  = 80
+	marginRight = 16
+	marginY     = 16
+	marginLabel = 24
+	markerSize  = 6
+)
+
+type chartPoint struct {
+	day   time.Time
+	price decimal.Decimal
+	ok    bool
+}
+
+type chartView struct {
+	Width, Height          int
+	Left, Right, Top, Base string
+	Lines                  []string
+	Points                 []pointView
+	Missing                []markerView
+	MinLabel, MaxLabel     string
+	FromLabel, ToLabel     string
+	MinY, MaxY, LabelY     string
+	Title                  string
+}
+
+type pointView struct {
+	X, Y  string
+	Title string
+}
+
+type markerView struct {
+	X, Y1, Y2 string
+	Title     string
+}
+
+// newChart lays out an SVG line chart of the prices, scaled to the first and last day and the lowest and highest price. The line breaks at missing days, which are marked along the base.
+func newChart(symbol string, points []chartPoint) *chartView {
+	left, right := float64(marginLeft), float64(chartWidth-marginRight)
+	top, base := float64(marginY), float64(chartHeight-marginY-marginLabel)
+	c := &chartView{
+		Width:  chartWidth,
+		Height: chartHeight,
+		Left:   coord(left),
+		Right:  coord(right),
+		Top:    coord(top),
+		Base:   coord(base),
+		LabelY: coord(base + marginLabel),
+		Title:  fmt.Sprintf("%s price", symbol),
+	}
+	if len(points) == 0 {
+		return c
+	}
+
+	var low, high *decimal.Decimal
+	for i := range points {
+		if !points[i].ok {
+			continue
+		}
+		p := points[i].price
+		if low == nil || p.LessThan(*low) {
+			low = &p
+		}
+		if high == nil || p.GreaterThan(*high) {
+			high = &p
+		}
+	}
+	if low == nil {
+		return c
+	}
+	lowF, _ := low.Float64()
+	highF, _ := high.Float64()
+	c.MinLabel, c.MaxLabel = low.String(), high.String()
+	c.MinY, c.MaxY = coord(base), coord(top)
+
+	first, last := points[0].day, points[len(points)-1].day
+	c.FromLabel, c.ToLabel = first.Format(dateFormat), last.Format(dateFormat)
+	x := func(day time.Time) float64 {
+		span := last.Sub(first)
+		if span <= 0 {
+			return (left + right) / 2
+		}
+		return left + (right-left)*float64(day.Sub(first))/float64(span)
+	}
+	y := func(price decimal.Decimal) float64 {
+		f, _ := price.Float64()
+		if highF == lowF {
+			return (top + base) / 2
+		}
+		return base - (base-top)*(f-lowF)/(highF-lowF)
+	}
+
+	var line []string
+	for _, p := range points {
+		if !p.ok {
+			c.addLine(line)
+			line = nil
+			c.Missing = append(c.Missing, markerView{X: coord(x(p.day)), Y1: coord(base - markerSize), Y2: coord(base + markerSize), Title: fmt.Sprintf("%s: no price", p.day.Format(dateFormat))})
+			continue
+		}
+		px, py := coord(x(p.day)), coord(y(p.price))
+		line = append(line, px+","+py)
+		c.Points = append(c.Points, pointView{X: px, Y: py, Title: fmt.Sprintf("%s: %s", p.day.Format(dateFormat), p.price)})
+	}
+	c.addLine(line)
+	return c
+}
+
+// addLine adds a segment of the price line, a lone price between missing days is only drawn as its point.
+func (c *chartView) addLine(line []string) {
+	if len(line) > 1 {
+		c.Lines = append(c.Lines, strings.Join(line, " "))
+	}
+}
+
+func coord(f float64) string {
+	return fmt.Sprintf("%.1f", f)
+}
@@ -0,0 +1,10 @@
+package report
+
+import (
+	"time"
+)
+
+var (
+	// Now function for the report's generated timestamp. Override this for unit tests.
+	Now = time.Now
+)
@@ -0,0 +1,238 @@
+package report
+
+import (
+	_ "embed"
+	"fmt"
+	"html/template"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/shopspring/decimal"
+
+	"github.com/benjohns1/invest-source/app"
+	"github.com/benjohns1/invest-source/output/destination"
+)
+
+//go:embed report.html
+var reportHTML string
+
+var reportTemplate = template.Must(template.New("report").Parse(reportHTML))
+
+// ChangeDays are the periods, in days, the summary table shows each symbol's price change over.
+var ChangeDays = []int{1, 7, 30}
+
+const dateFormat = "2006-01-02"
+
+// Output renders a self-contained HTML report, with a summary table and a price chart per symbol, marking the days a symbol's price is missing.
+type Output struct {
+	Destination destination.Destination
+	Title       string
+}
+
+// NewOutput creates an HTML report output writing to the destination.
+func NewOutput(dest destination.Destination, title string) (Output, error) {
+	if title == "" {
+		title = "Price report"
+	}
+	o := Output{Destination: dest, Title: title}
+	if err := o.Validate(); err != nil {
+		return Output{}, err
+	}
+	return o, nil
+}
+
+// Validate returns an error if the output was not correctly instantiated.
+func (o Output) Validate() error {
+	if o.Destination == nil {
+		return fmt.Errorf("output Destination must be set")
+	}
+
+	return nil
+}
+
+// Begin starts collecting prices for a report named after the filename, with an .html extension.
+func (o Output) Begin(filename string, symbols ...string) (app.DayWriter, error) {
+	return &reportWriter{
+		o:        o,
+		filename: strings.TrimSuffix(filename, filepath.Ext(filename)) + ".html",
+		symbols:  symbols,
+		prices:   make(map[string]map[time.Time]dayPrice),
+		days:     make(map[time.Time]struct{}),
+	}, nil
+}
+
+type dayPrice struct {
+	price    decimal.Decimal
+	currency string
+}
+
+// reportWriter collects each symbol's daily prices, and renders the report when closed.
+type reportWriter struct {
+	o        Output
+	filename string
+	symbols  []string
+	prices   map[string]map[time.Time]dayPrice
+	days     map[time.Time]struct{}
+}
+
+// WriteDay collects a day's prices, returning the expected symbols missing from them.
+func (w *reportWriter) WriteDay(quotes []app.Quote) ([]string, error) {
+	quotes, missing := app.FilterDay(quotes, w.symbols, nil)
+	for _, q := range quotes {
+		day := truncateDay(q.Time)
+		w.days[day] = struct{}{}
+		if w.prices[q.Symbol] == nil {
+			w.prices[q.Symbol] = make(map[time.Time]dayPrice)
+		}
+		price, currency := q.Denominated()
+		w.prices[q.Symbol][day] = dayPrice{price: price, currency: currency}
+	}
+	return missing, nil
+}
+
+// Close renders the report to the destination.
+func (w *reportWriter) Close() error {
+	f, err := w.o.Destination.Create(w.filename)
+	if err != nil {
+		return err
+	}
+	if err := reportTemplate.Execute(f, w.view()); err != nil {
+		_ = f.Abort()
+		return fmt.Errorf("error rendering report: %v", err)
+	}
+	return f.Close()
+}
+
+// Abort discards the collected prices.
+func (w *reportWriter) Abort() error {
+	w.prices = nil
+	return nil
+}
+
+type reportView struct {
+	Title      string
+	Generated  string
+	From       string
+	To         string
+	Changes    []string
+	NoDataSpan int
+	Symbols    []symbolView
+}
+
+type symbolView struct {
+	Symbol     string
+	Anchor     string
+	Currency   string
+	Latest     string
+	LatestDate string
+	Changes    []changeView
+	Missing    []string
+	Chart      *chartView
+}
+
+type changeView struct {
+	Value string
+	Class string
+}
+
+func (w *reportWriter) view() reportView {
+	days := w.calendar()
+
+	v := reportView{
+		Title:     w.o.Title,
+		Generated: Now().UTC().Format("2006-01-02 15:04 MST"),
+	}
+	for _, n := range ChangeDays {
+		v.Changes = append(v.Changes, fmt.Sprintf("%dd", n))
+	}
+	v.NoDataSpan = len(v.Changes) + 2
+	if len(days) > 0 {
+		v.From, v.To = days[0].Format(dateFormat), days[len(days)-1].Format(dateFormat)
+	}
+	for i, symbol := range app.OrderSymbols(w.symbols, w.prices) {
+		s := symbolReport(symbol, w.prices[symbol], days)
+		s.Anchor = fmt.Sprintf("symbol-%d", i+1)
+		v.Symbols = append(v.Symbols, s)
+	}
+	return v
+}
+
+// calendar returns every day from the first to the last day quoted, so that days without any quotes are also reported missing.
+func (w *reportWriter) calendar() []time.Time {
+	quoted := make([]time.Time, 0, len(w.days))
+	for day := range w.days {
+		quoted = append(quoted, day)
+	}
+	sort.Slice(quoted, func(i, j int) bool { return quoted[i].Before(quoted[j]) })
+	var days []time.Time
+	if len(quoted) > 0 {
+		for day := quoted[0]; !day.After(quoted[len(quoted)-1]); day = day.AddDate(0, 0, 1) {
+			days = append(days, day)
+		}
+	}
+	return days
+}
+
+func symbolReport(symbol string, prices map[time.Time]dayPrice, days []time.Time) symbolView {
+	s := symbolView{Symbol: symbol}
+	var points []chartPoint
+	for _, day := range days {
+		p, ok := prices[day]
+		if !ok {
+			s.Missing = append(s.Missing, day.Format(dateFormat))
+		}
+		points = append(points, chartPoint{day: day, price: p.price, ok: ok})
+	}
+	if len(prices) == 0 {
+		return s
+	}
+
+	latestDay := days[0]
+	for _, day := range days {
+		if _, ok := prices[day]; ok {
+			latestDay = day
+		}
+	}
+	latest := prices[latestDay]
+	s.Currency, s.Latest, s.LatestDate = latest.currency, latest.price.String(), latestDay.Format(dateFormat)
+	for _, n := range ChangeDays {
+		s.Changes = append(s.Changes, change(latest.price, priceOnOrBefore(prices, days, latestDay.AddDate(0, 0, -n))))
+	}
+	s.Chart = newChart(symbol, points)
+	return s
+}
+
+// priceOnOrBefore returns the most recent price on or before the day, if any.
+func priceOnOrBefore(prices map[time.Time]dayPrice, days []time.Time, day time.Time) *decimal.Decimal {
+	for i := len(days) - 1; i >= 0; i-- {
+		if days[i].After(day) {
+			continue
+		}
+		if p, ok := prices[days[i]]; ok {
+			return &p.price
+		}
+	}
+	return nil
+}
+
+func change(latest decimal.Decimal, past *decimal.Decimal) changeView {
+	if past == nil || past.IsZero() {
+		return changeView{Value: "–"}
+	}
+	c := latest.Sub(*past).Div(*past).Mul(decimal.NewFromInt(100))
+	v := changeView{Value: c.StringFixed(2) + "%"}
+	switch {
+	case c.IsPositive():
+		v.Class = "up"
+	case c.IsNegative():
+		v.Class = "down"
+	}
+	return v
+}
+
+func truncateDay(t time.Time) time.Time {
+	y, m, d := t.UTC().Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+}
@@ -0,0 +1,92 @@
+package report_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/benjohns1/invest-source/app"
+	"github.com/benjohns1/invest-source/output/destination"
+	"github.com/benjohns1/invest-source/output/report"
+)
+
+func quote(day int, symbol string, usd string) app.Quote {
+	return app.Quote{Time: time.Date(2021, time.June, day, 23, 59, 0, 0, time.UTC), Symbol: symbol, USD: decimal.RequireFromString(usd)}
+}
+
+type namedWriter struct {
+	destination.Writer
+	name *string
+}
+
+func (w namedWriter) Create(filename string) (destination.File, error) {
+	*w.name = filename
+	return w.Writer.Create(filename)
+}
+
+func TestOutput(t *testing.T) {
+	report.Now = func() time.Time { return time.Date(2021, time.June, 24, 8, 0, 0, 0, time.UTC) }
+	defer func() { report.Now = time.Now }()
+
+	var buf bytes.Buffer
+	var name string
+	o, err := report.NewOutput(namedWriter{Writer: destination.Writer{W: &buf}, name: &name}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := o.Begin("2021-05-23_to_2021-06-23.csv", "BTC", "ETH", "USDC")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// days arrive most recent first, as read from the cache
+	for _, day := range [][]app.Quote{
+		{quote(22, "BTC", "33000"), quote(22, "ETH", "1800"), quote(22, "<B&C>", "1")},
+		{quote(21, "BTC", "30000")},
+		{quote(20, "BTC", "31000"), quote(20, "ETH", "2000")},
+		{quote(15, "BTC", "40000"), quote(15, "ETH", "1500")},
+	} {
+		_, err := w.WriteDay(day)
+		assert.NoError(t, err)
+	}
+	assert.NoError(t, w.Close())
+	assert.Equal(t, "2021-05-23_to_2021-06-23.html", name)
+
+	html := buf.String()
+	for _, want := range []string{
+		"<title>Price report</title>",
+		"2021-06-15 to 2021-06-22, generated 2021-06-24 08:00 UTC",
+		// BTC: 1d from 30000, 7d from 40000 on the 15th, no 30d price
+		`<td>33000 USD</td><td>2021-06-22</td><td class="up">+10.00%</td><td class="down">-17.50%</td><td>–</td>`,
+		// ETH: 1d falls back to the 20th since the 21st is missing
+		`<td>1800 USD</td><td>2021-06-22</td><td class="down">-10.00%</td><td class="up">+20.00%</td>`,
+		`<td class="missing" colspan="5">no data</td>`,
+		// days without any quotes are missing for every symbol
+		`<td class="missing">4</td>`,
+		"Missing prices: 2021-06-16, 2021-06-17, 2021-06-18, 2021-06-19</p>",
+		"Missing prices: 2021-06-16, 2021-06-17, 2021-06-18, 2021-06-19, 2021-06-21</p>",
+		"2021-06-21: no price",
+		"2021-06-18: no price",
+		"2021-06-22: 33000",
+		"No prices for USDC.",
+		"&lt;B&amp;C&gt;",
+		"<svg ",
+		"<polyline ",
+	} {
+		assert.Contains(t, html, want)
+	}
+	assert.NotContains(t, html, "<B&C>", "symbols should be escaped")
+	assert.NotContains(t, html, "src=", "report should be self-contained")
+	assert.NotContains(t, html, "http://")
+	assert.Equal(t, 1, strings.Count(html, "<polyline "), "lone prices should only be drawn as points")
+	assert.Contains(t, html, `<polyline class="line" points="525.7,181.6 614.9,200.0 704.0,144.8"/>`, "BTC's line should break at the days without quotes")
+	assert.Contains(t, html, `<a href="#symbol-4">&lt;B&amp;C&gt;</a>`)
+}
+
+func TestNewOutput(t *testing.T) {
+	_, err := report.NewOutput(nil, "")
+	assert.Error(t, err)
+}
@@ -0,0 +1,262 @@
+package xlsx
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/xuri/excelize/v2"
+
+	"github.com/benjohns1/invest-source/app"
+	"github.com/benjohns1/invest-source/output/destination"
+)
+
+// SummarySheet is the name of the workbook's first sheet, summarising every symbol.
+const SummarySheet = "Summary"
+
+// Output writes an Excel workbook with a summary sheet, and a sheet per symbol of its daily prices.
+type Output struct {
+	Destination destination.Destination
+}
+
+// NewOutput creates a workbook output writing to the destination.
+func NewOutput(dest destination.Destination) (Output, error) {
+	o := Output{Destination: dest}
+	if err := o.Validate(); err != nil {
+		return Output{}, err
+	}
+	return o, nil
+}
+
+// Validate returns an error if the output was not correctly instantiated.
+func (o Output) Validate() error {
+	if o.Destination == nil {
+		return fmt.Errorf("output Destination must be set")
+	}
+
+	return nil
+}
+
+// Begin starts collecting prices for a workbook named after the filename, with an .xlsx extension.
+func (o Output) Begin(filename string, symbols ...string) (app.DayWriter, error) {
+	return &workbookWriter{
+		o:        o,
+		filename: strings.TrimSuffix(filename, filepath.Ext(filename)) + ".xlsx",
+		symbols:  symbols,
+		prices:   make(map[string][]dayPrice),
+		missing:  make(map[string]int),
+	}, nil
+}
+
+type dayPrice struct {
+	time     time.Time
+	price    decimal.Decimal
+	currency string
+}
+
+// workbookWriter collects each symbol's prices, since a daily change needs the prices sorted by date, and writes the workbook when closed.
+type workbookWriter struct {
+	o        Output
+	filename string
+	symbols  []string
+	prices   map[string][]dayPrice
+	missing  map[string]int
+}
+
+// WriteDay collects a day's prices, returning the expected symbols missing from them.
+func (w *workbookWriter) WriteDay(quotes []app.Quote) ([]string, error) {
+	quotes, missing := app.FilterDay(quotes, w.symbols, nil)
+	for _, q := range quotes {
+		price, currency := q.Denominated()
+		w.prices[q.Symbol] = append(w.prices[q.Symbol], dayPrice{time: q.Time, price: price, currency: currency})
+	}
+	for _, symbol := range missing {
+		w.missing[symbol]++
+	}
+	return missing, nil
+}
+
+// Close writes the workbook to the destination.
+func (w *workbookWriter) Close() error {
+	f := excelize.NewFile()
+	defer func() { _ = f.Close() }()
+	if err := w.build(f); err != nil {
+		return fmt.Errorf("error building workbook: %v", err)
+	}
+
+	out, err := w.o.Destination.Create(w.filename)
+	if err != nil {
+		return err
+	}
+	if _, err := f.WriteTo(out); err != nil {
+		_ = out.Abort()
+		return fmt.Errorf("error writing workbook: %v", err)
+	}
+	return out.Close()
+}
+
+// Abort discards the collected prices.
+func (w *workbookWriter) Abort() error {
+	w.prices = nil
+	return nil
+}
+
+// summaryHeader columns of the summary sheet.
+var summaryHeader = []interface{}{"Symbol", "Currency", "First Date", "Last Date", "Latest Price", "Change", "Days", "Missing Days"}
+
+// symbolHeader columns of each symbol's sheet.
+var symbolHeader = []interface{}{"Date", "Price", "Daily Change"}
+
+func (w *workbookWriter) build(f *excelize.File) error {
+	dateFormat := "yyyy-mm-dd"
+	dateStyle, err := f.NewStyle(&excelize.Style{CustomNumFmt: &dateFormat})
+	if err != nil {
+		return err
+	}
+	percentStyle, err := f.NewStyle(&excelize.Style{NumFmt: 10}) // 0.00%
+	if err != nil {
+		return err
+	}
+	headerStyle, err := f.NewStyle(&excelize.Style{Font: &excelize.Font{Bold: true}})
+	if err != nil {
+		return err
+	}
+
+	if err := f.SetSheetName(f.GetSheetName(0), SummarySheet); err != nil {
+		return err
+	}
+	if err := setColStyle(f, SummarySheet, dateStyle, "C", "D"); err != nil {
+		return err
+	}
+	if err := setColStyle(f, SummarySheet, percentStyle, "F"); err != nil {
+		return err
+	}
+	if err := writeHeader(f, SummarySheet, summaryHeader, headerStyle); err != nil {
+		return err
+	}
+
+	sheets := make(map[string]struct{})
+	for i, symbol := range app.OrderSymbols(w.symbols, w.prices) {
+		prices := w.prices[symbol]
+		sort.SliceStable(prices, func(i, j int) bool { return prices[i].time.Before(prices[j].time) })
+
+		summary := []interface{}{symbol, nil, nil, nil, nil, nil, len(prices), w.missing[symbol]}
+		if len(prices) > 0 {
+			first, last := prices[0], prices[len(prices)-1]
+			summary[1], summary[2], summary[3], summary[4] = last.currency, first.time, last.time, number(last.price)
+			if change, ok := relativeChange(first.price, last.price); ok {
+				summary[5] = change
+			}
+			if err := writeSymbolSheet(f, uniqueSheetName(symbol, sheets), prices, headerStyle, dateStyle, percentStyle); err != nil {
+				return err
+			}
+		}
+		cell, err := excelize.CoordinatesToCellName(1, i+2)
+		if err != nil {
+			return err
+		}
+		if err := f.SetSheetRow(SummarySheet, cell, &summary); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeSymbolSheet(f *excelize.File, sheet string, prices []dayPrice, headerStyle, dateStyle, percentStyle int) error {
+	if _, err := f.NewSheet(sheet); err != nil {
+		return err
+	}
+	if err := setColStyle(f, sheet, dateStyle, "A"); err != nil {
+		return err
+	}
+	if err := setColStyle(f, sheet, percentStyle, "C"); err != nil {
+		return err
+	}
+	if err := writeHeader(f, sheet, symbolHeader, headerStyle); err != nil {
+		return err
+	}
+	for i, p := range prices {
+		row := []interface{}{p.time, number(p.price)}
+		if i > 0 {
+			if change, ok := relativeChange(prices[i-1].price, p.price); ok {
+				row = append(row, change)
+			}
+		}
+		cell, err := excelize.CoordinatesToCellName(1, i+2)
+		if err != nil {
+			return err
+		}
+		if err := f.SetSheetRow(sheet, cell, &row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeHeader(f *excelize.File, sheet string, header []interface{}, style int) error {
+	if err := f.SetSheetRow(sheet, "A1", &header); err != nil {
+		return err
+	}
+	end, err := excelize.CoordinatesToCellName(len(header), 1)
+	if err != nil {
+		return err
+	}
+	return f.SetCellStyle(sheet, "A1", end, style)
+}
+
+func setColStyle(f *excelize.File, sheet string, style int, cols ...string) error {
+	for _, col := range cols {
+		if err := f.SetColStyle(sheet, col, style); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// number converts a price to a numeric cell value, losing precision beyond a double.
+func number(d decimal.Decimal) float64 {
+	f, _ := d.Float64()
+	return f
+}
+
+// relativeChange returns the change from one price to another as a fraction, false if the change from zero is undefined.
+func relativeChange(from, to decimal.Decimal) (float64, bool) {
+	if from.IsZero() {
+		return 0, false
+	}
+	return number(to.Sub(from).Div(from)), true
+}
+
+// uniqueSheetName returns a valid sheet name for the symbol that isn't in use yet.
+func uniqueSheetName(symbol string, used map[string]struct{}) string {
+	name := strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`[]:*?/\`, r) {
+			return '_'
+		}
+		return r
+	}, symbol)
+	name = strings.Trim(name, "'")
+	if name == "" {
+		name = "_"
+	}
+	if len([]rune(name)) > 31 {
+		name = string([]rune(name)[:31])
+	}
+	candidate := name
+	for i := 2; ; i++ {
+		key := strings.ToLower(candidate)
+		if _, ok := used[key]; !ok && key != strings.ToLower(SummarySheet) {
+			used[key] = struct{}{}
+			return candidate
+		}
+		suffix := fmt.Sprintf(" (%d)", i)
+		runes := []rune(name)
+		if len(runes)+len(suffix) > 31 {
+			runes = runes[:31-len(suffix)]
+		}
+		candidate = string(runes) + suffix
+	}
+}
@@ -0,0 +1,79 @@
+package xlsx_test
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/xuri/excelize/v2"
+
+	"github.com/benjohns1/invest-source/app"
+	"github.com/benjohns1/invest-source/output/destination"
+	"github.com/benjohns1/invest-source/output/xlsx"
+)
+
+func quote(day int, symbol string, usd string) app.Quote {
+	return app.Quote{Time: time.Date(2021, time.June, day, 23, 59, 0, 0, time.UTC), Symbol: symbol, USD: decimal.RequireFromString(usd)}
+}
+
+func TestOutput(t *testing.T) {
+	var buf bytes.Buffer
+	o, err := xlsx.NewOutput(destination.Writer{W: &buf})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := o.Begin("2021-06-20_to_2021-06-23.csv", "ETH", "BTC", "USDC")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// days arrive most recent first, as read from the cache
+	for _, day := range [][]app.Quote{
+		{quote(22, "BTC", "33000"), quote(22, "ETH", "2000"), quote(22, "A/B", "1")},
+		{quote(21, "BTC", "30000")},
+		{quote(20, "BTC", "0"), quote(20, "ETH", "2500")},
+	} {
+		_, err := w.WriteDay(day)
+		assert.NoError(t, err)
+	}
+	assert.NoError(t, w.Close())
+
+	f, err := excelize.OpenReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = f.Close() }()
+	assert.Equal(t, []string{"Summary", "ETH", "BTC", "A_B"}, f.GetSheetList())
+
+	summary, err := f.GetRows("Summary")
+	assert.NoError(t, err)
+	assert.Equal(t, [][]string{
+		{"Symbol", "Currency", "First Date", "Last Date", "Latest Price", "Change", "Days", "Missing Days"},
+		{"ETH", "USD", "2021-06-20", "2021-06-22", "2000", "-20.00%", "2", "1"},
+		{"BTC", "USD", "2021-06-20", "2021-06-22", "33000", "", "3", "0"},
+		{"USDC", "", "", "", "", "", "0", "3"},
+		{"A/B", "USD", "2021-06-22", "2021-06-22", "1", "0.00%", "1", "0"},
+	}, summary)
+
+	btc, err := f.GetRows("BTC")
+	assert.NoError(t, err)
+	assert.Equal(t, [][]string{
+		{"Date", "Price", "Daily Change"},
+		{"2021-06-20", "0"},
+		{"2021-06-21", "30000"},
+		{"2021-06-22", "33000", "10.00%"},
+	}, btc)
+
+	// numeric cells have no type attribute, unlike strings
+	for _, cell := range []string{"B4", "C4"} {
+		typ, err := f.GetCellType("BTC", cell)
+		assert.NoError(t, err)
+		assert.Contains(t, []excelize.CellType{excelize.CellTypeUnset, excelize.CellTypeNumber}, typ, cell)
+	}
+}
+
+func TestNewOutput(t *testing.T) {
+	_, err := xlsx.NewOutput(nil)
+	assert.Error(t, err)
+}
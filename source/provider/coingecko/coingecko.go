@@ -0,0 +1,268 @@
+package coingecko
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/shopspring/decimal"
+
+	"github.com/benjohns1/invest-source/app"
+	"github.com/benjohns1/invest-source/tracing"
+)
+
+// Provider CoinGecko crypto API provider, which doesn't require an API key.
+type Provider struct {
+	URL        string
+	VsCurrency string
+	PerPage    int
+	MaxPages   int
+	// PageDelay to wait between page requests, to stay within the public API's rate limit of a few calls per minute.
+	PageDelay time.Duration
+	// MaxRetries of a page request rate limited with a 429 status, waiting for its Retry-After, or else backing off exponentially from RetryDelay.
+	MaxRetries int
+	RetryDelay time.Duration
+	// MaxRetryAfter caps the wait for a Retry-After, so a long one can't outlast the caller.
+	MaxRetryAfter time.Duration
+	// IDs maps symbols to CoinGecko coin IDs, to pick the right coin when several share a symbol. Unmapped symbols resolve to the coin with the highest market cap.
+	IDs map[string]string
+}
+
+// NewCoinGeckoProvider creates a new provider for the CoinGecko markets API (https://www.coingecko.com/en/api), with optional symbol to coin ID mappings.
+func NewCoinGeckoProvider(ids map[string]string) (Provider, error) {
+	p := Provider{
+		URL:           "https://api.coingecko.com/api/v3/coins/markets",
+		VsCurrency:    "usd",
+		PerPage:       250,
+		MaxPages:      20,
+		PageDelay:     5 * time.Second,
+		MaxRetries:    3,
+		RetryDelay:    15 * time.Second,
+		MaxRetryAfter: 2 * time.Minute,
+		IDs:           make(map[string]string, len(ids)),
+	}
+	for symbol, id := range ids {
+		p.IDs[strings.ToUpper(strings.TrimSpace(symbol))] = strings.TrimSpace(id)
+	}
+	if err := p.Validate(); err != nil {
+		return Provider{}, err
+	}
+	return p, nil
+}
+
+// Validate returns an error if the provider was not correctly instantiated.
+func (p Provider) Validate() error {
+	if p.URL == "" {
+		return fmt.Errorf("provider URL must be set")
+	}
+
+	if p.PerPage <= 0 || p.PerPage > 250 {
+		return fmt.Errorf("provider PerPage must be between 1 and 250, got %d", p.PerPage)
+	}
+
+	if p.MaxPages <= 0 {
+		return fmt.Errorf("provider MaxPages must be greater than 0, got %d", p.MaxPages)
+	}
+
+	if p.PageDelay < 0 {
+		return fmt.Errorf("provider PageDelay cannot be negative, got %s", p.PageDelay)
+	}
+
+	if p.MaxRetries < 0 {
+		return fmt.Errorf("provider MaxRetries cannot be negative, got %d", p.MaxRetries)
+	}
+
+	if p.MaxRetries > 0 && p.RetryDelay <= 0 {
+		return fmt.Errorf("provider RetryDelay must be greater than 0 to retry, got %s", p.RetryDelay)
+	}
+
+	if p.MaxRetries > 0 && p.MaxRetryAfter <= 0 {
+		return fmt.Errorf("provider MaxRetryAfter must be greater than 0 to retry, got %s", p.MaxRetryAfter)
+	}
+
+	return nil
+}
+
+// QueryLatest retrieves the latest market data from the CoinGecko API, paging by market cap until a short page or MaxPages, and combines the pages into a single JSON array.
+// Pages are requested PageDelay apart, and retried while rate limited, returning the context's error if it's done while waiting.
+func (p Provider) QueryLatest(ctx context.Context) ([]byte, error) {
+	markets := make([]json.RawMessage, 0)
+	for page := 1; page <= p.MaxPages; page++ {
+		if page > 1 {
+			if err := wait(ctx, p.PageDelay); err != nil {
+				return nil, err
+			}
+		}
+		data, err := p.queryPageRetrying(ctx, page)
+		if err != nil {
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return nil, ctxErr
+			}
+			return nil, fmt.Errorf("error querying page %d: %v", page, err)
+		}
+		var pageMarkets []json.RawMessage
+		if err := json.Unmarshal(data, &pageMarkets); err != nil {
+			return nil, fmt.Errorf("error unmarshalling page %d into JSON: %v", page, err)
+		}
+		markets = append(markets, pageMarkets...)
+		if len(pageMarkets) < p.PerPage {
+			break
+		}
+	}
+
+	return json.Marshal(markets)
+}
+
+// rateLimitError is returned for a page request rejected with a 429 status, with the wait the API asked for in its Retry-After header, if any.
+type rateLimitError struct {
+	status     string
+	retryAfter time.Duration
+}
+
+func (e rateLimitError) Error() string {
+	return fmt.Sprintf("rate limited with status %s", e.status)
+}
+
+// queryPageRetrying queries a page, waiting and retrying up to MaxRetries times while it's rate limited.
+func (p Provider) queryPageRetrying(ctx context.Context, page int) ([]byte, error) {
+	backoff := p.RetryDelay
+	for retry := 0; ; retry++ {
+		data, err := p.queryPage(ctx, page)
+		var limited rateLimitError
+		if !errors.As(err, &limited) || retry >= p.MaxRetries {
+			return data, err
+		}
+		delay := min(limited.retryAfter, p.MaxRetryAfter)
+		if delay <= 0 {
+			delay = backoff
+			backoff *= 2
+		}
+		if err := wait(ctx, delay); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// wait for the delay, or until the context is done, returning its error.
+func wait(ctx context.Context, delay time.Duration) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-After(delay):
+		return nil
+	}
+}
+
+// retryAfter parses a Retry-After header of either delay seconds or an HTTP date, returning 0 if it's missing or invalid.
+func retryAfter(header string) time.Duration {
+	if header == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(header); err == nil {
+		return time.Duration(seconds) * time.Second
+	}
+	if t, err := http.ParseTime(header); err == nil {
+		return t.Sub(Now())
+	}
+	return 0
+}
+
+func (p Provider) queryPage(ctx context.Context, page int) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", p.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	q := url.Values{}
+	q.Add("vs_currency", p.VsCurrency)
+	q.Add("order", "market_cap_desc")
+	q.Add("per_page", fmt.Sprintf("%d", p.PerPage))
+	q.Add("page", fmt.Sprintf("%d", page))
+
+	req.Header.Set("Accept", "application/json")
+	req.URL.RawQuery = q.Encode()
+
+	resp, err := tracing.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode == http.StatusTooManyRequests {
+		return nil, rateLimitError{status: resp.Status, retryAfter: retryAfter(resp.Header.Get("Retry-After"))}
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s raw response body: %s", resp.Status, respBody)
+	}
+
+	return respBody, nil
+}
+
+type market struct {
+	ID           string       `json:"id"`
+	Symbol       string       `json:"symbol"`
+	CurrentPrice *json.Number `json:"current_price"`
+	LastUpdated  *string      `json:"last_updated"`
+}
+
+// ParseQuotes parses market data into quotes, resolving each symbol to a single coin by its mapped ID or else by highest market cap. Coins without a price are skipped.
+func (p Provider) ParseQuotes(_ context.Context, data []byte, symbols ...string) ([]app.Quote, error) {
+	if data == nil {
+		return nil, fmt.Errorf("data cannot be empty")
+	}
+	var v []market
+	if err := json.Unmarshal(data, &v); err != nil {
+		return nil, fmt.Errorf("error unmarshalling data into JSON: %v", err)
+	}
+	filterSymbols := len(symbols) > 0
+	symbolMap := make(map[string]struct{}, len(symbols))
+	for _, symbol := range symbols {
+		symbolMap[symbol] = struct{}{}
+	}
+	seen := make(map[string]struct{}, len(v))
+	quotes := make([]app.Quote, 0)
+	for _, m := range v {
+		symbol := strings.ToUpper(m.Symbol)
+		if id, ok := p.IDs[symbol]; ok && id != m.ID {
+			continue
+		}
+		if _, ok := seen[symbol]; ok {
+			continue
+		}
+		if filterSymbols {
+			if _, ok := symbolMap[symbol]; !ok {
+				continue
+			}
+		}
+		if m.CurrentPrice == nil || m.LastUpdated == nil {
+			continue
+		}
+		price, err := decimal.NewFromString(m.CurrentPrice.String())
+		if err != nil {
+			return nil, fmt.Errorf("error parsing price for %s (%s): %v", symbol, m.ID, err)
+		}
+		t, err := time.Parse(time.RFC3339Nano, *m.LastUpdated)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing updated time for %s (%s): %v", symbol, m.ID, err)
+		}
+		seen[symbol] = struct{}{}
+		quotes = append(quotes, app.Quote{
+			Time:   t,
+			Symbol: symbol,
+			USD:    price,
+		})
+	}
+	return quotes, nil
+}
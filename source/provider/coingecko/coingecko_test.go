@@ -0,0 +1,320 @@
+package coingecko_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/benjohns1/invest-source/app"
+	"github.com/benjohns1/invest-source/provider/coingecko"
+)
+
+// recordedMarkets serves the recorded markets pages from testdata, counting the pages requested.
+func recordedMarkets(requested *int) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if q.Get("vs_currency") != "usd" || q.Get("order") != "market_cap_desc" || q.Get("per_page") != "2" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		*requested++
+		data, err := ioutil.ReadFile(fmt.Sprintf("testdata/markets-page-%s.json", q.Get("page")))
+		if err != nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write(data)
+	}))
+}
+
+// recordSleeps stubs After for the test, recording each wait without waiting.
+func recordSleeps(t *testing.T) *[]time.Duration {
+	orig := coingecko.After
+	t.Cleanup(func() { coingecko.After = orig })
+	var sleeps []time.Duration
+	coingecko.After = func(d time.Duration) <-chan time.Time {
+		sleeps = append(sleeps, d)
+		c := make(chan time.Time, 1)
+		c <- time.Time{}
+		return c
+	}
+	return &sleeps
+}
+
+func TestProvider_QueryLatest(t *testing.T) {
+	tests := []struct {
+		name          string
+		maxPages      int
+		wantIDs       []string
+		wantRequested int
+		wantSleeps    []time.Duration
+	}{
+		{
+			name:          "should combine pages until a short page, waiting between pages",
+			maxPages:      20,
+			wantIDs:       []string{"bitcoin", "ethereum", "usd-coin", "bitcoin-plus", "delisted-coin"},
+			wantRequested: 3,
+			wantSleeps:    []time.Duration{5 * time.Second, 5 * time.Second},
+		},
+		{
+			name:          "should stop paging at max pages",
+			maxPages:      1,
+			wantIDs:       []string{"bitcoin", "ethereum"},
+			wantRequested: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sleeps := recordSleeps(t)
+			requested := 0
+			srv := recordedMarkets(&requested)
+			defer srv.Close()
+
+			p, err := coingecko.NewCoinGeckoProvider(nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			p.URL, p.PerPage, p.MaxPages = srv.URL, 2, tt.maxPages
+
+			got, err := p.QueryLatest(context.Background())
+			assert.NoError(t, err)
+			var markets []struct {
+				ID string `json:"id"`
+			}
+			assert.NoError(t, json.Unmarshal(got, &markets))
+			ids := make([]string, 0, len(markets))
+			for _, m := range markets {
+				ids = append(ids, m.ID)
+			}
+			assert.Equal(t, tt.wantIDs, ids)
+			assert.Equal(t, tt.wantRequested, requested)
+			assert.Equal(t, tt.wantSleeps, *sleeps)
+		})
+	}
+}
+
+func TestProvider_QueryLatest_RateLimited(t *testing.T) {
+	coingecko.Now = func() time.Time { return time.Date(2021, time.June, 21, 12, 0, 0, 0, time.UTC) }
+	tests := []struct {
+		name       string
+		retryAfter []string
+		wantErr    bool
+		wantSleeps []time.Duration
+	}{
+		{
+			name:       "should wait for Retry-After seconds and retry",
+			retryAfter: []string{"30"},
+			wantSleeps: []time.Duration{30 * time.Second},
+		},
+		{
+			name:       "should wait until a Retry-After date and retry",
+			retryAfter: []string{"Mon, 21 Jun 2021 12:01:00 GMT"},
+			wantSleeps: []time.Duration{time.Minute},
+		},
+		{
+			name:       "should wait at most MaxRetryAfter for a long Retry-After",
+			retryAfter: []string{"3600"},
+			wantSleeps: []time.Duration{2 * time.Minute},
+		},
+		{
+			name:       "should back off exponentially without Retry-After",
+			retryAfter: []string{"", "", ""},
+			wantSleeps: []time.Duration{15 * time.Second, 30 * time.Second, 60 * time.Second},
+		},
+		{
+			name:       "should fail once out of retries",
+			retryAfter: []string{"", "", "", ""},
+			wantErr:    true,
+			wantSleeps: []time.Duration{15 * time.Second, 30 * time.Second, 60 * time.Second},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sleeps := recordSleeps(t)
+			limited := 0
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if limited < len(tt.retryAfter) {
+					if tt.retryAfter[limited] != "" {
+						w.Header().Set("Retry-After", tt.retryAfter[limited])
+					}
+					limited++
+					w.WriteHeader(http.StatusTooManyRequests)
+					return
+				}
+				_, _ = w.Write([]byte(`[{"id": "bitcoin"}]`))
+			}))
+			defer srv.Close()
+
+			p, err := coingecko.NewCoinGeckoProvider(nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			p.URL = srv.URL
+
+			got, err := p.QueryLatest(context.Background())
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+				assert.JSONEq(t, `[{"id": "bitcoin"}]`, string(got))
+			}
+			assert.Equal(t, tt.wantSleeps, *sleeps)
+		})
+	}
+}
+
+func TestProvider_QueryLatest_Cancelled(t *testing.T) {
+	orig := coingecko.After
+	t.Cleanup(func() { coingecko.After = orig })
+	var cancel context.CancelFunc
+	// cancel instead of ever finishing a wait
+	coingecko.After = func(time.Duration) <-chan time.Time {
+		cancel()
+		return nil
+	}
+	tests := []struct {
+		name   string
+		status int
+	}{
+		{name: "should stop waiting to retry once the context is cancelled", status: http.StatusTooManyRequests},
+		{name: "should stop waiting between pages once the context is cancelled", status: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var ctx context.Context
+			ctx, cancel = context.WithCancel(context.Background())
+			defer cancel()
+			requested := 0
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requested++
+				w.WriteHeader(tt.status)
+				_, _ = w.Write([]byte(`[{"id": "bitcoin"}]`))
+			}))
+			defer srv.Close()
+
+			p, err := coingecko.NewCoinGeckoProvider(nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			p.URL, p.PerPage = srv.URL, 1
+
+			_, err = p.QueryLatest(ctx)
+			assert.ErrorIs(t, err, context.Canceled)
+			assert.Equal(t, 1, requested)
+		})
+	}
+}
+
+func TestProvider_QueryLatest_Error(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer srv.Close()
+
+	p, err := coingecko.NewCoinGeckoProvider(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.URL, p.MaxRetries = srv.URL, 0
+	_, err = p.QueryLatest(context.Background())
+	assert.Error(t, err)
+}
+
+func TestProvider_ParseQuotes(t *testing.T) {
+	recordSleeps(t)
+	requested := 0
+	srv := recordedMarkets(&requested)
+	defer srv.Close()
+	recorder, err := coingecko.NewCoinGeckoProvider(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recorder.URL, recorder.PerPage = srv.URL, 2
+	recorded, err := recorder.QueryLatest(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	btc := app.Quote{Time: time.Date(2021, time.June, 21, 0, 1, 23, 456000000, time.UTC), Symbol: "BTC", USD: decimal.NewFromInt(35641)}
+	eth := app.Quote{Time: time.Date(2021, time.June, 21, 0, 1, 8, 901000000, time.UTC), Symbol: "ETH", USD: decimal.RequireFromString("2249.87")}
+	usdc := app.Quote{Time: time.Date(2021, time.June, 21, 0, 0, 41, 123000000, time.UTC), Symbol: "USDC", USD: decimal.RequireFromString("1.001")}
+	btcPlus := app.Quote{Time: time.Date(2021, time.June, 20, 23, 58, 2, 0, time.UTC), Symbol: "BTC", USD: decimal.RequireFromString("21.37")}
+
+	type args struct {
+		data    []byte
+		symbols []string
+	}
+	tests := []struct {
+		name    string
+		ids     map[string]string
+		args    args
+		want    []app.Quote
+		wantErr bool
+	}{
+		{
+			name:    "should fail with invalid json",
+			args:    args{data: []byte("invalid-json")},
+			wantErr: true,
+		},
+		{
+			name:    "should fail with nil data",
+			args:    args{data: nil},
+			wantErr: true,
+		},
+		{
+			name: "should return an empty array, given no data",
+			args: args{data: []byte("[]")},
+			want: []app.Quote{},
+		},
+		{
+			name: "should resolve shared symbols to the highest market cap and skip coins without a price",
+			args: args{data: recorded},
+			want: []app.Quote{btc, eth, usdc},
+		},
+		{
+			name: "should resolve shared symbols by mapped coin ID",
+			ids:  map[string]string{"btc": "bitcoin-plus"},
+			args: args{data: recorded},
+			want: []app.Quote{eth, usdc, btcPlus},
+		},
+		{
+			name: "should only return the given symbols",
+			args: args{data: recorded, symbols: []string{"USDC", "BTC"}},
+			want: []app.Quote{btc, usdc},
+		},
+		{
+			name:    "should fail if a coin's updated time cannot be parsed",
+			args:    args{data: []byte(`[{"id": "bitcoin", "symbol": "btc", "current_price": 1, "last_updated": "invalid"}]`)},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := coingecko.NewCoinGeckoProvider(tt.ids)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := p.ParseQuotes(context.Background(), tt.args.data, tt.args.symbols...)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			if assert.Len(t, got, len(tt.want)) {
+				for i, want := range tt.want {
+					assert.Equal(t, want.Symbol, got[i].Symbol)
+					assert.True(t, want.Time.Equal(got[i].Time), "%s: want %s, got %s", want.Symbol, want.Time, got[i].Time)
+					assert.True(t, want.USD.Equal(got[i].USD), "%s: want %s, got %s", want.Symbol, want.USD, got[i].USD)
+				}
+			}
+		})
+	}
+}
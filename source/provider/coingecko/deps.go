@@ -0,0 +1,13 @@
+package coingecko
+
+import (
+	"time"
+)
+
+var (
+	// Now function for retrieving the current timestamp. Override this for unit tests.
+	Now = time.Now
+
+	// After waits between requests. Override this for unit tests.
+	After = time.After
+)
@@ -1,31 +1,70 @@
 package coinmarketcap
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"net/url"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/shopspring/decimal"
 
 	"github.com/benjohns1/invest-source/app"
+	"github.com/benjohns1/invest-source/provider/envelope"
+	"github.com/benjohns1/invest-source/tracing"
+)
+
+// Query modes, trading off API credit usage against coverage.
+const (
+	// ModeListings pages through all listings ranked by market cap, up to MaxPages of Limit listings each.
+	ModeListings = "listings"
+	// ModeQuotes queries only the coins in IDs, in a single call.
+	ModeQuotes = "quotes"
 )
 
 // Provider CoinMarketCap crypto API provider.
 type Provider struct {
-	ApiKey  string
-	Limit   int
-	Convert string
+	ApiKey   string
+	URL      string
+	Limit    int
+	Convert  string
+	Mode     string
+	MaxPages int
+	// IDs maps symbols to CoinMarketCap IDs, to query in ModeQuotes.
+	IDs map[string]string
+	// MonthlyCreditBudget is the number of API credits QueryLatest may use per calendar month, 0 for no budget.
+	MonthlyCreditBudget int
+	// Ledger records the credits used by every query, whether it succeeds or fails, and totals them to check the budget, required with a MonthlyCreditBudget.
+	Ledger Ledger
 }
 
+// Ledger records the API credits used per calendar month, e.g. a keyval.Ledger.
+type Ledger interface {
+	// Total returns the credits used in the calendar month of the given time.
+	Total(ctx context.Context, month time.Time) (int, error)
+	// Add records the credits used at the given time.
+	Add(ctx context.Context, at time.Time, credits int) error
+}
+
+// ErrBudgetExceeded is returned by QueryLatest instead of querying, if the query could exceed the monthly credit budget.
+var ErrBudgetExceeded = errors.New("monthly API credit budget would be exceeded")
+
 // NewCoinMarketCapProvider creates a new provider for the Coin Market Cap API (https://coinmarketcap.com/).
 func NewCoinMarketCapProvider(apiKey string) (Provider, error) {
 	p := Provider{
-		ApiKey:  apiKey,
-		Limit:   5000,
-		Convert: "USD",
+		ApiKey:   apiKey,
+		URL:      "https://pro-api.coinmarketcap.com",
+		Limit:    5000,
+		Convert:  "USD",
+		Mode:     ModeListings,
+		MaxPages: 1,
 	}
 	if err := p.Validate(); err != nil {
 		return Provider{}, err
@@ -39,48 +78,191 @@ func (p Provider) Validate() error {
 		return fmt.Errorf("provider ApiKey must be set")
 	}
 
-	if p.Limit <= 0 {
-		return fmt.Errorf("provider Limit must be greater than 0, got %d", p.Limit)
+	if p.URL == "" {
+		return fmt.Errorf("provider URL must be set")
+	}
+
+	switch p.Mode {
+	case ModeListings:
+		if p.Limit <= 0 || p.Limit > 5000 {
+			return fmt.Errorf("provider Limit must be between 1 and 5000, got %d", p.Limit)
+		}
+		if p.MaxPages <= 0 {
+			return fmt.Errorf("provider MaxPages must be greater than 0, got %d", p.MaxPages)
+		}
+	case ModeQuotes:
+		if len(p.IDs) == 0 {
+			return fmt.Errorf("provider IDs must be set in %s mode", ModeQuotes)
+		}
+	default:
+		return fmt.Errorf("invalid provider Mode '%s', must be one of %s or %s", p.Mode, ModeListings, ModeQuotes)
+	}
+
+	if p.MonthlyCreditBudget < 0 {
+		return fmt.Errorf("provider MonthlyCreditBudget must not be negative, got %d", p.MonthlyCreditBudget)
+	}
+	if p.MonthlyCreditBudget > 0 && p.Ledger == nil {
+		return fmt.Errorf("provider Ledger must be set with a MonthlyCreditBudget")
 	}
 
 	return nil
 }
 
-// QueryLatest retrieves the latest currency listing data from the CoinMarketCap API.
-func (p Provider) QueryLatest() ([]byte, error) {
-	client := &http.Client{}
-	req, err := http.NewRequest("GET", "https://pro-api.coinmarketcap.com/v1/cryptocurrency/listings/latest", nil)
+// QueryLatest retrieves the latest currency data from the CoinMarketCap API, either paging through listings or querying quotes for the configured IDs.
+// The credits used are added to the Ledger, including those used by failed calls, and recorded in the data's envelope metadata.
+// Returns ErrBudgetExceeded without querying if the query could exceed the monthly credit budget.
+func (p Provider) QueryLatest(ctx context.Context) ([]byte, error) {
+	if err := p.checkBudget(ctx); err != nil {
+		return nil, err
+	}
+
+	var data []byte
+	var credits int
+	var err error
+	if p.Mode == ModeQuotes {
+		data, credits, err = p.queryQuotes(ctx)
+	} else {
+		data, credits, err = p.queryListings(ctx)
+	}
+	if ledgerErr := p.recordCredits(ctx, credits); ledgerErr != nil {
+		return nil, errors.Join(err, ledgerErr)
+	}
 	if err != nil {
 		return nil, err
 	}
 
+	return envelope.Wrap(data, map[string]string{envelope.MetaCredits: strconv.Itoa(credits)})
+}
+
+// EstimateCredits returns the most credits a single QueryLatest call can use: 1 credit per 200 listings, or per 100 quotes.
+func (p Provider) EstimateCredits() int {
+	if p.Mode == ModeQuotes {
+		return ceilDiv(len(p.IDs), 100)
+	}
+	return p.MaxPages * ceilDiv(p.Limit, 200)
+}
+
+func ceilDiv(n, d int) int {
+	return (n + d - 1) / d
+}
+
+func (p Provider) checkBudget(ctx context.Context) error {
+	if p.MonthlyCreditBudget == 0 {
+		return nil
+	}
+	used, err := p.Ledger.Total(ctx, Now())
+	if err != nil {
+		return fmt.Errorf("error reading credits used this month: %v", err)
+	}
+	if estimate := p.EstimateCredits(); used+estimate > p.MonthlyCreditBudget {
+		return fmt.Errorf("%w: %d of %d credits used this month, and the query could use up to %d", ErrBudgetExceeded, used, p.MonthlyCreditBudget, estimate)
+	}
+	return nil
+}
+
+// recordCredits adds the credits used to the Ledger, if it's set and any were used.
+func (p Provider) recordCredits(ctx context.Context, credits int) error {
+	if p.Ledger == nil || credits == 0 {
+		return nil
+	}
+	if err := p.Ledger.Add(ctx, Now(), credits); err != nil {
+		return fmt.Errorf("error recording %d credits used: %v", credits, err)
+	}
+	return nil
+}
+
+// queryListings pages through listings with start offsets until a short page or MaxPages, and combines the pages into a single listings response.
+func (p Provider) queryListings(ctx context.Context) ([]byte, int, error) {
+	combined := struct {
+		Data   []json.RawMessage `json:"data"`
+		Status json.RawMessage   `json:"status,omitempty"`
+	}{Data: make([]json.RawMessage, 0)}
+	credits := 0
+	for page := 0; page < p.MaxPages; page++ {
+		q := url.Values{}
+		q.Add("start", fmt.Sprintf("%d", page*p.Limit+1))
+		q.Add("limit", fmt.Sprintf("%d", p.Limit))
+		q.Add("convert", p.Convert)
+
+		respBody, pageCredits, err := p.get(ctx, "/v1/cryptocurrency/listings/latest", q)
+		credits += pageCredits
+		if err != nil {
+			return nil, credits, err
+		}
+		if p.MaxPages == 1 {
+			return respBody, credits, nil
+		}
+
+		var v struct {
+			Data   []json.RawMessage `json:"data"`
+			Status json.RawMessage   `json:"status"`
+		}
+		if err := json.Unmarshal(respBody, &v); err != nil {
+			return nil, credits, fmt.Errorf("error unmarshalling listings page %d into JSON: %v", page+1, err)
+		}
+		combined.Data = append(combined.Data, v.Data...)
+		combined.Status = v.Status
+		if len(v.Data) < p.Limit {
+			break
+		}
+	}
+	data, err := json.Marshal(combined)
+	return data, credits, err
+}
+
+// queryQuotes queries the latest quotes for the configured IDs only.
+func (p Provider) queryQuotes(ctx context.Context) ([]byte, int, error) {
+	ids := make([]string, 0, len(p.IDs))
+	for _, id := range p.IDs {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
 	q := url.Values{}
-	q.Add("limit", fmt.Sprintf("%d", p.Limit))
+	q.Add("id", strings.Join(ids, ","))
 	q.Add("convert", p.Convert)
 
+	return p.get(ctx, "/v2/cryptocurrency/quotes/latest", q)
+}
+
+// get returns the response body, and the credits used as reported in its status.
+func (p Provider) get(ctx context.Context, path string, q url.Values) ([]byte, int, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", strings.TrimSuffix(p.URL, "/")+path, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+
 	req.Header.Set("Accepts", "application/json")
 	req.Header.Add("X-CMC_PRO_API_KEY", p.ApiKey)
 	req.URL.RawQuery = q.Encode()
 
-	resp, err := client.Do(req)
+	resp, err := tracing.HTTPClient.Do(req)
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
+	defer func() { _ = resp.Body.Close() }()
 
 	respBody, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 
+	var status struct {
+		Status struct {
+			CreditCount int `json:"credit_count"`
+		} `json:"status"`
+	}
+	_ = json.Unmarshal(respBody, &status)
+
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("unexpected status %s raw response body: %s", resp.Status, respBody)
+		return nil, status.Status.CreditCount, fmt.Errorf("unexpected status %s raw response body: %s", resp.Status, respBody)
 	}
 
-	return respBody, nil
+	return respBody, status.Status.CreditCount, nil
 }
 
 type entry struct {
-	Data []security `json:"data"`
+	Data json.RawMessage `json:"data"`
 }
 
 type security struct {
@@ -93,22 +275,27 @@ type security struct {
 	} `json:"quote"`
 }
 
-// ParseLatestQuotes
-func (p Provider) ParseQuotes(data []byte, symbols ...string) ([]app.Quote, error) {
+// ParseQuotes parses listings data, or quotes data keyed by ID sorted by symbol, unwrapping it from its envelope if needed.
+func (p Provider) ParseQuotes(_ context.Context, data []byte, symbols ...string) ([]app.Quote, error) {
 	if data == nil {
 		return nil, fmt.Errorf("data cannot be empty")
 	}
+	data, _, _ = envelope.Unwrap(data)
 	v := entry{}
 	if err := json.Unmarshal(data, &v); err != nil {
 		return nil, fmt.Errorf("error unmarshalling data into JSON: %v", err)
 	}
+	securities, err := parseSecurities(v.Data)
+	if err != nil {
+		return nil, err
+	}
 	filterSymbols := len(symbols) > 0
 	symbolMap := make(map[string]struct{}, len(symbols))
 	for _, symbol := range symbols {
 		symbolMap[symbol] = struct{}{}
 	}
 	quotes := make([]app.Quote, 0)
-	for _, datum := range v.Data {
+	for _, datum := range securities {
 		if filterSymbols {
 			if _, ok := symbolMap[datum.Symbol]; !ok {
 				continue
@@ -130,3 +317,29 @@ func (p Provider) ParseQuotes(data []byte, symbols ...string) ([]app.Quote, erro
 	}
 	return quotes, nil
 }
+
+// parseSecurities parses the listings array, or the quotes object keyed by ID.
+func parseSecurities(data json.RawMessage) ([]security, error) {
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) == 0 || bytes.Equal(trimmed, []byte("null")) {
+		return nil, nil
+	}
+	if trimmed[0] != '{' {
+		var securities []security
+		if err := json.Unmarshal(trimmed, &securities); err != nil {
+			return nil, fmt.Errorf("error unmarshalling listings data into JSON: %v", err)
+		}
+		return securities, nil
+	}
+
+	var byID map[string]security
+	if err := json.Unmarshal(trimmed, &byID); err != nil {
+		return nil, fmt.Errorf("error unmarshalling quotes data into JSON: %v", err)
+	}
+	securities := make([]security, 0, len(byID))
+	for _, s := range byID {
+		securities = append(securities, s)
+	}
+	sort.Slice(securities, func(i, j int) bool { return securities[i].Symbol < securities[j].Symbol })
+	return securities, nil
+}
@@ -1,6 +1,11 @@
 package coinmarketcap_test
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"testing"
 	"time"
 
@@ -9,6 +14,7 @@ import (
 
 	"github.com/benjohns1/invest-source/app"
 	"github.com/benjohns1/invest-source/provider/coinmarketcap"
+	"github.com/benjohns1/invest-source/provider/envelope"
 )
 
 func TestProvider_ParseQuotes(t *testing.T) {
@@ -140,7 +146,7 @@ func TestProvider_ParseQuotes(t *testing.T) {
 				}
 				tt.provider = &p
 			}
-			got, err := tt.provider.ParseQuotes(tt.args.data, tt.args.symbols...)
+			got, err := tt.provider.ParseQuotes(context.Background(), tt.args.data, tt.args.symbols...)
 			if tt.wantErr {
 				assert.Error(t, err)
 			} else {
@@ -150,3 +156,371 @@ func TestProvider_ParseQuotes(t *testing.T) {
 		})
 	}
 }
+
+func TestProvider_ParseQuotes_QuotesMode(t *testing.T) {
+	p, err := coinmarketcap.NewCoinMarketCapProvider("dummy-api-key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := p.ParseQuotes(context.Background(), []byte(`{
+	"data": {
+		"1027": {"id": 1027, "symbol": "ETH", "quote": {"USD": {"price": 2250.5, "last_updated": "2021-06-21T00:00:00.000Z"}}},
+		"1": {"id": 1, "symbol": "BTC", "quote": {"USD": {"price": 35000, "last_updated": "2021-06-21T00:00:00.000Z"}}}
+	}
+}`))
+	assert.NoError(t, err)
+	date := time.Date(2021, time.June, 21, 0, 0, 0, 0, time.UTC)
+	assert.Equal(t, []app.Quote{
+		{Time: date, Symbol: "BTC", USD: decimal.NewFromInt(35000)},
+		{Time: date, Symbol: "ETH", USD: decimal.RequireFromString("2250.5")},
+	}, got)
+}
+
+func TestProvider_ParseQuotes_Envelope(t *testing.T) {
+	p, err := coinmarketcap.NewCoinMarketCapProvider("dummy-api-key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := envelope.Wrap([]byte(`{"data": [{"symbol": "BTC", "quote": {"USD": {"price": 35000, "last_updated": "2021-06-21T00:00:00.000Z"}}}]}`), map[string]string{envelope.MetaCredits: "1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := p.ParseQuotes(context.Background(), data)
+	assert.NoError(t, err)
+	assert.Equal(t, []app.Quote{{Time: time.Date(2021, time.June, 21, 0, 0, 0, 0, time.UTC), Symbol: "BTC", USD: decimal.NewFromInt(35000)}}, got)
+}
+
+func TestProvider_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		modify  func(p *coinmarketcap.Provider)
+		wantErr bool
+	}{
+		{
+			name:   "should succeed with default listings mode",
+			modify: func(p *coinmarketcap.Provider) {},
+		},
+		{
+			name:    "should fail with a limit above the API maximum",
+			modify:  func(p *coinmarketcap.Provider) { p.Limit = 5001 },
+			wantErr: true,
+		},
+		{
+			name:    "should fail without max pages",
+			modify:  func(p *coinmarketcap.Provider) { p.MaxPages = 0 },
+			wantErr: true,
+		},
+		{
+			name:    "should fail in quotes mode without IDs",
+			modify:  func(p *coinmarketcap.Provider) { p.Mode = coinmarketcap.ModeQuotes },
+			wantErr: true,
+		},
+		{
+			name: "should succeed in quotes mode with IDs",
+			modify: func(p *coinmarketcap.Provider) {
+				p.Mode, p.IDs = coinmarketcap.ModeQuotes, map[string]string{"BTC": "1"}
+			},
+		},
+		{
+			name:    "should fail with a budget without a ledger",
+			modify:  func(p *coinmarketcap.Provider) { p.MonthlyCreditBudget = 100 },
+			wantErr: true,
+		},
+		{
+			name:    "should fail with an unknown mode",
+			modify:  func(p *coinmarketcap.Provider) { p.Mode = "everything" },
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := coinmarketcap.NewCoinMarketCapProvider("dummy-api-key")
+			if err != nil {
+				t.Fatal(err)
+			}
+			tt.modify(&p)
+			if tt.wantErr {
+				assert.Error(t, p.Validate())
+			} else {
+				assert.NoError(t, p.Validate())
+			}
+		})
+	}
+}
+
+func TestProvider_QueryLatest(t *testing.T) {
+	var requests []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-CMC_PRO_API_KEY") != "dummy-api-key" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		q := r.URL.Query()
+		requests = append(requests, r.URL.Path+"?"+r.URL.RawQuery)
+		switch {
+		case r.URL.Path == "/v1/cryptocurrency/listings/latest" && q.Get("start") == "1":
+			_, _ = w.Write([]byte(`{"status": {"credit_count": 1}, "data": [{"symbol": "BTC"}, {"symbol": "ETH"}]}`))
+		case r.URL.Path == "/v1/cryptocurrency/listings/latest" && q.Get("start") == "3":
+			_, _ = w.Write([]byte(`{"status": {"credit_count": 1}, "data": [{"symbol": "USDC"}]}`))
+		case r.URL.Path == "/v2/cryptocurrency/quotes/latest":
+			_, _ = w.Write([]byte(`{"status": {"credit_count": 1}, "data": {"1": {"symbol": "BTC"}}}`))
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		name         string
+		modify       func(p *coinmarketcap.Provider)
+		want         string
+		wantCredits  string
+		wantRequests []string
+		wantErr      bool
+	}{
+		{
+			name:         "should return a single listings page as is",
+			modify:       func(p *coinmarketcap.Provider) { p.Limit = 2 },
+			want:         `{"status": {"credit_count": 1}, "data": [{"symbol": "BTC"}, {"symbol": "ETH"}]}`,
+			wantCredits:  "1",
+			wantRequests: []string{"/v1/cryptocurrency/listings/latest?convert=USD&limit=2&start=1"},
+		},
+		{
+			name:        "should combine listings pages until a short page",
+			modify:      func(p *coinmarketcap.Provider) { p.Limit, p.MaxPages = 2, 10 },
+			want:        `{"data":[{"symbol":"BTC"},{"symbol":"ETH"},{"symbol":"USDC"}],"status":{"credit_count":1}}`,
+			wantCredits: "2",
+			wantRequests: []string{
+				"/v1/cryptocurrency/listings/latest?convert=USD&limit=2&start=1",
+				"/v1/cryptocurrency/listings/latest?convert=USD&limit=2&start=3",
+			},
+		},
+		{
+			name: "should query quotes for the configured IDs only",
+			modify: func(p *coinmarketcap.Provider) {
+				p.Mode, p.IDs = coinmarketcap.ModeQuotes, map[string]string{"BTC": "1", "ETH": "1027"}
+			},
+			want:         `{"status": {"credit_count": 1}, "data": {"1": {"symbol": "BTC"}}}`,
+			wantCredits:  "1",
+			wantRequests: []string{"/v2/cryptocurrency/quotes/latest?convert=USD&id=1%2C1027"},
+		},
+		{
+			name:    "should fail on an unexpected status",
+			modify:  func(p *coinmarketcap.Provider) { p.ApiKey = "wrong-api-key" },
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			requests = nil
+			p, err := coinmarketcap.NewCoinMarketCapProvider("dummy-api-key")
+			if err != nil {
+				t.Fatal(err)
+			}
+			p.URL = srv.URL
+			tt.modify(&p)
+			got, err := p.QueryLatest(context.Background())
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			payload, meta, ok := envelope.Unwrap(got)
+			assert.True(t, ok)
+			assert.JSONEq(t, tt.want, string(payload))
+			assert.Equal(t, tt.wantCredits, meta[envelope.MetaCredits])
+			assert.Equal(t, tt.wantRequests, requests)
+		})
+	}
+}
+
+// stubLedger records the credits added to it, on top of a fixed total used.
+type stubLedger struct {
+	used     int
+	err      error
+	addErr   error
+	months   []time.Time
+	added    []int
+	addedAts []time.Time
+}
+
+func (l *stubLedger) Total(_ context.Context, month time.Time) (int, error) {
+	l.months = append(l.months, month)
+	return l.used, l.err
+}
+
+func (l *stubLedger) Add(_ context.Context, at time.Time, credits int) error {
+	l.added, l.addedAts = append(l.added, credits), append(l.addedAts, at)
+	return l.addErr
+}
+
+func TestProvider_QueryLatest_Budget(t *testing.T) {
+	coinmarketcap.Now = func() time.Time {
+		return time.Date(2021, time.June, 21, 12, 0, 0, 0, time.UTC)
+	}
+	queried := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		queried++
+		_, _ = w.Write([]byte(`{"status": {"credit_count": 25}, "data": []}`))
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		name        string
+		budget      int
+		used        int
+		usedErr     error
+		wantQueried bool
+		wantErr     bool
+	}{
+		{
+			name:        "should query without a budget",
+			budget:      0,
+			used:        1000,
+			wantQueried: true,
+		},
+		{
+			name:        "should query within the budget",
+			budget:      100,
+			used:        75,
+			wantQueried: true,
+		},
+		{
+			name:    "should refuse to query if the budget would be exceeded",
+			budget:  100,
+			used:    76,
+			wantErr: true,
+		},
+		{
+			name:    "should fail if credits used can't be read",
+			budget:  100,
+			usedErr: fmt.Errorf("cache unavailable"),
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			queried = 0
+			p, err := coinmarketcap.NewCoinMarketCapProvider("dummy-api-key")
+			if err != nil {
+				t.Fatal(err)
+			}
+			ledger := &stubLedger{used: tt.used, err: tt.usedErr}
+			p.URL = srv.URL
+			p.MonthlyCreditBudget = tt.budget
+			p.Ledger = ledger
+			assert.NoError(t, p.Validate())
+			assert.Equal(t, 25, p.EstimateCredits())
+
+			_, err = p.QueryLatest(context.Background())
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+			if tt.usedErr == nil && tt.wantErr {
+				assert.True(t, errors.Is(err, coinmarketcap.ErrBudgetExceeded))
+			}
+			if tt.budget > 0 {
+				assert.Equal(t, []time.Time{coinmarketcap.Now()}, ledger.months)
+			}
+			assert.Equal(t, tt.wantQueried, queried > 0)
+			if tt.wantQueried {
+				assert.Equal(t, []int{25}, ledger.added)
+			} else {
+				assert.Empty(t, ledger.added)
+			}
+		})
+	}
+}
+
+func TestProvider_QueryLatest_Ledger(t *testing.T) {
+	coinmarketcap.Now = func() time.Time {
+		return time.Date(2021, time.June, 21, 12, 0, 0, 0, time.UTC)
+	}
+	tests := []struct {
+		name      string
+		handler   func(page int, w http.ResponseWriter)
+		maxPages  int
+		addErr    error
+		wantAdded []int
+		wantErr   bool
+	}{
+		{
+			name: "should record the credits used by a successful query",
+			handler: func(_ int, w http.ResponseWriter) {
+				_, _ = w.Write([]byte(`{"status": {"credit_count": 25}, "data": []}`))
+			},
+			maxPages:  1,
+			wantAdded: []int{25},
+		},
+		{
+			name: "should record the credits used by a failed query",
+			handler: func(_ int, w http.ResponseWriter) {
+				w.WriteHeader(http.StatusBadRequest)
+				_, _ = w.Write([]byte(`{"status": {"credit_count": 1, "error_message": "invalid value for convert"}}`))
+			},
+			maxPages:  1,
+			wantAdded: []int{1},
+			wantErr:   true,
+		},
+		{
+			name: "should record the credits used by pages before a failed page",
+			handler: func(page int, w http.ResponseWriter) {
+				if page > 1 {
+					w.WriteHeader(http.StatusInternalServerError)
+					return
+				}
+				_, _ = w.Write([]byte(`{"status": {"credit_count": 1}, "data": [{"symbol": "BTC"}, {"symbol": "ETH"}]}`))
+			},
+			maxPages:  2,
+			wantAdded: []int{1},
+			wantErr:   true,
+		},
+		{
+			name: "should record nothing if no credits were used",
+			handler: func(_ int, w http.ResponseWriter) {
+				w.WriteHeader(http.StatusUnauthorized)
+			},
+			maxPages: 1,
+			wantErr:  true,
+		},
+		{
+			name: "should fail if the credits can't be recorded",
+			handler: func(_ int, w http.ResponseWriter) {
+				_, _ = w.Write([]byte(`{"status": {"credit_count": 25}, "data": []}`))
+			},
+			maxPages:  1,
+			addErr:    fmt.Errorf("ledger unavailable"),
+			wantAdded: []int{25},
+			wantErr:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			page := 0
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				page++
+				tt.handler(page, w)
+			}))
+			defer srv.Close()
+			p, err := coinmarketcap.NewCoinMarketCapProvider("dummy-api-key")
+			if err != nil {
+				t.Fatal(err)
+			}
+			ledger := &stubLedger{addErr: tt.addErr}
+			p.URL, p.Limit, p.MaxPages, p.Ledger = srv.URL, 2, tt.maxPages, ledger
+
+			_, err = p.QueryLatest(context.Background())
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tt.wantAdded, ledger.added)
+			for _, at := range ledger.addedAts {
+				assert.Equal(t, coinmarketcap.Now(), at)
+			}
+		})
+	}
+}
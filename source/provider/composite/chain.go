@@ -0,0 +1,104 @@
+package composite
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/benjohns1/invest-source/app"
+	"github.com/benjohns1/invest-source/provider/envelope"
+)
+
+// Named provider, the name is recorded with the data it produced.
+type Named struct {
+	Name     string
+	Provider app.Provider
+}
+
+// Chain is a provider that fails over between providers in priority order, recording which one produced the data so it's parsed by the same provider.
+type Chain struct {
+	Providers []Named
+	Log       app.Log
+}
+
+// NewChain creates a failover chain of providers, in priority order.
+func NewChain(log app.Log, providers ...Named) (Chain, error) {
+	c := Chain{
+		Providers: providers,
+		Log:       log,
+	}
+	if err := c.Validate(); err != nil {
+		return Chain{}, err
+	}
+	return c, nil
+}
+
+// Validate returns an error if the chain was not correctly instantiated.
+func (c Chain) Validate() error {
+	if len(c.Providers) == 0 {
+		return fmt.Errorf("chain must have at least one provider")
+	}
+	names := make(map[string]struct{}, len(c.Providers))
+	for i, p := range c.Providers {
+		if p.Name == "" {
+			return fmt.Errorf("chain provider %d must have a name", i)
+		}
+		if p.Provider == nil {
+			return fmt.Errorf("chain provider '%s' must be set", p.Name)
+		}
+		if _, ok := names[p.Name]; ok {
+			return fmt.Errorf("chain provider '%s' is duplicated", p.Name)
+		}
+		names[p.Name] = struct{}{}
+	}
+	if c.Log == nil {
+		return fmt.Errorf("chain Log must be set")
+	}
+
+	return nil
+}
+
+// QueryLatest queries each provider in order until one succeeds, and returns its data enveloped with the provider's name. Returns all errors if every provider fails.
+func (c Chain) QueryLatest(ctx context.Context) ([]byte, error) {
+	errs := make([]error, 0, len(c.Providers))
+	for _, p := range c.Providers {
+		data, err := p.Provider.QueryLatest(ctx)
+		if err != nil {
+			c.Log.Warn("provider failed, trying next provider", "provider", p.Name, "error", err)
+			errs = append(errs, fmt.Errorf("%s: %w", p.Name, err))
+			continue
+		}
+		c.Log.Info("queried provider", "provider", p.Name, "bytes", len(data))
+		return envelope.SetMeta(data, map[string]string{envelope.MetaProvider: p.Name})
+	}
+	return nil, fmt.Errorf("all providers failed: %w", errors.Join(errs...))
+}
+
+// ParseQuotes dispatches to the provider that produced the data. Data without an envelope, or whose envelope doesn't name a provider, is parsed by the first provider, since it was cached before the chain was introduced.
+func (c Chain) ParseQuotes(ctx context.Context, data []byte, symbols ...string) ([]app.Quote, error) {
+	if data == nil {
+		return nil, fmt.Errorf("data cannot be empty")
+	}
+	payload, meta, ok := envelope.Unwrap(data)
+	if !ok {
+		return c.Providers[0].Provider.ParseQuotes(ctx, data, symbols...)
+	}
+	name, ok := meta[envelope.MetaProvider]
+	if !ok {
+		return c.Providers[0].Provider.ParseQuotes(ctx, payload, symbols...)
+	}
+	p, err := c.provider(name)
+	if err != nil {
+		return nil, err
+	}
+	return p.ParseQuotes(ctx, payload, symbols...)
+}
+
+func (c Chain) provider(name string) (app.Provider, error) {
+	for _, p := range c.Providers {
+		if p.Name == name {
+			return p.Provider, nil
+		}
+	}
+	return nil, fmt.Errorf("data was produced by provider '%s', which isn't configured", name)
+}
@@ -0,0 +1,190 @@
+package composite_test
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"testing"
+
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/benjohns1/invest-source/app"
+	"github.com/benjohns1/invest-source/provider/composite"
+	"github.com/benjohns1/invest-source/provider/envelope"
+)
+
+// stubProvider returns fixed data, and parses data into a single quote priced by the provider's name.
+type stubProvider struct {
+	name    string
+	data    []byte
+	err     error
+	queried int
+}
+
+func (p *stubProvider) QueryLatest(context.Context) ([]byte, error) {
+	p.queried++
+	return p.data, p.err
+}
+
+func (p *stubProvider) ParseQuotes(_ context.Context, data []byte, symbols ...string) ([]app.Quote, error) {
+	if string(data) != string(p.data) {
+		return nil, fmt.Errorf("%s can't parse '%s'", p.name, data)
+	}
+	return []app.Quote{{Symbol: p.name, USD: decimal.NewFromInt(1)}}, nil
+}
+
+var testLog = slog.New(slog.NewTextHandler(os.Stdout, nil))
+
+func TestNewChain(t *testing.T) {
+	p := &stubProvider{}
+	tests := []struct {
+		name      string
+		providers []composite.Named
+		wantErr   bool
+	}{
+		{
+			name:    "should fail without providers",
+			wantErr: true,
+		},
+		{
+			name:      "should fail with an unnamed provider",
+			providers: []composite.Named{{Provider: p}},
+			wantErr:   true,
+		},
+		{
+			name:      "should fail with a duplicate name",
+			providers: []composite.Named{{Name: "a", Provider: p}, {Name: "a", Provider: p}},
+			wantErr:   true,
+		},
+		{
+			name:      "should succeed with named providers",
+			providers: []composite.Named{{Name: "a", Provider: p}, {Name: "b", Provider: p}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := composite.NewChain(testLog, tt.providers...)
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestChain_QueryLatest(t *testing.T) {
+	tests := []struct {
+		name         string
+		primary      *stubProvider
+		secondary    *stubProvider
+		wantProvider string
+		wantErr      bool
+	}{
+		{
+			name:         "should return the first provider's data",
+			primary:      &stubProvider{name: "primary", data: []byte(`{"p":1}`)},
+			secondary:    &stubProvider{name: "secondary", data: []byte(`{"s":1}`)},
+			wantProvider: "primary",
+		},
+		{
+			name:         "should fail over to the next provider",
+			primary:      &stubProvider{name: "primary", err: fmt.Errorf("credits exhausted")},
+			secondary:    &stubProvider{name: "secondary", data: []byte(`{"s":1}`)},
+			wantProvider: "secondary",
+		},
+		{
+			name:      "should fail if every provider fails",
+			primary:   &stubProvider{name: "primary", err: fmt.Errorf("credits exhausted")},
+			secondary: &stubProvider{name: "secondary", err: fmt.Errorf("unavailable")},
+			wantErr:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := composite.NewChain(testLog, composite.Named{Name: "primary", Provider: tt.primary}, composite.Named{Name: "secondary", Provider: tt.secondary})
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := c.QueryLatest(context.Background())
+			if tt.wantErr {
+				assert.Error(t, err)
+				assert.ErrorIs(t, err, tt.primary.err)
+				assert.ErrorIs(t, err, tt.secondary.err)
+				return
+			}
+			assert.NoError(t, err)
+			_, meta, ok := envelope.Unwrap(got)
+			assert.True(t, ok)
+			assert.Equal(t, tt.wantProvider, meta[envelope.MetaProvider])
+			if tt.wantProvider == "primary" {
+				assert.Equal(t, 0, tt.secondary.queried)
+			}
+
+			quotes, err := c.ParseQuotes(context.Background(), got)
+			assert.NoError(t, err)
+			assert.Equal(t, []app.Quote{{Symbol: tt.wantProvider, USD: decimal.NewFromInt(1)}}, quotes)
+		})
+	}
+}
+
+func TestChain_ParseQuotes(t *testing.T) {
+	primary := &stubProvider{name: "primary", data: []byte(`{"p":1}`)}
+	secondary := &stubProvider{name: "secondary", data: []byte(`{"s":1}`)}
+	c, err := composite.NewChain(testLog, composite.Named{Name: "primary", Provider: primary}, composite.Named{Name: "secondary", Provider: secondary})
+	if err != nil {
+		t.Fatal(err)
+	}
+	wrap := func(data []byte, meta, value string) []byte {
+		wrapped, err := envelope.Wrap(data, map[string]string{meta: value})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return wrapped
+	}
+
+	tests := []struct {
+		name    string
+		data    []byte
+		want    []app.Quote
+		wantErr bool
+	}{
+		{
+			name:    "should fail with nil data",
+			wantErr: true,
+		},
+		{
+			name: "should parse data without an envelope with the first provider",
+			data: []byte(`{"p":1}`),
+			want: []app.Quote{{Symbol: "primary", USD: decimal.NewFromInt(1)}},
+		},
+		{
+			name: "should parse enveloped data without a provider with the first provider",
+			data: wrap([]byte(`{"p":1}`), envelope.MetaCredits, "25"),
+			want: []app.Quote{{Symbol: "primary", USD: decimal.NewFromInt(1)}},
+		},
+		{
+			name: "should dispatch to the provider that produced the data",
+			data: wrap([]byte(`{"s":1}`), envelope.MetaProvider, "secondary"),
+			want: []app.Quote{{Symbol: "secondary", USD: decimal.NewFromInt(1)}},
+		},
+		{
+			name:    "should fail if the producing provider isn't configured",
+			data:    wrap([]byte(`{"x":1}`), envelope.MetaProvider, "removed"),
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := c.ParseQuotes(context.Background(), tt.data)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
@@ -0,0 +1,229 @@
+package composite
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/shopspring/decimal"
+
+	"github.com/benjohns1/invest-source/app"
+	"github.com/benjohns1/invest-source/provider/envelope"
+)
+
+// Consensus is a provider that queries every provider, and reconciles each symbol's price across them when parsing.
+type Consensus struct {
+	Providers []Named
+	// Tolerance is the relative deviation from the median price above which a source's price is flagged as an outlier, e.g. 0.02 for 2%.
+	Tolerance decimal.Decimal
+	// Median emits the median price across sources, otherwise the price of the highest priority source is emitted.
+	Median bool
+	Log    app.Log
+
+	chain Chain
+}
+
+// NewConsensus creates a consensus of providers, in priority order.
+func NewConsensus(log app.Log, tolerance decimal.Decimal, median bool, providers ...Named) (Consensus, error) {
+	c := Consensus{
+		Providers: providers,
+		Tolerance: tolerance,
+		Median:    median,
+		Log:       log,
+		chain:     Chain{Providers: providers, Log: log},
+	}
+	if err := c.Validate(); err != nil {
+		return Consensus{}, err
+	}
+	return c, nil
+}
+
+// Validate returns an error if the consensus was not correctly instantiated.
+func (c Consensus) Validate() error {
+	if err := c.chain.Validate(); err != nil {
+		return err
+	}
+	if !c.Tolerance.IsPositive() {
+		return fmt.Errorf("consensus Tolerance must be greater than 0, got %s", c.Tolerance)
+	}
+
+	return nil
+}
+
+type sources struct {
+	Sources  map[string]json.RawMessage `json:"sources"`
+	Outliers []Outlier                  `json:"outliers,omitempty"`
+}
+
+// QueryLatest queries every provider and combines their enveloped data. Failed providers are logged and left out, returns all errors if every provider fails.
+// Every symbol is reconciled across the providers, logging the outliers and recording them with the data.
+func (c Consensus) QueryLatest(ctx context.Context) ([]byte, error) {
+	v := sources{Sources: make(map[string]json.RawMessage, len(c.Providers))}
+	errs := make([]error, 0)
+	for _, p := range c.Providers {
+		data, err := p.Provider.QueryLatest(ctx)
+		if err != nil {
+			c.Log.Warn("provider failed, leaving it out of consensus", "provider", p.Name, "error", err)
+			errs = append(errs, fmt.Errorf("%s: %w", p.Name, err))
+			continue
+		}
+		c.Log.Info("queried provider", "provider", p.Name, "bytes", len(data))
+		if v.Sources[p.Name], err = envelope.SetMeta(data, map[string]string{envelope.MetaProvider: p.Name}); err != nil {
+			return nil, err
+		}
+	}
+	if len(v.Sources) == 0 {
+		return nil, fmt.Errorf("all providers failed: %w", errors.Join(errs...))
+	}
+	parsed, err := c.parseSources(ctx, v)
+	if err != nil {
+		c.Log.Warn("error reconciling queried prices, caching them without outliers", "error", err)
+		return json.Marshal(v)
+	}
+	_, report := Reconcile(parsed, c.Tolerance, c.Median)
+	c.logOutliers(report)
+	v.Outliers = report.Outliers
+	return json.Marshal(v)
+}
+
+// ParseQuotes parses each source's data with the provider that produced it, and reconciles prices across sources. Outliers were logged when the data was queried, so only their recorded number is logged. Data from a single provider, e.g. cached by a Chain, is parsed without reconciling.
+func (c Consensus) ParseQuotes(ctx context.Context, data []byte, symbols ...string) ([]app.Quote, error) {
+	if data == nil {
+		return nil, fmt.Errorf("data cannot be empty")
+	}
+	var v sources
+	if err := json.Unmarshal(data, &v); err != nil || v.Sources == nil {
+		return c.chain.ParseQuotes(ctx, data, symbols...)
+	}
+
+	for name := range v.Sources {
+		if _, err := c.chain.provider(name); err != nil {
+			c.Log.Warn("data from provider isn't configured in the consensus, ignoring it", "provider", name)
+		}
+	}
+	parsed, err := c.parseSources(ctx, v, symbols...)
+	if err != nil {
+		return nil, err
+	}
+
+	quotes, _ := Reconcile(parsed, c.Tolerance, c.Median)
+	c.Log.Debug("reconciled cached prices", "sources", len(parsed), "recordedOutliers", len(v.Outliers))
+	return quotes, nil
+}
+
+// parseSources parses each source's data with the provider that produced it, in priority order.
+func (c Consensus) parseSources(ctx context.Context, v sources, symbols ...string) ([]SourceQuotes, error) {
+	parsed := make([]SourceQuotes, 0, len(v.Sources))
+	for _, p := range c.Providers {
+		sourceData, ok := v.Sources[p.Name]
+		if !ok {
+			continue
+		}
+		payload, _, _ := envelope.Unwrap(sourceData)
+		quotes, err := p.Provider.ParseQuotes(ctx, payload, symbols...)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing %s data: %v", p.Name, err)
+		}
+		parsed = append(parsed, SourceQuotes{Provider: p.Name, Quotes: quotes})
+	}
+	return parsed, nil
+}
+
+func (c Consensus) logOutliers(report Report) {
+	for _, o := range report.Outliers {
+		c.Log.Warn("price outlier", "symbol", o.Symbol, "provider", o.Provider, "price", o.Price.String(), "median", o.Median.String(), "deviation", o.Deviation.String())
+	}
+}
+
+// SourceQuotes are the quotes parsed from a single provider.
+type SourceQuotes struct {
+	Provider string
+	Quotes   []app.Quote
+}
+
+// Outlier is a source's price that deviates from the median price by more than the tolerance.
+type Outlier struct {
+	Symbol    string          `json:"symbol"`
+	Provider  string          `json:"provider"`
+	Price     decimal.Decimal `json:"price"`
+	Median    decimal.Decimal `json:"median"`
+	Deviation decimal.Decimal `json:"deviation"`
+}
+
+func (o Outlier) String() string {
+	return fmt.Sprintf("%s from %s is %s, %s%% from the median %s", o.Symbol, o.Provider, o.Price, o.Deviation.Mul(decimal.NewFromInt(100)).StringFixed(2), o.Median)
+}
+
+// Report lists the outliers found when reconciling prices.
+type Report struct {
+	Outliers []Outlier
+}
+
+// Reconcile compares each symbol's price across sources, in priority order, and reports prices deviating from the median by more than the tolerance.
+// It returns the median quote for each symbol if median is true, otherwise the quote from the highest priority source. With two sources, both are reported if they deviate, since the median is their mean.
+// Only a source's first quote of a symbol is compared, so that a source listing several assets under one symbol counts once towards the median.
+func Reconcile(sources []SourceQuotes, tolerance decimal.Decimal, median bool) ([]app.Quote, Report) {
+	type sourceQuote struct {
+		provider string
+		quote    app.Quote
+	}
+	symbols := make([]string, 0)
+	bySymbol := make(map[string][]sourceQuote)
+	for _, s := range sources {
+		seen := make(map[string]struct{}, len(s.Quotes))
+		for _, q := range s.Quotes {
+			if _, ok := seen[q.Symbol]; ok {
+				continue
+			}
+			seen[q.Symbol] = struct{}{}
+			if _, ok := bySymbol[q.Symbol]; !ok {
+				symbols = append(symbols, q.Symbol)
+			}
+			bySymbol[q.Symbol] = append(bySymbol[q.Symbol], sourceQuote{provider: s.Provider, quote: q})
+		}
+	}
+
+	var report Report
+	quotes := make([]app.Quote, 0, len(symbols))
+	for _, symbol := range symbols {
+		sqs := bySymbol[symbol]
+		prices := make([]decimal.Decimal, 0, len(sqs))
+		latest := time.Time{}
+		for _, sq := range sqs {
+			prices = append(prices, sq.quote.USD)
+			if sq.quote.Time.After(latest) {
+				latest = sq.quote.Time
+			}
+		}
+		m := medianOf(prices)
+		if m.IsPositive() {
+			for _, sq := range sqs {
+				deviation := sq.quote.USD.Sub(m).Abs().Div(m)
+				if deviation.GreaterThan(tolerance) {
+					report.Outliers = append(report.Outliers, Outlier{Symbol: symbol, Provider: sq.provider, Price: sq.quote.USD, Median: m, Deviation: deviation})
+				}
+			}
+		}
+
+		if median {
+			quotes = append(quotes, app.Quote{Time: latest, Symbol: symbol, USD: m})
+		} else {
+			quotes = append(quotes, sqs[0].quote)
+		}
+	}
+	return quotes, report
+}
+
+func medianOf(prices []decimal.Decimal) decimal.Decimal {
+	sorted := make([]decimal.Decimal, len(prices))
+	copy(sorted, prices)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].LessThan(sorted[j]) })
+	mid := len(sorted) / 2
+	if len(sorted)%2 == 1 {
+		return sorted[mid]
+	}
+	return sorted[mid-1].Add(sorted[mid]).Div(decimal.NewFromInt(2))
+}
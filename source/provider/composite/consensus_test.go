@@ -0,0 +1,268 @@
+package composite_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/benjohns1/invest-source/app"
+	"github.com/benjohns1/invest-source/provider/composite"
+	"github.com/benjohns1/invest-source/provider/envelope"
+)
+
+var quoteTime = time.Date(2021, time.June, 21, 0, 0, 0, 0, time.UTC)
+
+// pricesProvider returns its prices as a JSON map of symbol to price, and parses them back into quotes in symbol order.
+type pricesProvider struct {
+	prices map[string]string
+	err    error
+}
+
+func (p pricesProvider) QueryLatest(context.Context) ([]byte, error) {
+	if p.err != nil {
+		return nil, p.err
+	}
+	return json.Marshal(p.prices)
+}
+
+func (p pricesProvider) ParseQuotes(_ context.Context, data []byte, symbols ...string) ([]app.Quote, error) {
+	var prices map[string]string
+	if err := json.Unmarshal(data, &prices); err != nil {
+		return nil, err
+	}
+	quotes := make([]app.Quote, 0)
+	for _, symbol := range []string{"BTC", "ETH", "USDC"} {
+		if price, ok := prices[symbol]; ok {
+			quotes = append(quotes, app.Quote{Time: quoteTime, Symbol: symbol, USD: decimal.RequireFromString(price)})
+		}
+	}
+	return quotes, nil
+}
+
+func quote(symbol string, price string) app.Quote {
+	return app.Quote{Time: quoteTime, Symbol: symbol, USD: decimal.RequireFromString(price)}
+}
+
+func TestReconcile(t *testing.T) {
+	tolerance := decimal.RequireFromString("0.02")
+	three := []composite.SourceQuotes{
+		{Provider: "a", Quotes: []app.Quote{quote("BTC", "35000"), quote("ETH", "2250")}},
+		{Provider: "b", Quotes: []app.Quote{quote("BTC", "35100"), quote("ETH", "2000")}},
+		{Provider: "c", Quotes: []app.Quote{quote("BTC", "35200"), quote("ETH", "2260"), quote("USDC", "1")}},
+	}
+
+	tests := []struct {
+		name        string
+		sources     []composite.SourceQuotes
+		median      bool
+		want        []app.Quote
+		wantOutlier []string
+	}{
+		{
+			name:        "should flag outliers and emit the highest priority source's quotes",
+			sources:     three,
+			want:        []app.Quote{quote("BTC", "35000"), quote("ETH", "2250"), quote("USDC", "1")},
+			wantOutlier: []string{"ETH b"},
+		},
+		{
+			name:        "should flag outliers and emit the median quotes",
+			sources:     three,
+			median:      true,
+			want:        []app.Quote{quote("BTC", "35100"), quote("ETH", "2250"), quote("USDC", "1")},
+			wantOutlier: []string{"ETH b"},
+		},
+		{
+			name: "should flag both sources when two sources deviate",
+			sources: []composite.SourceQuotes{
+				{Provider: "a", Quotes: []app.Quote{quote("BTC", "30000")}},
+				{Provider: "b", Quotes: []app.Quote{quote("BTC", "40000")}},
+			},
+			median:      true,
+			want:        []app.Quote{quote("BTC", "35000")},
+			wantOutlier: []string{"BTC a", "BTC b"},
+		},
+		{
+			name: "should only compare a source's first quote of a symbol",
+			sources: []composite.SourceQuotes{
+				{Provider: "a", Quotes: []app.Quote{quote("BTC", "35000"), quote("BTC", "21.37")}},
+				{Provider: "b", Quotes: []app.Quote{quote("BTC", "35100")}},
+				{Provider: "c", Quotes: []app.Quote{quote("BTC", "35200")}},
+			},
+			median: true,
+			want:   []app.Quote{quote("BTC", "35100")},
+		},
+		{
+			name:    "should pass a single source through",
+			sources: three[:1],
+			want:    []app.Quote{quote("BTC", "35000"), quote("ETH", "2250")},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, report := composite.Reconcile(tt.sources, tolerance, tt.median)
+			if assert.Len(t, got, len(tt.want)) {
+				for i, want := range tt.want {
+					assert.Equal(t, want.Symbol, got[i].Symbol)
+					assert.Equal(t, want.Time, got[i].Time)
+					assert.True(t, want.USD.Equal(got[i].USD), "%s: want %s, got %s", want.Symbol, want.USD, got[i].USD)
+				}
+			}
+			outliers := make([]string, 0, len(report.Outliers))
+			for _, o := range report.Outliers {
+				outliers = append(outliers, fmt.Sprintf("%s %s", o.Symbol, o.Provider))
+			}
+			if len(tt.wantOutlier) == 0 {
+				tt.wantOutlier = []string{}
+			}
+			assert.Equal(t, tt.wantOutlier, outliers)
+		})
+	}
+}
+
+func TestConsensus(t *testing.T) {
+	a := pricesProvider{prices: map[string]string{"BTC": "35000", "ETH": "2250"}}
+	b := pricesProvider{prices: map[string]string{"BTC": "35100", "ETH": "2000"}}
+	c := pricesProvider{prices: map[string]string{"BTC": "35200", "ETH": "2260"}}
+	down := pricesProvider{err: fmt.Errorf("unavailable")}
+
+	tests := []struct {
+		name      string
+		providers []composite.Named
+		data      func(t *testing.T) []byte
+		want      []app.Quote
+		wantErr   bool
+	}{
+		{
+			name:      "should emit the median of every provider's prices",
+			providers: []composite.Named{{Name: "a", Provider: a}, {Name: "b", Provider: b}, {Name: "c", Provider: c}},
+			want:      []app.Quote{quote("BTC", "35100"), quote("ETH", "2250")},
+		},
+		{
+			name:      "should leave out failed providers",
+			providers: []composite.Named{{Name: "down", Provider: down}, {Name: "b", Provider: b}, {Name: "c", Provider: c}},
+			want:      []app.Quote{quote("BTC", "35150"), quote("ETH", "2130")},
+		},
+		{
+			name:      "should fail if every provider fails",
+			providers: []composite.Named{{Name: "down", Provider: down}, {Name: "also-down", Provider: down}},
+			wantErr:   true,
+		},
+		{
+			name:      "should parse data cached by a single provider",
+			providers: []composite.Named{{Name: "a", Provider: a}, {Name: "b", Provider: b}},
+			data: func(t *testing.T) []byte {
+				data, err := envelope.Wrap([]byte(`{"BTC":"35100"}`), map[string]string{envelope.MetaProvider: "b"})
+				if err != nil {
+					t.Fatal(err)
+				}
+				return data
+			},
+			want: []app.Quote{quote("BTC", "35100")},
+		},
+		{
+			name:      "should parse data cached before envelopes with the first provider",
+			providers: []composite.Named{{Name: "a", Provider: a}, {Name: "b", Provider: b}},
+			data:      func(t *testing.T) []byte { return []byte(`{"BTC":"35000"}`) },
+			want:      []app.Quote{quote("BTC", "35000")},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			consensus, err := composite.NewConsensus(testLog, decimal.RequireFromString("0.02"), true, tt.providers...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var data []byte
+			if tt.data != nil {
+				data = tt.data(t)
+			} else {
+				data, err = consensus.QueryLatest(context.Background())
+				if tt.wantErr {
+					assert.Error(t, err)
+					return
+				}
+				assert.NoError(t, err)
+			}
+			got, err := consensus.ParseQuotes(context.Background(), data)
+			assert.NoError(t, err)
+			if assert.Len(t, got, len(tt.want)) {
+				for i, want := range tt.want {
+					assert.Equal(t, want.Symbol, got[i].Symbol)
+					assert.True(t, want.USD.Equal(got[i].USD), "%s: want %s, got %s", want.Symbol, want.USD, got[i].USD)
+				}
+			}
+		})
+	}
+}
+
+func TestNewConsensus(t *testing.T) {
+	_, err := composite.NewConsensus(testLog, decimal.Zero, true, composite.Named{Name: "a", Provider: pricesProvider{}})
+	assert.Error(t, err)
+	_, err = composite.NewConsensus(testLog, decimal.RequireFromString("0.02"), true)
+	assert.Error(t, err)
+}
+
+func TestConsensus_outliers(t *testing.T) {
+	var logged bytes.Buffer
+	log := slog.New(slog.NewTextHandler(&logged, nil))
+	consensus, err := composite.NewConsensus(log, decimal.RequireFromString("0.02"), true,
+		composite.Named{Name: "a", Provider: pricesProvider{prices: map[string]string{"BTC": "35000", "ETH": "2250"}}},
+		composite.Named{Name: "b", Provider: pricesProvider{prices: map[string]string{"BTC": "35100", "ETH": "2000"}}},
+		composite.Named{Name: "c", Provider: pricesProvider{prices: map[string]string{"BTC": "35200", "ETH": "2260"}}},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := consensus.QueryLatest(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var recorded struct {
+		Outliers []composite.Outlier `json:"outliers"`
+	}
+	assert.NoError(t, json.Unmarshal(data, &recorded))
+	if assert.Len(t, recorded.Outliers, 1) {
+		assert.Equal(t, "ETH from b is 2000, 11.11% from the median 2250", recorded.Outliers[0].String())
+	}
+	assert.Equal(t, 1, strings.Count(logged.String(), "price outlier"), "should log outliers when querying")
+
+	logged.Reset()
+	got, err := consensus.ParseQuotes(context.Background(), data)
+	assert.NoError(t, err)
+	assert.Len(t, got, 2, "should still parse data with recorded outliers")
+	assert.NotContains(t, logged.String(), "price outlier", "should not log outliers again when parsing")
+}
+
+func TestConsensus_metadata(t *testing.T) {
+	consensus, err := composite.NewConsensus(testLog, decimal.RequireFromString("0.02"), true,
+		composite.Named{Name: "a", Provider: pricesProvider{prices: map[string]string{"BTC": "1"}}},
+		composite.Named{Name: "b", Provider: pricesProvider{prices: map[string]string{"BTC": "1"}}},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := consensus.QueryLatest(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var v struct {
+		Sources map[string]json.RawMessage `json:"sources"`
+	}
+	assert.NoError(t, json.Unmarshal(data, &v))
+	providers := make([]string, 0, len(v.Sources))
+	for _, sourceData := range v.Sources {
+		_, meta, ok := envelope.Unwrap(sourceData)
+		assert.True(t, ok)
+		providers = append(providers, meta[envelope.MetaProvider])
+	}
+	assert.ElementsMatch(t, []string{"a", "b"}, providers, "should record each source's provider in its metadata")
+}
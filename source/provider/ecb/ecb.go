@@ -0,0 +1,172 @@
+package ecb
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/shopspring/decimal"
+
+	"github.com/benjohns1/invest-source/app"
+	"github.com/benjohns1/invest-source/tracing"
+)
+
+// Provider European Central Bank euro foreign exchange reference rates provider.
+type Provider struct {
+	URL string
+	// HistoryURL of the reference rates for every business day since 1999.
+	HistoryURL string
+}
+
+// NewECBProvider creates a new provider for the ECB daily euro foreign exchange reference rates (https://www.ecb.europa.eu/stats/policy_and_exchange_rates/euro_reference_exchange_rates/html/index.en.html).
+func NewECBProvider() (Provider, error) {
+	p := Provider{
+		URL:        "https://www.ecb.europa.eu/stats/eurofxref/eurofxref-daily.xml",
+		HistoryURL: "https://www.ecb.europa.eu/stats/eurofxref/eurofxref-hist.xml",
+	}
+	if err := p.Validate(); err != nil {
+		return Provider{}, err
+	}
+	return p, nil
+}
+
+// Validate returns an error if the provider was not correctly instantiated.
+func (p Provider) Validate() error {
+	if p.URL == "" {
+		return fmt.Errorf("provider URL must be set")
+	}
+	if p.HistoryURL == "" {
+		return fmt.Errorf("provider HistoryURL must be set")
+	}
+
+	return nil
+}
+
+// QueryLatest retrieves the latest reference rates XML from the ECB. The ECB publishes rates on business days at around 16:00 CET, so it holds the previous business day's rates until then.
+func (p Provider) QueryLatest(ctx context.Context) ([]byte, error) {
+	return get(ctx, p.URL)
+}
+
+// QueryHistory retrieves the reference rates for every business day since 1999, split into each day's XML in the same format as QueryLatest, keyed by day in the app.DateFormat.
+func (p Provider) QueryHistory(ctx context.Context) (map[string][]byte, error) {
+	data, err := get(ctx, p.HistoryURL)
+	if err != nil {
+		return nil, err
+	}
+	v := envelope{}
+	if err := xml.Unmarshal(data, &v); err != nil {
+		return nil, fmt.Errorf("error unmarshalling history data into XML: %v", err)
+	}
+
+	days := make(map[string][]byte, len(v.Cube.Days))
+	for _, day := range v.Cube.Days {
+		if _, err := time.Parse(app.DateFormat, day.Time); err != nil {
+			return nil, fmt.Errorf("error parsing reference rate date: %v", err)
+		}
+		dayData, err := xml.Marshal(envelope{Cube: cube{Days: []cubeDay{day}}})
+		if err != nil {
+			return nil, fmt.Errorf("error marshalling %s reference rates: %v", day.Time, err)
+		}
+		days[day.Time] = dayData
+	}
+	return days, nil
+}
+
+func get(ctx context.Context, url string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := tracing.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s raw response body: %s", resp.Status, respBody)
+	}
+
+	return respBody, nil
+}
+
+type envelope struct {
+	XMLName xml.Name `xml:"Envelope"`
+	Cube    cube     `xml:"Cube"`
+}
+
+type cube struct {
+	Days []cubeDay `xml:"Cube"`
+}
+
+type cubeDay struct {
+	Time  string     `xml:"time,attr"`
+	Rates []cubeRate `xml:"Cube"`
+}
+
+type cubeRate struct {
+	Currency string `xml:"currency,attr"`
+	Rate     string `xml:"rate,attr"`
+}
+
+// ParseQuotes parses the reference rates into quotes of each currency's price in USD, including EUR.
+func (p Provider) ParseQuotes(_ context.Context, data []byte, symbols ...string) ([]app.Quote, error) {
+	if data == nil {
+		return nil, fmt.Errorf("data cannot be empty")
+	}
+	v := envelope{}
+	if err := xml.Unmarshal(data, &v); err != nil {
+		return nil, fmt.Errorf("error unmarshalling data into XML: %v", err)
+	}
+	filterSymbols := len(symbols) > 0
+	symbolMap := make(map[string]struct{}, len(symbols))
+	for _, symbol := range symbols {
+		symbolMap[symbol] = struct{}{}
+	}
+
+	quotes := make([]app.Quote, 0)
+	for _, day := range v.Cube.Days {
+		t, err := time.Parse("2006-01-02", day.Time)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing reference rate date: %v", err)
+		}
+		currencies := []string{"EUR"}
+		perEUR := map[string]decimal.Decimal{"EUR": decimal.NewFromInt(1)}
+		for _, r := range day.Rates {
+			rate, err := decimal.NewFromString(r.Rate)
+			if err != nil {
+				return nil, fmt.Errorf("error parsing %s reference rate: %v", r.Currency, err)
+			}
+			if !rate.IsPositive() {
+				return nil, fmt.Errorf("invalid %s reference rate %s", r.Currency, r.Rate)
+			}
+			currencies = append(currencies, r.Currency)
+			perEUR[r.Currency] = rate
+		}
+		usdPerEUR, ok := perEUR["USD"]
+		if !ok {
+			return nil, fmt.Errorf("no USD reference rate found for %s", day.Time)
+		}
+		for _, currency := range currencies {
+			if filterSymbols {
+				if _, ok := symbolMap[currency]; !ok {
+					continue
+				}
+			}
+			quotes = append(quotes, app.Quote{
+				Time:   t,
+				Symbol: currency,
+				USD:    usdPerEUR.Div(perEUR[currency]),
+			})
+		}
+	}
+	return quotes, nil
+}
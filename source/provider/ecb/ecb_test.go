@@ -0,0 +1,160 @@
+package ecb_test
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/benjohns1/invest-source/app"
+	"github.com/benjohns1/invest-source/provider/ecb"
+)
+
+func TestProvider_ParseQuotes(t *testing.T) {
+	fixture, err := ioutil.ReadFile("testdata/eurofxref-daily.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	date := time.Date(2021, time.June, 21, 0, 0, 0, 0, time.UTC)
+	usdPerEUR := decimal.RequireFromString("1.1898")
+
+	type args struct {
+		data    []byte
+		symbols []string
+	}
+	tests := []struct {
+		name    string
+		args    args
+		want    []app.Quote
+		wantErr bool
+	}{
+		{
+			name: "should fail with invalid xml",
+			args: args{
+				data: []byte("invalid-xml"),
+			},
+			wantErr: true,
+		},
+		{
+			name: "should fail with nil data",
+			args: args{
+				data: nil,
+			},
+			wantErr: true,
+		},
+		{
+			name: "should fail without a USD rate",
+			args: args{
+				data: []byte(`<Envelope><Cube><Cube time="2021-06-21"><Cube currency="GBP" rate="0.85830"/></Cube></Cube></Envelope>`),
+			},
+			wantErr: true,
+		},
+		{
+			name: "should fail with an invalid rate",
+			args: args{
+				data: []byte(`<Envelope><Cube><Cube time="2021-06-21"><Cube currency="USD" rate="invalid"/></Cube></Cube></Envelope>`),
+			},
+			wantErr: true,
+		},
+		{
+			name: "should return each currency's price in USD, including EUR",
+			args: args{
+				data:    fixture,
+				symbols: []string{"EUR", "USD", "GBP"},
+			},
+			want: []app.Quote{
+				{Time: date, Symbol: "EUR", USD: usdPerEUR},
+				{Time: date, Symbol: "USD", USD: decimal.NewFromInt(1)},
+				{Time: date, Symbol: "GBP", USD: usdPerEUR.Div(decimal.RequireFromString("0.85830"))},
+			},
+		},
+		{
+			name: "should return all currencies without a list of symbols",
+			args: args{
+				data: fixture,
+			},
+			want: []app.Quote{
+				{Time: date, Symbol: "EUR", USD: usdPerEUR},
+				{Time: date, Symbol: "USD", USD: decimal.NewFromInt(1)},
+				{Time: date, Symbol: "JPY", USD: usdPerEUR.Div(decimal.RequireFromString("131.21"))},
+				{Time: date, Symbol: "GBP", USD: usdPerEUR.Div(decimal.RequireFromString("0.85830"))},
+				{Time: date, Symbol: "CHF", USD: usdPerEUR.Div(decimal.RequireFromString("1.0954"))},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := ecb.NewECBProvider()
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := p.ParseQuotes(context.Background(), tt.args.data, tt.args.symbols...)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			if assert.Len(t, got, len(tt.want)) {
+				for i, want := range tt.want {
+					assert.Equal(t, want.Symbol, got[i].Symbol)
+					assert.Equal(t, want.Time, got[i].Time)
+					assert.True(t, want.USD.Equal(got[i].USD), "%s: want %s, got %s", want.Symbol, want.USD, got[i].USD)
+				}
+			}
+		})
+	}
+}
+
+func TestProvider_QueryLatest(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/eurofxref-daily.xml" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write([]byte("<Envelope/>"))
+	}))
+	defer srv.Close()
+
+	got, err := ecb.Provider{URL: srv.URL + "/eurofxref-daily.xml"}.QueryLatest(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("<Envelope/>"), got)
+
+	_, err = ecb.Provider{URL: srv.URL + "/missing.xml"}.QueryLatest(context.Background())
+	assert.Error(t, err)
+}
+
+func TestProvider_QueryHistory(t *testing.T) {
+	fixture, err := ioutil.ReadFile("testdata/eurofxref-hist.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/eurofxref-hist.xml" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write(fixture)
+	}))
+	defer srv.Close()
+
+	p := ecb.Provider{URL: srv.URL + "/eurofxref-daily.xml", HistoryURL: srv.URL + "/eurofxref-hist.xml"}
+	got, err := p.QueryHistory(context.Background())
+	assert.NoError(t, err)
+	if !assert.Len(t, got, 3) {
+		return
+	}
+	quotes, err := p.ParseQuotes(context.Background(), got["2021-06-18"], "GBP")
+	assert.NoError(t, err, "each day should parse like the daily rates")
+	if assert.Len(t, quotes, 1) {
+		assert.Equal(t, time.Date(2021, time.June, 18, 0, 0, 0, 0, time.UTC), quotes[0].Time)
+		assert.True(t, decimal.RequireFromString("1.1898").Div(decimal.RequireFromString("0.85868")).Equal(quotes[0].USD))
+	}
+
+	_, err = ecb.Provider{HistoryURL: srv.URL + "/missing.xml"}.QueryHistory(context.Background())
+	assert.Error(t, err)
+}
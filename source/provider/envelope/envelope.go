@@ -0,0 +1,71 @@
+package envelope
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+// Version of the envelope format, written in every envelope so that raw provider data can be told apart.
+const Version = 1
+
+// Metadata keys recorded by this repo's providers.
+const (
+	// MetaProvider is the name of the provider that produced the payload.
+	MetaProvider = "provider"
+	// MetaCredits is the number of API credits used to query the payload.
+	MetaCredits = "credits"
+)
+
+type envelope struct {
+	Version int               `json:"envelope"`
+	Meta    map[string]string `json:"meta,omitempty"`
+	Data    json.RawMessage   `json:"data,omitempty"`
+	Raw     []byte            `json:"raw,omitempty"`
+}
+
+// Wrap wraps provider data with metadata into a JSON envelope. JSON data is embedded as-is, any other data is base64 encoded.
+func Wrap(data []byte, meta map[string]string) ([]byte, error) {
+	e := envelope{Version: Version, Meta: meta}
+	if json.Valid(data) {
+		e.Data = data
+	} else {
+		e.Raw = data
+	}
+	return json.Marshal(e)
+}
+
+// Unwrap returns the payload and metadata of enveloped data, or the data itself with ok false if it isn't enveloped, e.g. if it was cached before envelopes were introduced.
+func Unwrap(data []byte) (payload []byte, meta map[string]string, ok bool) {
+	if !bytes.HasPrefix(bytes.TrimSpace(data), []byte("{")) {
+		return data, nil, false
+	}
+	var e envelope
+	if err := json.Unmarshal(data, &e); err != nil || e.Version == 0 {
+		return data, nil, false
+	}
+	if e.Meta == nil {
+		e.Meta = make(map[string]string)
+	}
+	if e.Raw != nil {
+		return e.Raw, e.Meta, true
+	}
+	return e.Data, e.Meta, true
+}
+
+// SetMeta returns the data with the metadata merged into its envelope, wrapping it first if needed.
+func SetMeta(data []byte, meta map[string]string) ([]byte, error) {
+	payload, existing, _ := Unwrap(data)
+	merged := make(map[string]string, len(existing)+len(meta))
+	for k, v := range existing {
+		merged[k] = v
+	}
+	for k, v := range meta {
+		merged[k] = v
+	}
+	wrapped, err := Wrap(payload, merged)
+	if err != nil {
+		return nil, fmt.Errorf("error wrapping data in envelope: %v", err)
+	}
+	return wrapped, nil
+}
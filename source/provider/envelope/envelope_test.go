@@ -0,0 +1,96 @@
+package envelope_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/benjohns1/invest-source/provider/envelope"
+)
+
+func TestWrap(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		meta map[string]string
+	}{
+		{
+			name: "should round trip JSON data",
+			data: []byte(`{"data":[{"symbol":"BTC"}]}`),
+			meta: map[string]string{envelope.MetaProvider: "coinmarketcap"},
+		},
+		{
+			name: "should round trip non-JSON data",
+			data: []byte(`<Envelope><Cube/></Envelope>`),
+			meta: map[string]string{envelope.MetaProvider: "ecb"},
+		},
+		{
+			name: "should round trip without metadata",
+			data: []byte(`[]`),
+			meta: map[string]string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wrapped, err := envelope.Wrap(tt.data, tt.meta)
+			assert.NoError(t, err)
+			payload, meta, ok := envelope.Unwrap(wrapped)
+			assert.True(t, ok)
+			assert.Equal(t, tt.data, payload)
+			assert.Equal(t, tt.meta, meta)
+		})
+	}
+}
+
+func TestUnwrap(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{
+			name: "should pass through JSON data without an envelope",
+			data: []byte(`{"data":[{"symbol":"BTC"}]}`),
+		},
+		{
+			name: "should pass through non-JSON data",
+			data: []byte(`<Envelope/>`),
+		},
+		{
+			name: "should pass through invalid JSON data",
+			data: []byte(`{invalid`),
+		},
+		{
+			name: "should pass through nil data",
+			data: nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			payload, meta, ok := envelope.Unwrap(tt.data)
+			assert.False(t, ok)
+			assert.Nil(t, meta)
+			assert.Equal(t, tt.data, payload)
+		})
+	}
+}
+
+func TestSetMeta(t *testing.T) {
+	wrapped, err := envelope.Wrap([]byte(`{}`), map[string]string{"credits": "1", envelope.MetaProvider: "inner"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := envelope.SetMeta(wrapped, map[string]string{envelope.MetaProvider: "outer"})
+	assert.NoError(t, err)
+	payload, meta, ok := envelope.Unwrap(got)
+	assert.True(t, ok)
+	assert.Equal(t, []byte(`{}`), payload)
+	assert.Equal(t, map[string]string{"credits": "1", envelope.MetaProvider: "outer"}, meta)
+
+	got, err = envelope.SetMeta([]byte(`{}`), map[string]string{envelope.MetaProvider: "outer"})
+	assert.NoError(t, err)
+	payload, meta, ok = envelope.Unwrap(got)
+	assert.True(t, ok)
+	assert.Equal(t, []byte(`{}`), payload)
+	assert.Equal(t, map[string]string{envelope.MetaProvider: "outer"}, meta)
+}
@@ -0,0 +1,266 @@
+package rest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/PaesslerAG/gval"
+	"github.com/PaesslerAG/jsonpath"
+	"github.com/shopspring/decimal"
+
+	"github.com/benjohns1/invest-source/app"
+	"github.com/benjohns1/invest-source/tracing"
+)
+
+// Time formats for Config.TimeFormat besides a Go time layout.
+const (
+	TimeFormatUnix   = "unix"
+	TimeFormatUnixMs = "unixms"
+)
+
+// Config declares how to query a REST/JSON API and map its response into quotes.
+type Config struct {
+	// URL of the endpoint returning the latest quotes.
+	URL string
+	// Headers to set on the request.
+	Headers map[string]string
+	// Query params to add to the request, as a list rather than a map since config keys are read in lower case and query param names are case-sensitive.
+	Query []Param
+	// SecretKey is the config key holding the API secret, e.g. in .secrets.yaml, it is not the secret itself.
+	SecretKey string
+	// SecretHeader is the request header to send the secret in.
+	SecretHeader string
+	// SecretQuery is the query param to send the secret in.
+	SecretQuery string
+	// Quotes is the JSONPath selecting the list of quote items from the response, e.g. "$.data[*]".
+	Quotes string
+	// Symbol, Price and Time are JSONPaths evaluated against each quote item, e.g. "$.symbol".
+	Symbol string
+	Price  string
+	Time   string
+	// TimeFormat is a Go time layout, "unix" or "unixms" (default RFC3339).
+	TimeFormat string
+}
+
+// Param is a named request param.
+type Param struct {
+	Name  string
+	Value string
+}
+
+// Provider generic REST/JSON API provider, mapping responses into quotes with JSONPath expressions.
+type Provider struct {
+	Config Config
+	Secret string
+
+	quotes, symbol, price, time gval.Evaluable
+}
+
+// NewProvider creates a new provider for the REST API declared by the config, with the secret resolved from its SecretKey.
+func NewProvider(cfg Config, secret string) (Provider, error) {
+	p := Provider{
+		Config: cfg,
+		Secret: secret,
+	}
+	if p.Config.TimeFormat == "" {
+		p.Config.TimeFormat = time.RFC3339
+	}
+	if err := p.Validate(); err != nil {
+		return Provider{}, err
+	}
+
+	var err error
+	for _, path := range []struct {
+		name string
+		expr string
+		eval *gval.Evaluable
+	}{
+		{"Quotes", p.Config.Quotes, &p.quotes},
+		{"Symbol", p.Config.Symbol, &p.symbol},
+		{"Price", p.Config.Price, &p.price},
+		{"Time", p.Config.Time, &p.time},
+	} {
+		if *path.eval, err = jsonpath.New(path.expr); err != nil {
+			return Provider{}, fmt.Errorf("invalid provider %s JSONPath '%s': %v", path.name, path.expr, err)
+		}
+	}
+	return p, nil
+}
+
+// Validate returns an error if the provider was not correctly instantiated.
+func (p Provider) Validate() error {
+	if p.Config.URL == "" {
+		return fmt.Errorf("provider URL must be set")
+	}
+	if _, err := url.Parse(p.Config.URL); err != nil {
+		return fmt.Errorf("invalid provider URL '%s': %v", p.Config.URL, err)
+	}
+	for i, param := range p.Config.Query {
+		if param.Name == "" {
+			return fmt.Errorf("provider Query param %d must have a Name", i)
+		}
+	}
+	for name, path := range map[string]string{"Quotes": p.Config.Quotes, "Symbol": p.Config.Symbol, "Price": p.Config.Price, "Time": p.Config.Time} {
+		if path == "" {
+			return fmt.Errorf("provider %s JSONPath must be set", name)
+		}
+	}
+	if p.Config.SecretKey != "" {
+		if p.Secret == "" {
+			return fmt.Errorf("provider secret '%s' must be set", p.Config.SecretKey)
+		}
+		if p.Config.SecretHeader == "" && p.Config.SecretQuery == "" {
+			return fmt.Errorf("provider SecretHeader or SecretQuery must be set to send secret '%s'", p.Config.SecretKey)
+		}
+	}
+
+	return nil
+}
+
+// QueryLatest retrieves the latest quote data from the configured endpoint.
+func (p Provider) QueryLatest(ctx context.Context) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", p.Config.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	q := req.URL.Query()
+	for _, param := range p.Config.Query {
+		q.Set(param.Name, param.Value)
+	}
+	req.Header.Set("Accept", "application/json")
+	for k, v := range p.Config.Headers {
+		req.Header.Set(k, v)
+	}
+	if p.Secret != "" {
+		if p.Config.SecretHeader != "" {
+			req.Header.Set(p.Config.SecretHeader, p.Secret)
+		}
+		if p.Config.SecretQuery != "" {
+			q.Set(p.Config.SecretQuery, p.Secret)
+		}
+	}
+	req.URL.RawQuery = q.Encode()
+
+	resp, err := tracing.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s raw response body: %s", resp.Status, respBody)
+	}
+
+	return respBody, nil
+}
+
+// ParseQuotes selects quote items from the data with the Quotes JSONPath, and maps each item's symbol, price and time.
+func (p Provider) ParseQuotes(ctx context.Context, data []byte, symbols ...string) ([]app.Quote, error) {
+	if data == nil {
+		return nil, fmt.Errorf("data cannot be empty")
+	}
+	if p.quotes == nil {
+		return nil, fmt.Errorf("provider must be created with NewProvider")
+	}
+	var v interface{}
+	d := json.NewDecoder(bytes.NewReader(data))
+	d.UseNumber()
+	if err := d.Decode(&v); err != nil {
+		return nil, fmt.Errorf("error unmarshalling data into JSON: %v", err)
+	}
+
+	selected, err := p.quotes(ctx, v)
+	if err != nil {
+		return nil, fmt.Errorf("error selecting quotes with '%s': %v", p.Config.Quotes, err)
+	}
+	items, ok := selected.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("quotes JSONPath '%s' must select a list, got %T", p.Config.Quotes, selected)
+	}
+
+	filterSymbols := len(symbols) > 0
+	symbolMap := make(map[string]struct{}, len(symbols))
+	for _, symbol := range symbols {
+		symbolMap[symbol] = struct{}{}
+	}
+	quotes := make([]app.Quote, 0)
+	for i, item := range items {
+		symbol, err := p.str(ctx, p.symbol, item)
+		if err != nil {
+			return nil, fmt.Errorf("error selecting symbol of quote %d with '%s': %v", i, p.Config.Symbol, err)
+		}
+		if filterSymbols {
+			if _, ok := symbolMap[symbol]; !ok {
+				continue
+			}
+		}
+		rawPrice, err := p.str(ctx, p.price, item)
+		if err != nil {
+			return nil, fmt.Errorf("error selecting price for %s with '%s': %v", symbol, p.Config.Price, err)
+		}
+		price, err := decimal.NewFromString(rawPrice)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing price for %s: %v", symbol, err)
+		}
+		rawTime, err := p.str(ctx, p.time, item)
+		if err != nil {
+			return nil, fmt.Errorf("error selecting time for %s with '%s': %v", symbol, p.Config.Time, err)
+		}
+		t, err := parseTime(p.Config.TimeFormat, rawTime)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing time for %s: %v", symbol, err)
+		}
+		quotes = append(quotes, app.Quote{
+			Time:   t,
+			Symbol: symbol,
+			USD:    price,
+		})
+	}
+	return quotes, nil
+}
+
+func (p Provider) str(ctx context.Context, eval gval.Evaluable, item interface{}) (string, error) {
+	v, err := eval(ctx, item)
+	if err != nil {
+		return "", err
+	}
+	switch v := v.(type) {
+	case string:
+		return v, nil
+	case json.Number:
+		return v.String(), nil
+	default:
+		return "", fmt.Errorf("expected a string or number, got %T", v)
+	}
+}
+
+func parseTime(format string, value string) (time.Time, error) {
+	switch strings.ToLower(format) {
+	case TimeFormatUnix, TimeFormatUnixMs:
+		// parsed as a decimal, since many APIs return fractional epoch times, e.g. 1700000000.123
+		n, err := decimal.NewFromString(value)
+		if err != nil {
+			return time.Time{}, err
+		}
+		unit := time.Second
+		if strings.ToLower(format) == TimeFormatUnixMs {
+			unit = time.Millisecond
+		}
+		return time.Unix(0, n.Mul(decimal.NewFromInt(int64(unit))).IntPart()).UTC(), nil
+	default:
+		return time.Parse(format, value)
+	}
+}
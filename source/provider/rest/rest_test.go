@@ -0,0 +1,277 @@
+package rest_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/benjohns1/invest-source/app"
+	"github.com/benjohns1/invest-source/provider/rest"
+)
+
+var exchangeConfig = rest.Config{
+	URL:        "https://api.example.com/v1/tickers",
+	Quotes:     "$.result.tickers[*]",
+	Symbol:     "$.base",
+	Price:      "$.last",
+	Time:       "$.ts",
+	TimeFormat: rest.TimeFormatUnixMs,
+}
+
+func TestNewProvider(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     func(cfg rest.Config) rest.Config
+		secret  string
+		wantErr bool
+	}{
+		{
+			name: "should succeed with a valid config",
+			cfg:  func(cfg rest.Config) rest.Config { return cfg },
+		},
+		{
+			name:    "should fail without a URL",
+			cfg:     func(cfg rest.Config) rest.Config { cfg.URL = ""; return cfg },
+			wantErr: true,
+		},
+		{
+			name:    "should fail without a Price JSONPath",
+			cfg:     func(cfg rest.Config) rest.Config { cfg.Price = ""; return cfg },
+			wantErr: true,
+		},
+		{
+			name:    "should fail with an invalid JSONPath",
+			cfg:     func(cfg rest.Config) rest.Config { cfg.Symbol = "$.[invalid"; return cfg },
+			wantErr: true,
+		},
+		{
+			name: "should fail if the secret key is set without a secret",
+			cfg: func(cfg rest.Config) rest.Config {
+				cfg.SecretKey, cfg.SecretHeader = "ExchangeApiKey", "X-Api-Key"
+				return cfg
+			},
+			wantErr: true,
+		},
+		{
+			name:    "should fail if the secret has nowhere to be sent",
+			cfg:     func(cfg rest.Config) rest.Config { cfg.SecretKey = "ExchangeApiKey"; return cfg },
+			secret:  "secret",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := rest.NewProvider(tt.cfg(exchangeConfig), tt.secret)
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestProvider_ParseQuotes(t *testing.T) {
+	data := []byte(`{
+	"result": {
+		"tickers": [
+			{"base": "BTC", "last": "35000.12", "ts": 1624233600000},
+			{"base": "ETH", "last": 2250.5, "ts": 1624233600000}
+		]
+	}
+}`)
+	ts := time.Date(2021, time.June, 21, 0, 0, 0, 0, time.UTC)
+
+	type args struct {
+		data    []byte
+		symbols []string
+	}
+	tests := []struct {
+		name    string
+		cfg     func(cfg rest.Config) rest.Config
+		args    args
+		want    []app.Quote
+		wantErr bool
+	}{
+		{
+			name:    "should fail with invalid json",
+			args:    args{data: []byte("invalid-json")},
+			wantErr: true,
+		},
+		{
+			name:    "should fail with nil data",
+			args:    args{data: nil},
+			wantErr: true,
+		},
+		{
+			name: "should map each selected item into a quote",
+			args: args{data: data},
+			want: []app.Quote{
+				{Time: ts, Symbol: "BTC", USD: decimal.RequireFromString("35000.12")},
+				{Time: ts, Symbol: "ETH", USD: decimal.RequireFromString("2250.5")},
+			},
+		},
+		{
+			name: "should only return the given symbols",
+			args: args{data: data, symbols: []string{"ETH"}},
+			want: []app.Quote{
+				{Time: ts, Symbol: "ETH", USD: decimal.RequireFromString("2250.5")},
+			},
+		},
+		{
+			name: "should parse times with a Go time layout",
+			cfg: func(cfg rest.Config) rest.Config {
+				cfg.Time, cfg.TimeFormat = "$.date", "2006-01-02"
+				return cfg
+			},
+			args: args{data: []byte(`{"result": {"tickers": [{"base": "BTC", "last": 1, "date": "2021-06-21"}]}}`)},
+			want: []app.Quote{
+				{Time: ts, Symbol: "BTC", USD: decimal.NewFromInt(1)},
+			},
+		},
+		{
+			name: "should parse fractional epoch times",
+			cfg: func(cfg rest.Config) rest.Config {
+				cfg.TimeFormat = rest.TimeFormatUnix
+				return cfg
+			},
+			args: args{data: []byte(`{"result": {"tickers": [{"base": "BTC", "last": 1, "ts": 1624233600.5}, {"base": "ETH", "last": 2, "ts": "1624233600.123"}]}}`)},
+			want: []app.Quote{
+				{Time: ts.Add(500 * time.Millisecond), Symbol: "BTC", USD: decimal.NewFromInt(1)},
+				{Time: ts.Add(123 * time.Millisecond), Symbol: "ETH", USD: decimal.NewFromInt(2)},
+			},
+		},
+		{
+			name: "should parse fractional epoch milliseconds",
+			args: args{data: []byte(`{"result": {"tickers": [{"base": "BTC", "last": 1, "ts": 1624233600000.25}]}}`)},
+			want: []app.Quote{
+				{Time: ts.Add(250 * time.Microsecond), Symbol: "BTC", USD: decimal.NewFromInt(1)},
+			},
+		},
+		{
+			name:    "should fail if the quotes JSONPath doesn't select a list",
+			cfg:     func(cfg rest.Config) rest.Config { cfg.Quotes = "$.result"; return cfg },
+			args:    args{data: data},
+			wantErr: true,
+		},
+		{
+			name:    "should fail if an item is missing its price",
+			args:    args{data: []byte(`{"result": {"tickers": [{"base": "BTC", "ts": 1624233600000}]}}`)},
+			wantErr: true,
+		},
+		{
+			name:    "should fail if a price cannot be parsed",
+			args:    args{data: []byte(`{"result": {"tickers": [{"base": "BTC", "last": "invalid", "ts": 1624233600000}]}}`)},
+			wantErr: true,
+		},
+		{
+			name:    "should fail if a time cannot be parsed",
+			args:    args{data: []byte(`{"result": {"tickers": [{"base": "BTC", "last": 1, "ts": "invalid"}]}}`)},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := exchangeConfig
+			if tt.cfg != nil {
+				cfg = tt.cfg(cfg)
+			}
+			p, err := rest.NewProvider(cfg, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := p.ParseQuotes(context.Background(), tt.args.data, tt.args.symbols...)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			if assert.Len(t, got, len(tt.want)) {
+				for i, want := range tt.want {
+					assert.Equal(t, want.Symbol, got[i].Symbol)
+					assert.True(t, want.Time.Equal(got[i].Time), "%s: want %s, got %s", want.Symbol, want.Time, got[i].Time)
+					assert.True(t, want.USD.Equal(got[i].USD), "%s: want %s, got %s", want.Symbol, want.USD, got[i].USD)
+				}
+			}
+		})
+	}
+}
+
+func TestProvider_QueryLatest(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Api-Key") != "secret" || r.URL.Query().Get("quote") != "USD" || r.Header.Get("X-Client") != "invest-source" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_, _ = w.Write([]byte(`{"result": {}}`))
+	}))
+	defer srv.Close()
+
+	cfg := exchangeConfig
+	cfg.URL = srv.URL
+	cfg.Query = []rest.Param{{Name: "quote", Value: "USD"}}
+	cfg.Headers = map[string]string{"X-Client": "invest-source"}
+	cfg.SecretKey, cfg.SecretHeader = "ExchangeApiKey", "X-Api-Key"
+
+	p, err := rest.NewProvider(cfg, "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := p.QueryLatest(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, []byte(`{"result": {}}`), got)
+
+	p, err = rest.NewProvider(cfg, "wrong-secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = p.QueryLatest(context.Background())
+	assert.Error(t, err)
+}
+
+func TestConfig_viper(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("apiKey") != "key" || r.URL.Query().Get("quoteCurrency") != "USD" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		_, _ = w.Write([]byte(`{"result": {}}`))
+	}))
+	defer srv.Close()
+
+	v := viper.New()
+	v.SetConfigType("yaml")
+	if err := v.ReadConfig(strings.NewReader(`
+REST:
+  URL: ` + srv.URL + `
+  Query:
+    - Name: quoteCurrency
+      Value: USD
+  SecretKey: ExchangeApiKey
+  SecretQuery: apiKey
+  Quotes: $.result[*]
+  Symbol: $.base
+  Price: $.last
+  Time: $.ts
+`)); err != nil {
+		t.Fatal(err)
+	}
+	var cfg rest.Config
+	if err := v.UnmarshalKey("REST", &cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := rest.NewProvider(cfg, "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = p.QueryLatest(context.Background())
+	assert.NoError(t, err, "query param names should keep their case through viper")
+}
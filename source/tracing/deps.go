@@ -0,0 +1,15 @@
+package tracing
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+var (
+	// NewExporter creates the exporter sending spans to the OTLP/HTTP endpoint URL. Override this for unit tests.
+	NewExporter = func(ctx context.Context, endpoint string) (sdktrace.SpanExporter, error) {
+		return otlptracehttp.New(ctx, otlptracehttp.WithEndpointURL(endpoint))
+	}
+)
@@ -0,0 +1,10 @@
+package tracing
+
+import (
+	"net/http"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+)
+
+// HTTPClient sends the providers' requests, tracing each as a client span parented to the span in the request's context, and recorded by that span's tracer provider.
+var HTTPClient = &http.Client{Transport: otelhttp.NewTransport(http.DefaultTransport)}
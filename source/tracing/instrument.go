@@ -0,0 +1,94 @@
+package tracing
+
+import (
+	"context"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/benjohns1/invest-source/app"
+)
+
+// Span attribute keys.
+const (
+	AttrProvider = attribute.Key("provider.name")
+	AttrCache    = attribute.Key("cache.name")
+	AttrCacheHit = attribute.Key("cache.hit")
+	AttrDate     = attribute.Key("cache.date")
+	AttrEntries  = attribute.Key("cache.entries")
+	AttrBytes    = attribute.Key("data.bytes")
+	AttrSymbols  = attribute.Key("quote.symbols")
+	AttrQuotes   = attribute.Key("quote.count")
+)
+
+// Provider traces the provider's queries, parenting the spans of the HTTP requests they make, and the parsing of their data, labelled with its name.
+func (t *Tracing) Provider(name string, p app.Provider) app.Provider {
+	return provider{Provider: p, name: name, t: t}
+}
+
+type provider struct {
+	app.Provider
+	name string
+	t    *Tracing
+}
+
+func (p provider) QueryLatest(ctx context.Context) ([]byte, error) {
+	ctx, span := p.t.start(ctx, "provider.QueryLatest", trace.SpanKindInternal, AttrProvider.String(p.name))
+	data, err := p.Provider.QueryLatest(ctx)
+	span.SetAttributes(AttrBytes.Int(len(data)))
+	return data, end(span, err)
+}
+
+func (p provider) ParseQuotes(ctx context.Context, data []byte, symbols ...string) ([]app.Quote, error) {
+	ctx, span := p.t.start(ctx, "provider.ParseQuotes", trace.SpanKindInternal, AttrProvider.String(p.name), AttrBytes.Int(len(data)), AttrSymbols.Int(len(symbols)))
+	quotes, err := p.Provider.ParseQuotes(ctx, data, symbols...)
+	span.SetAttributes(AttrQuotes.Int(len(quotes)))
+	return quotes, end(span, err)
+}
+
+// Cache traces the cache's reads and writes, labelled with its name.
+func (t *Tracing) Cache(name string, c app.DatedCache) app.DatedCache {
+	return cache{DatedCache: c, name: name, t: t}
+}
+
+type cache struct {
+	app.DatedCache
+	name string
+	t    *Tracing
+}
+
+func (c cache) ReadCurrent(ctx context.Context) ([]byte, error) {
+	ctx, span := c.t.start(ctx, "cache.ReadCurrent", trace.SpanKindClient, AttrCache.String(c.name))
+	data, err := c.DatedCache.ReadCurrent(ctx)
+	span.SetAttributes(AttrCacheHit.Bool(data != nil), AttrBytes.Int(len(data)))
+	return data, end(span, err)
+}
+
+func (c cache) ReadDate(ctx context.Context, date time.Time) ([]byte, error) {
+	ctx, span := c.t.start(ctx, "cache.ReadDate", trace.SpanKindClient, AttrCache.String(c.name), AttrDate.String(date.Format(app.DateFormat)))
+	data, err := c.DatedCache.ReadDate(ctx, date)
+	span.SetAttributes(AttrCacheHit.Bool(data != nil), AttrBytes.Int(len(data)))
+	return data, end(span, err)
+}
+
+func (c cache) ReadSince(ctx context.Context, since time.Time) ([][]byte, error) {
+	ctx, span := c.t.start(ctx, "cache.ReadSince", trace.SpanKindClient, AttrCache.String(c.name), AttrDate.String(since.Format(app.DateFormat)))
+	set, err := c.DatedCache.ReadSince(ctx, since)
+	n := 0
+	for _, data := range set {
+		n += len(data)
+	}
+	span.SetAttributes(AttrEntries.Int(len(set)), AttrBytes.Int(n))
+	return set, end(span, err)
+}
+
+func (c cache) WriteCurrent(ctx context.Context, data []byte) error {
+	ctx, span := c.t.start(ctx, "cache.WriteCurrent", trace.SpanKindClient, AttrCache.String(c.name), AttrBytes.Int(len(data)))
+	return end(span, c.DatedCache.WriteCurrent(ctx, data))
+}
+
+func (c cache) WriteDate(ctx context.Context, date time.Time, data []byte) error {
+	ctx, span := c.t.start(ctx, "cache.WriteDate", trace.SpanKindClient, AttrCache.String(c.name), AttrDate.String(date.Format(app.DateFormat)), AttrBytes.Int(len(data)))
+	return end(span, c.DatedCache.WriteDate(ctx, date, data))
+}
@@ -0,0 +1,77 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+const instrumentation = "github.com/benjohns1/invest-source/tracing"
+
+// Tracing traces the app use-cases, and the provider and cache calls they make, as OpenTelemetry spans, parented to the span in the context each call is passed.
+type Tracing struct {
+	tracer trace.Tracer
+	sdk    *sdktrace.TracerProvider
+}
+
+// New creates tracing that exports the service's spans to the OTLP/HTTP endpoint URL, e.g. http://localhost:4318, or a no-op tracing if the endpoint is empty.
+func New(ctx context.Context, endpoint, service string) (*Tracing, error) {
+	if endpoint == "" {
+		return &Tracing{tracer: noop.NewTracerProvider().Tracer(instrumentation)}, nil
+	}
+	if u, err := url.Parse(endpoint); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("tracing endpoint must be an http or https URL, got '%s'", endpoint)
+	}
+	exporter, err := NewExporter(ctx, endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("error creating OTLP exporter: %v", err)
+	}
+	sdk := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName(service))),
+	)
+	return &Tracing{tracer: sdk.Tracer(instrumentation), sdk: sdk}, nil
+}
+
+// Run traces a use-case run, recording its error, and passes run the context of its span, to parent the spans of the provider and cache calls made with it.
+func (t *Tracing) Run(ctx context.Context, usecase string, run func(ctx context.Context) error) error {
+	ctx, span := t.tracer.Start(ctx, usecase)
+	return end(span, run(ctx))
+}
+
+// Flush exports the spans ended so far, e.g. before a lambda is frozen between requests.
+func (t *Tracing) Flush(ctx context.Context) error {
+	if t.sdk == nil {
+		return nil
+	}
+	return t.sdk.ForceFlush(ctx)
+}
+
+// Shutdown exports the remaining spans and stops the exporter.
+func (t *Tracing) Shutdown(ctx context.Context) error {
+	if t.sdk == nil {
+		return nil
+	}
+	return t.sdk.Shutdown(ctx)
+}
+
+func (t *Tracing) start(ctx context.Context, name string, kind trace.SpanKind, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return t.tracer.Start(ctx, name, trace.WithSpanKind(kind), trace.WithAttributes(attrs...))
+}
+
+func end(span trace.Span, err error) error {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+	return err
+}
@@ -0,0 +1,232 @@
+package tracing_test
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/benjohns1/invest-source/app"
+	"github.com/benjohns1/invest-source/tracing"
+)
+
+// httpProvider queries its URL with the traced HTTPClient, as the providers do.
+type httpProvider struct {
+	url string
+}
+
+func (p httpProvider) QueryLatest(ctx context.Context) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", p.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := tracing.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// ParseQuotes returns a quote per symbol, for a span's quote count.
+func (p httpProvider) ParseQuotes(_ context.Context, _ []byte, symbols ...string) ([]app.Quote, error) {
+	quotes := make([]app.Quote, len(symbols))
+	for i, symbol := range symbols {
+		quotes[i] = app.Quote{Symbol: symbol}
+	}
+	return quotes, nil
+}
+
+// daysCache holds data by date, implementing only the reads the tests trace.
+type daysCache struct {
+	app.DatedCache
+	days map[string][]byte
+}
+
+func (c daysCache) ReadDate(_ context.Context, date time.Time) ([]byte, error) {
+	return c.days[date.Format(app.DateFormat)], nil
+}
+
+func (c daysCache) ReadSince(context.Context, time.Time) ([][]byte, error) {
+	set := make([][]byte, 0, len(c.days))
+	for _, data := range c.days {
+		set = append(set, data)
+	}
+	return set, nil
+}
+
+// newTracing creates tracing that exports to memory.
+func newTracing(t *testing.T) (*tracing.Tracing, *tracetest.InMemoryExporter) {
+	exporter := tracetest.NewInMemoryExporter()
+	orig := tracing.NewExporter
+	tracing.NewExporter = func(context.Context, string) (sdktrace.SpanExporter, error) { return exporter, nil }
+	t.Cleanup(func() { tracing.NewExporter = orig })
+
+	tr, err := tracing.New(context.Background(), "http://localhost:4318", "invest-source")
+	assert.NoError(t, err)
+	return tr, exporter
+}
+
+// flushed returns the spans exported so far, by name.
+func flushed(t *testing.T, tr *tracing.Tracing, exporter *tracetest.InMemoryExporter) map[string]tracetest.SpanStub {
+	assert.NoError(t, tr.Flush(context.Background()))
+	byName := make(map[string]tracetest.SpanStub)
+	for _, s := range exporter.GetSpans() {
+		byName[s.Name] = s
+	}
+	return byName
+}
+
+func attrs(kvs []attribute.KeyValue) map[attribute.Key]attribute.Value {
+	m := make(map[attribute.Key]attribute.Value, len(kvs))
+	for _, kv := range kvs {
+		m[kv.Key] = kv.Value
+	}
+	return m
+}
+
+func TestTracing_Provider(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/down" {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		_, _ = w.Write([]byte("12345"))
+	}))
+	defer srv.Close()
+	tr, exporter := newTracing(t)
+
+	p := tr.Provider("coingecko", httpProvider{url: srv.URL + "/latest"})
+	assert.NoError(t, tr.Run(context.Background(), "cache-daily-source-data", func(ctx context.Context) error {
+		data, err := p.QueryLatest(ctx)
+		if err != nil {
+			return err
+		}
+		_, err = p.ParseQuotes(ctx, data, "BTC", "ETH")
+		return err
+	}))
+
+	spans := flushed(t, tr, exporter)
+	assert.Len(t, spans, 4)
+	run, query, request, parse := spans["cache-daily-source-data"], spans["provider.QueryLatest"], spans["HTTP GET"], spans["provider.ParseQuotes"]
+	assert.Equal(t, run.SpanContext.SpanID(), query.Parent.SpanID(), "provider spans should be parented to the run passed in the context")
+	assert.Equal(t, query.SpanContext.SpanID(), request.Parent.SpanID(), "each HTTP request should be parented to the query making it")
+	assert.Equal(t, trace.SpanKindClient, request.SpanKind)
+	assert.Equal(t, attribute.IntValue(5), attrs(query.Attributes)[tracing.AttrBytes])
+	assert.Equal(t, attribute.StringValue("coingecko"), attrs(parse.Attributes)[tracing.AttrProvider])
+	assert.Equal(t, attribute.IntValue(2), attrs(parse.Attributes)[tracing.AttrQuotes])
+
+	exporter.Reset()
+	down := tr.Provider("coinmarketcap", httpProvider{url: srv.URL + "/down"})
+	assert.Error(t, tr.Run(context.Background(), "cache-daily-source-data", func(ctx context.Context) error {
+		_, err := down.QueryLatest(ctx)
+		return err
+	}))
+
+	spans = flushed(t, tr, exporter)
+	assert.Equal(t, codes.Error, spans["cache-daily-source-data"].Status.Code)
+	assert.Equal(t, codes.Error, spans["provider.QueryLatest"].Status.Code)
+	assert.Equal(t, "unexpected status 502 Bad Gateway", spans["provider.QueryLatest"].Status.Description)
+	assert.Equal(t, spans["provider.QueryLatest"].SpanContext.SpanID(), spans["HTTP GET"].Parent.SpanID())
+}
+
+func TestTracing_Cache(t *testing.T) {
+	tr, exporter := newTracing(t)
+	c := tr.Cache("keyval", daysCache{days: map[string][]byte{"2021-01-01": []byte("day1"), "2021-01-02": []byte("day02")}})
+
+	_, err := c.ReadSince(context.Background(), time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC))
+	assert.NoError(t, err)
+	_, err = c.ReadDate(context.Background(), time.Date(2021, 1, 3, 0, 0, 0, 0, time.UTC))
+	assert.NoError(t, err)
+
+	spans := flushed(t, tr, exporter)
+	readSince := spans["cache.ReadSince"]
+	assert.False(t, readSince.Parent.IsValid(), "spans without a traced context should be roots")
+	assert.Equal(t, map[attribute.Key]attribute.Value{
+		tracing.AttrCache:   attribute.StringValue("keyval"),
+		tracing.AttrDate:    attribute.StringValue("2021-01-01"),
+		tracing.AttrEntries: attribute.IntValue(2),
+		tracing.AttrBytes:   attribute.IntValue(9),
+	}, attrs(readSince.Attributes))
+	assert.Equal(t, attribute.BoolValue(false), attrs(spans["cache.ReadDate"].Attributes)[tracing.AttrCacheHit])
+}
+
+func TestTracing_Run_concurrent(t *testing.T) {
+	tr, exporter := newTracing(t)
+	c := tr.Cache("file", daysCache{days: map[string][]byte{}})
+
+	// both runs have started before either reads, so a run-wide parent would be shared
+	var started, done sync.WaitGroup
+	started.Add(2)
+	for _, usecase := range []string{"cache-daily-source-data", "output-daily-quotes"} {
+		done.Add(1)
+		go func() {
+			defer done.Done()
+			assert.NoError(t, tr.Run(context.Background(), usecase, func(ctx context.Context) error {
+				started.Done()
+				started.Wait()
+				_, err := c.ReadDate(ctx, time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC))
+				return err
+			}))
+		}()
+	}
+	done.Wait()
+
+	assert.NoError(t, tr.Flush(context.Background()))
+	runs := make(map[trace.SpanID]string)
+	var reads []tracetest.SpanStub
+	for _, s := range exporter.GetSpans() {
+		if s.Name == "cache.ReadDate" {
+			reads = append(reads, s)
+			continue
+		}
+		runs[s.SpanContext.SpanID()] = s.Name
+	}
+	assert.Len(t, runs, 2)
+	assert.Len(t, reads, 2)
+	parents := make(map[string]bool)
+	for _, s := range reads {
+		parents[runs[s.Parent.SpanID()]] = true
+	}
+	assert.Equal(t, map[string]bool{"cache-daily-source-data": true, "output-daily-quotes": true}, parents, "each read should be parented to its own run")
+}
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name     string
+		endpoint string
+		wantErr  bool
+	}{
+		{name: "should create no-op tracing without an endpoint", endpoint: ""},
+		{name: "should create tracing with an http endpoint", endpoint: "http://localhost:4318"},
+		{name: "should create tracing with an https endpoint", endpoint: "https://otlp.example.com"},
+		{name: "should fail with a host without a scheme", endpoint: "localhost:4318", wantErr: true},
+		{name: "should fail with a grpc scheme", endpoint: "grpc://localhost:4317", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tr, err := tracing.New(context.Background(), tt.endpoint, "invest-source")
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.NoError(t, tr.Run(context.Background(), "run", func(context.Context) error { return nil }))
+			assert.NoError(t, tr.Shutdown(context.Background()))
+		})
+	}
+}
@@ -0,0 +1,99 @@
+package filesystem
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+)
+
+// AtomicFile is written to a temporary file beside its path, which only replaces the path once committed, so readers never see a partial file.
+type AtomicFile struct {
+	*os.File
+	path string
+	done bool
+}
+
+// CreateAtomic creates a temporary file to be committed to the path, keeping the permissions of any file it replaces.
+func CreateAtomic(path string) (*AtomicFile, error) {
+	perm := os.FileMode(0644)
+	if info, err := os.Stat(path); err == nil {
+		perm = info.Mode().Perm()
+	}
+	f, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return nil, fmt.Errorf("error creating temporary file for '%s': %v", path, err)
+	}
+	if err := f.Chmod(perm); err != nil {
+		_ = f.Close()
+		_ = os.Remove(f.Name())
+		return nil, fmt.Errorf("error creating temporary file for '%s': %v", path, err)
+	}
+	return &AtomicFile{File: f, path: path}, nil
+}
+
+// Commit syncs the temporary file to disk, renames it over the path, and syncs the parent directory so the rename itself survives a crash.
+// The temporary file is removed if any step before the rename fails.
+func (f *AtomicFile) Commit() error {
+	if f.done {
+		return fmt.Errorf("file '%s' has already been committed or aborted", f.path)
+	}
+	f.done = true
+	if err := f.File.Sync(); err != nil {
+		_ = f.File.Close()
+		_ = os.Remove(f.File.Name())
+		return fmt.Errorf("error syncing file '%s': %v", f.path, err)
+	}
+	if err := f.File.Close(); err != nil {
+		_ = os.Remove(f.File.Name())
+		return fmt.Errorf("error closing file '%s': %v", f.path, err)
+	}
+	if err := os.Rename(f.File.Name(), f.path); err != nil {
+		_ = os.Remove(f.File.Name())
+		return fmt.Errorf("error replacing file '%s': %v", f.path, err)
+	}
+	if err := syncDir(filepath.Dir(f.path)); err != nil {
+		return fmt.Errorf("error syncing directory of file '%s': %v", f.path, err)
+	}
+	return nil
+}
+
+// syncDir flushes a directory's entries to disk. Windows can't sync directories, and persists renames without it.
+func syncDir(dir string) error {
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	if err := d.Sync(); err != nil {
+		_ = d.Close()
+		return err
+	}
+	return d.Close()
+}
+
+// Abort closes and removes the temporary file, leaving the path untouched. It does nothing once committed.
+func (f *AtomicFile) Abort() error {
+	if f.done {
+		return nil
+	}
+	f.done = true
+	_ = f.File.Close()
+	return os.Remove(f.File.Name())
+}
+
+// WriteFileAtomic writes data to the path through an AtomicFile.
+func WriteFileAtomic(path string, data []byte) error {
+	f, err := CreateAtomic(path)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		_ = f.Abort()
+		return fmt.Errorf("error writing file '%s': %v", path, err)
+	}
+	return f.Commit()
+}
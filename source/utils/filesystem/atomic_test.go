@@ -0,0 +1,68 @@
+package filesystem_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/benjohns1/invest-source/utils/filesystem"
+)
+
+func TestAtomicFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "out.csv")
+	if err := ioutil.WriteFile(path, []byte("old"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	aborted, err := filesystem.CreateAtomic(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = aborted.Write([]byte("partial"))
+	assert.NoError(t, aborted.Abort())
+	assertFile(t, path, "old")
+
+	f, err := filesystem.CreateAtomic(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = f.Write([]byte("new"))
+	assert.NoError(t, err)
+	assertFile(t, path, "old")
+	assert.NoError(t, f.Commit())
+	assertFile(t, path, "new")
+	assert.NoError(t, f.Abort(), "abort after commit should do nothing")
+	assert.Error(t, f.Commit())
+	assertFile(t, path, "new")
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm(), "should keep the replaced file's permissions")
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Len(t, entries, 1, "temporary files should be removed")
+}
+
+func TestWriteFileAtomic(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "new.json")
+	assert.NoError(t, filesystem.WriteFileAtomic(path, []byte("{}")))
+	assertFile(t, path, "{}")
+	assert.Error(t, filesystem.WriteFileAtomic(filepath.Join(t.TempDir(), "missing", "new.json"), []byte("{}")))
+}
+
+func assertFile(t *testing.T, path string, want string) {
+	t.Helper()
+	got, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, want, string(got))
+}
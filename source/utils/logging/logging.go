@@ -0,0 +1,43 @@
+package logging
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// Log record formats.
+const (
+	// FormatText writes key=value records, for reading in a terminal.
+	FormatText = "text"
+	// FormatJSON writes a JSON object per record, for searching in log aggregators such as CloudWatch.
+	FormatJSON = "json"
+)
+
+// New creates a structured logger writing records at or above the level (debug, info, warn or error, default info) in the format (default FormatText).
+func New(w io.Writer, format, level string) (*slog.Logger, error) {
+	var l slog.Level
+	if level = strings.TrimSpace(level); level != "" {
+		if err := l.UnmarshalText([]byte(level)); err != nil {
+			return nil, fmt.Errorf("unknown log level '%s', must be one of debug, info, warn or error", level)
+		}
+	}
+	opts := &slog.HandlerOptions{Level: l}
+
+	switch format = strings.ToLower(strings.TrimSpace(format)); format {
+	case FormatText, "":
+		return slog.New(slog.NewTextHandler(w, opts)), nil
+	case FormatJSON:
+		return slog.New(slog.NewJSONHandler(w, opts)), nil
+	default:
+		return nil, fmt.Errorf("unknown log format '%s', must be one of %s or %s", format, FormatText, FormatJSON)
+	}
+}
+
+// Fatal logs the message at error level and exits, for errors a command can't continue from.
+func Fatal(l *slog.Logger, msg string, args ...any) {
+	l.Error(msg, args...)
+	os.Exit(1)
+}
@@ -0,0 +1,54 @@
+package logging_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/benjohns1/invest-source/utils/logging"
+)
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name    string
+		format  string
+		level   string
+		want    []string
+		wantErr bool
+	}{
+		{name: "should default to text at info", want: []string{"level=INFO msg=info bytes=3", "level=WARN msg=warn bytes=3"}},
+		{name: "should log debug records", format: "TEXT", level: "debug", want: []string{"level=DEBUG msg=debug bytes=3", "level=INFO msg=info bytes=3", "level=WARN msg=warn bytes=3"}},
+		{name: "should log json", format: "json", level: "warn", want: []string{`"level":"WARN","msg":"warn","bytes":3`}},
+		{name: "should fail with an unknown format", format: "xml", wantErr: true},
+		{name: "should fail with an unknown level", level: "verbose", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			l, err := logging.New(&buf, tt.format, tt.level)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			l.Debug("debug", "bytes", 3)
+			l.Info("info", "bytes", 3)
+			l.Warn("warn", "bytes", 3)
+
+			lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+			if assert.Len(t, lines, len(tt.want)) {
+				for i, want := range tt.want {
+					assert.Contains(t, lines[i], want)
+					if tt.format == logging.FormatJSON {
+						assert.True(t, json.Valid([]byte(lines[i])))
+					}
+				}
+			}
+		})
+	}
+}
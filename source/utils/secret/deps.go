@@ -0,0 +1,32 @@
+package secret
+
+import (
+	"os"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+)
+
+var (
+	// ReadFile reads a secret file.
+	ReadFile = os.ReadFile
+
+	// NewSecretsManagerClient creates a Secrets Manager client, using the endpoint if set. Override this for unit tests.
+	NewSecretsManagerClient = func(cfg aws.Config, endpoint string) SecretsManagerAPI {
+		return secretsmanager.NewFromConfig(cfg, func(o *secretsmanager.Options) {
+			if endpoint != "" {
+				o.BaseEndpoint = aws.String(endpoint)
+			}
+		})
+	}
+
+	// NewParameterStoreClient creates an SSM client, using the endpoint if set. Override this for unit tests.
+	NewParameterStoreClient = func(cfg aws.Config, endpoint string) ParameterStoreAPI {
+		return ssm.NewFromConfig(cfg, func(o *ssm.Options) {
+			if endpoint != "" {
+				o.BaseEndpoint = aws.String(endpoint)
+			}
+		})
+	}
+)
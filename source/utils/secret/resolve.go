@@ -0,0 +1,134 @@
+package secret
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+)
+
+// SecretsManagerAPI is the part of the AWS Secrets Manager client used to read secrets.
+type SecretsManagerAPI interface {
+	GetSecretValue(ctx context.Context, params *secretsmanager.GetSecretValueInput, optFns ...func(*secretsmanager.Options)) (*secretsmanager.GetSecretValueOutput, error)
+}
+
+// ParameterStoreAPI is the part of the AWS SSM client used to read Parameter Store parameters.
+type ParameterStoreAPI interface {
+	GetParameter(ctx context.Context, params *ssm.GetParameterInput, optFns ...func(*ssm.Options)) (*ssm.GetParameterOutput, error)
+}
+
+// Resolve returns the secret a reference points to, with surrounding whitespace trimmed:
+//   - file:///path/to/file reads a file
+//   - secretsmanager://secret-id reads an AWS Secrets Manager secret by name or ARN, add ?key=field to read a field of a JSON secret
+//   - ssm:///parameter/name reads an AWS SSM Parameter Store parameter, decrypting a SecureString
+//
+// The AWS schemes take optional region and endpoint query params, e.g. ?endpoint=http://localhost:4566 for localstack. Any other value, without a scheme, is the secret itself.
+func Resolve(ctx context.Context, ref string) (String, error) {
+	scheme, rest, ok := strings.Cut(ref, "://")
+	if !ok {
+		return String(ref), nil
+	}
+	name, rawQuery, _ := strings.Cut(rest, "?")
+	q, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return "", fmt.Errorf("invalid %s secret query '%s': %v", scheme, rawQuery, err)
+	}
+	if name == "" {
+		return "", fmt.Errorf("%s secret reference must have a name", scheme)
+	}
+
+	var value string
+	switch scheme {
+	case "file":
+		value, err = readFile(name)
+	case "secretsmanager":
+		value, err = readSecretsManager(ctx, name, q)
+	case "ssm":
+		value, err = readParameter(ctx, name, q)
+	default:
+		return "", fmt.Errorf("unknown secret scheme '%s', must be one of file, secretsmanager or ssm", scheme)
+	}
+	if err != nil {
+		return "", err
+	}
+	if value = strings.TrimSpace(value); value == "" {
+		return "", fmt.Errorf("%s secret '%s' is empty", scheme, name)
+	}
+	return String(value), nil
+}
+
+func readFile(path string) (string, error) {
+	data, err := ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("error reading secret file: %v", err)
+	}
+	return string(data), nil
+}
+
+func readSecretsManager(ctx context.Context, id string, q url.Values) (string, error) {
+	cfg, err := loadAWSConfig(ctx, q)
+	if err != nil {
+		return "", err
+	}
+	out, err := NewSecretsManagerClient(cfg, q.Get("endpoint")).GetSecretValue(ctx, &secretsmanager.GetSecretValueInput{SecretId: aws.String(id)})
+	if err != nil {
+		return "", fmt.Errorf("error reading Secrets Manager secret '%s': %v", id, err)
+	}
+	value := string(out.SecretBinary)
+	if out.SecretString != nil {
+		value = *out.SecretString
+	}
+
+	key := q.Get("key")
+	if key == "" {
+		return value, nil
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal([]byte(value), &fields); err != nil {
+		// the error could quote the secret, so leave it out
+		return "", fmt.Errorf("Secrets Manager secret '%s' isn't a JSON object, can't read key '%s'", id, key)
+	}
+	field, ok := fields[key].(string)
+	if !ok {
+		return "", fmt.Errorf("Secrets Manager secret '%s' has no string key '%s'", id, key)
+	}
+	return field, nil
+}
+
+func readParameter(ctx context.Context, name string, q url.Values) (string, error) {
+	cfg, err := loadAWSConfig(ctx, q)
+	if err != nil {
+		return "", err
+	}
+	out, err := NewParameterStoreClient(cfg, q.Get("endpoint")).GetParameter(ctx, &ssm.GetParameterInput{Name: aws.String(name), WithDecryption: aws.Bool(true)})
+	if err != nil {
+		return "", fmt.Errorf("error reading SSM parameter '%s': %v", name, err)
+	}
+	if out.Parameter == nil || out.Parameter.Value == nil {
+		return "", nil
+	}
+	return *out.Parameter.Value, nil
+}
+
+func loadAWSConfig(ctx context.Context, q url.Values) (aws.Config, error) {
+	var loadOpts []func(*config.LoadOptions) error
+	region := q.Get("region")
+	if region == "" && q.Get("endpoint") != "" && os.Getenv("AWS_REGION") == "" {
+		region = "us-east-1"
+	}
+	if region != "" {
+		loadOpts = append(loadOpts, config.WithRegion(region))
+	}
+	cfg, err := config.LoadDefaultConfig(ctx, loadOpts...)
+	if err != nil {
+		return aws.Config{}, fmt.Errorf("error loading AWS config: %v", err)
+	}
+	return cfg, nil
+}
@@ -0,0 +1,131 @@
+package secret_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	ssmTypes "github.com/aws/aws-sdk-go-v2/service/ssm/types"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/benjohns1/invest-source/utils/secret"
+)
+
+type secretsManager map[string]string
+
+func (s secretsManager) GetSecretValue(_ context.Context, params *secretsmanager.GetSecretValueInput, _ ...func(*secretsmanager.Options)) (*secretsmanager.GetSecretValueOutput, error) {
+	value, ok := s[*params.SecretId]
+	if !ok {
+		return nil, errors.New("ResourceNotFoundException")
+	}
+	return &secretsmanager.GetSecretValueOutput{SecretString: aws.String(value)}, nil
+}
+
+type parameterStore map[string]string
+
+func (p parameterStore) GetParameter(_ context.Context, params *ssm.GetParameterInput, _ ...func(*ssm.Options)) (*ssm.GetParameterOutput, error) {
+	value, ok := p[*params.Name]
+	if !ok || !*params.WithDecryption {
+		return nil, errors.New("ParameterNotFound")
+	}
+	return &ssm.GetParameterOutput{Parameter: &ssmTypes.Parameter{Value: aws.String(value)}}, nil
+}
+
+func TestResolve(t *testing.T) {
+	dir := t.TempDir()
+	keyFile := filepath.Join(dir, "api-key")
+	if err := os.WriteFile(keyFile, []byte("file-key\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	newSecretsManager, newParameterStore := secret.NewSecretsManagerClient, secret.NewParameterStoreClient
+	defer func() {
+		secret.NewSecretsManagerClient, secret.NewParameterStoreClient = newSecretsManager, newParameterStore
+	}()
+	var endpoint string
+	secret.NewSecretsManagerClient = func(_ aws.Config, e string) secret.SecretsManagerAPI {
+		endpoint = e
+		return secretsManager{
+			"cmc": "sm-key",
+			"arn:aws:secretsmanager:us-west-2:000000000000:secret:prod/keys": `{"coinmarketcap":"json-key","count":1}`,
+			"blank": " ",
+		}
+	}
+	secret.NewParameterStoreClient = func(_ aws.Config, e string) secret.ParameterStoreAPI {
+		endpoint = e
+		return parameterStore{"/prod/cmc": "ssm-key", "plain": "plain-key"}
+	}
+
+	tests := []struct {
+		name         string
+		ref          string
+		want         string
+		wantEndpoint string
+		wantErr      bool
+	}{
+		{name: "should return a plain value as the secret", ref: "b54bcf4d-1bca", want: "b54bcf4d-1bca"},
+		{name: "should return an empty value", ref: "", want: ""},
+		{name: "should read and trim a file", ref: "file://" + keyFile, want: "file-key"},
+		{name: "should fail reading a missing file", ref: "file://" + filepath.Join(dir, "missing"), wantErr: true},
+		{name: "should read a Secrets Manager secret", ref: "secretsmanager://cmc?region=us-west-2", want: "sm-key"},
+		{name: "should read a key of a JSON Secrets Manager secret by ARN", ref: "secretsmanager://arn:aws:secretsmanager:us-west-2:000000000000:secret:prod/keys?key=coinmarketcap&endpoint=http://localhost:4566", want: "json-key", wantEndpoint: "http://localhost:4566"},
+		{name: "should fail reading a non-string key", ref: "secretsmanager://arn:aws:secretsmanager:us-west-2:000000000000:secret:prod/keys?key=count&region=us-west-2", wantErr: true},
+		{name: "should fail reading a key of a plain secret", ref: "secretsmanager://cmc?key=coinmarketcap&region=us-west-2", wantErr: true},
+		{name: "should fail reading a missing secret", ref: "secretsmanager://missing?region=us-west-2", wantErr: true},
+		{name: "should fail reading a blank secret", ref: "secretsmanager://blank?region=us-west-2", wantErr: true},
+		{name: "should read a decrypted SSM parameter by path", ref: "ssm:///prod/cmc?region=us-west-2", want: "ssm-key"},
+		{name: "should read an SSM parameter by name", ref: "ssm://plain?region=us-west-2", want: "plain-key"},
+		{name: "should fail without a name", ref: "ssm://?region=us-west-2", wantErr: true},
+		{name: "should fail with an unknown scheme", ref: "vault://cmc", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			endpoint = ""
+			got, err := secret.Resolve(context.Background(), tt.ref)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got.Reveal())
+			assert.Equal(t, tt.wantEndpoint, endpoint)
+		})
+	}
+}
+
+// TestResolve_localstack runs against localstack when the AWSEndpoint environment variable is set, e.g. AWSEndpoint=http://localhost:4566 after running `mage awsLocal`.
+func TestResolve_localstack(t *testing.T) {
+	endpoint := os.Getenv("AWSEndpoint")
+	if endpoint == "" {
+		t.Skip("AWSEndpoint not set, skipping localstack secret tests")
+	}
+	ctx := context.Background()
+	cfg, err := config.LoadDefaultConfig(ctx, config.WithRegion("us-west-2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	name := fmt.Sprintf("secrettest-%d", time.Now().UnixNano())
+
+	sm := secretsmanager.NewFromConfig(cfg, func(o *secretsmanager.Options) { o.BaseEndpoint = aws.String(endpoint) })
+	if _, err := sm.CreateSecret(ctx, &secretsmanager.CreateSecretInput{Name: aws.String(name), SecretString: aws.String(`{"apiKey":"sm-key"}`)}); err != nil {
+		t.Fatal(err)
+	}
+	got, err := secret.Resolve(ctx, fmt.Sprintf("secretsmanager://%s?key=apiKey&region=us-west-2&endpoint=%s", name, endpoint))
+	assert.NoError(t, err)
+	assert.Equal(t, "sm-key", got.Reveal())
+
+	ps := ssm.NewFromConfig(cfg, func(o *ssm.Options) { o.BaseEndpoint = aws.String(endpoint) })
+	if _, err := ps.PutParameter(ctx, &ssm.PutParameterInput{Name: aws.String("/" + name), Value: aws.String("ssm-key"), Type: ssmTypes.ParameterTypeSecureString}); err != nil {
+		t.Fatal(err)
+	}
+	got, err = secret.Resolve(ctx, fmt.Sprintf("ssm:///%s?region=us-west-2&endpoint=%s", name, endpoint))
+	assert.NoError(t, err)
+	assert.Equal(t, "ssm-key", got.Reveal())
+}
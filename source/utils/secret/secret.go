@@ -0,0 +1,41 @@
+package secret
+
+import (
+	"encoding/json"
+	"log/slog"
+	"strconv"
+)
+
+// Redacted replaces a secret's value wherever it's formatted, logged or marshaled.
+const Redacted = "[REDACTED]"
+
+// String is a secret config value, such as an API key. It's redacted when formatted with fmt, logged with slog or marshaled to JSON, so a config struct holding it can be logged safely. Use Reveal to read its value.
+type String string
+
+// Reveal returns the secret's value.
+func (s String) Reveal() string {
+	return string(s)
+}
+
+// String returns Redacted, or an empty string if the secret isn't set.
+func (s String) String() string {
+	if s == "" {
+		return ""
+	}
+	return Redacted
+}
+
+// GoString redacts the secret when formatted with %#v.
+func (s String) GoString() string {
+	return strconv.Quote(s.String())
+}
+
+// LogValue redacts the secret when logged with slog.
+func (s String) LogValue() slog.Value {
+	return slog.StringValue(s.String())
+}
+
+// MarshalJSON redacts the secret when marshaled to JSON.
+func (s String) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.String())
+}
@@ -0,0 +1,45 @@
+package secret_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/benjohns1/invest-source/utils/secret"
+)
+
+type appConfig struct {
+	Name   string
+	APIKey secret.String
+}
+
+func TestString(t *testing.T) {
+	cfg := appConfig{Name: "cmc", APIKey: "my-api-key"}
+
+	for _, got := range []string{
+		fmt.Sprintf("%v", cfg),
+		fmt.Sprintf("%+v", cfg),
+		fmt.Sprintf("%#v", cfg),
+		fmt.Sprintf("%s", cfg.APIKey),
+		fmt.Sprintf("%q", cfg.APIKey),
+	} {
+		assert.NotContains(t, got, "my-api-key")
+		assert.Contains(t, got, secret.Redacted)
+	}
+	assert.Equal(t, `secret_test.appConfig{Name:"cmc", APIKey:"[REDACTED]"}`, fmt.Sprintf("%#v", cfg))
+
+	data, err := json.Marshal(cfg)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"Name":"cmc","APIKey":"[REDACTED]"}`, string(data))
+
+	var buf bytes.Buffer
+	slog.New(slog.NewTextHandler(&buf, nil)).Info("config", "apiKey", cfg.APIKey)
+	assert.Contains(t, buf.String(), "apiKey=[REDACTED]")
+
+	assert.Equal(t, "my-api-key", cfg.APIKey.Reveal())
+	assert.Equal(t, `""`, fmt.Sprintf("%#v", secret.String("")), "an unset secret should show it's empty")
+}